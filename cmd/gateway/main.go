@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -42,19 +43,55 @@ func main() {
 	// metrics
 	metricsRegistry := metrics.NewRegistry()
 
-	// policy store
+	// policy store and the rules selecting each client's policy
 	policyStore := config.NewPolicyStore()
+	rateLimitRules := config.DefaultRateLimitRules()
+	if cfg.RateLimitRulesFile != "" {
+		rr, err := config.LoadRateLimitRules(cfg.RateLimitRulesFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load rate limit rules")
+		}
+		rateLimitRules = rr
+	}
+	policyRules, err := service.NewPolicyResolver(rateLimitRules)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to compile rate limit rules")
+	}
+
+	// routes
+	routes := config.DefaultRoutes(cfg.DownstreamURL)
+	if cfg.RoutesFile != "" {
+		rc, err := config.LoadRoutes(cfg.RoutesFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load routes")
+		}
+		routes = rc
+	}
 
 	// handler
-	proxy := handler.NewProxyHandler(cfg.DownstreamURL, limSvc, metricsRegistry)
-	health := &handler.HealthHandler{}
+	proxy, err := handler.NewProxyHandler(routes, limSvc, metricsRegistry)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to build proxy")
+	}
+	health := &handler.HealthHandler{Clusters: proxy.Clusters(), Routes: proxy.Router()}
+
+	// background service discovery and upstream health checks, stopped on shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	for _, c := range proxy.Clusters() {
+		c.StartDiscovery(bgCtx, nil)
+		c.StartHealthChecks(bgCtx)
+	}
 	admin := handler.NewAdminHandler(policyStore)
+	canaries := handler.NewCanaryAdminHandler(proxy.Router())
 
-	// JWT auth (optional: only if JWT_SECRET is set)
-	var jwtMiddleware func(http.Handler) http.Handler
+	// JWT auth (optional: only if JWT_SECRET is set); valid tokens also identify
+	// callers to the rate limiter on every route
+	var jwtMiddleware, jwtIdentity func(http.Handler) http.Handler
 	if secret := os.Getenv("JWT_SECRET"); secret != "" {
 		issuer := os.Getenv("JWT_ISS")
 		jwtMiddleware = middleware.NewJWTMiddleware([]byte(secret), issuer)
+		jwtIdentity = middleware.NewJWTIdentity([]byte(secret), issuer)
 		log.Info().Msg("JWT authentication enabled")
 	}
 
@@ -63,29 +100,100 @@ func main() {
 	// Protect admin endpoints with JWT if enabled
 	if jwtMiddleware != nil {
 		mux.Handle("/admin/policies", jwtMiddleware(admin))
+		mux.Handle("/admin/canaries", jwtMiddleware(canaries))
 	} else {
 		mux.Handle("/admin/policies", admin)
+		mux.Handle("/admin/canaries", canaries)
 	}
 	mux.HandleFunc("/health", health.Liveness)
 	mux.HandleFunc("/ready", health.Readiness)
 	mux.HandleFunc("/status", health.Status)
 	mux.Handle("/", proxy)
 
-	// middleware chain
-	h := middleware.RequestID(mux)
-	h = middleware.Logging(h)
-	h = middleware.RateLimit(limSvc, metricsRegistry, policyStore)(h)
-	h = middleware.RequestSizeLimit(middleware.MaxRequestSize)(h)
+	// TLS termination (optional: only if TLS_LISTEN_ADDR is set)
+	var tlsCfg config.TLSConfig
+	if cfg.TLSConfigFile != "" {
+		if cfg.TLSListenAddr == "" {
+			log.Fatal().Str("file", cfg.TLSConfigFile).Msg("TLS_CONFIG_FILE is set but TLS_LISTEN_ADDR is not")
+		}
+		tc, err := config.LoadTLS(cfg.TLSConfigFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load tls config")
+		}
+		tlsCfg = tc
+	}
+	if cfg.TLSCertFile != "" {
+		tlsCfg.Certificates = append(tlsCfg.Certificates, config.CertificateConfig{CertFile: cfg.TLSCertFile, KeyFile: cfg.TLSKeyFile})
+	}
+	tlsCfg = tlsCfg.WithDefaults()
 
-	srv := &http.Server{Addr: cfg.ListenAddr, Handler: h}
+	// mutual TLS (optional: only if the TLS config has client_auth)
+	var clientAuth *service.ClientAuth
+	if cfg.TLSListenAddr != "" && tlsCfg.ClientAuth != nil {
+		clientAuth, err = service.NewClientAuth(*tlsCfg.ClientAuth)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load client ca bundles")
+		}
+		clientAuth.Watch(bgCtx, time.Duration(tlsCfg.ReloadIntervalMs)*time.Millisecond)
+		log.Info().Str("mode", tlsCfg.ClientAuth.Mode).Msg("client certificate authentication enabled")
+	}
+
+	// middleware chain
+	h := chain{
+		limiter:     limSvc,
+		metrics:     metricsRegistry,
+		policies:    policyStore,
+		rules:       policyRules,
+		routes:      proxy.Router(),
+		headers:     cfg.RateLimitHeaders,
+		apiKeys:     middleware.DefaultAPIKeys(),
+		jwtIdentity: jwtIdentity,
+		clientAuth:  clientAuth,
+		cors:        proxy.CORS,
+	}.wrap(mux)
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	if cfg.H2C {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	plain := h
+	redirect := cfg.TLSListenAddr != "" && tlsCfg.RedirectHTTP
+	if redirect {
+		_, port, _ := net.SplitHostPort(cfg.TLSListenAddr)
+		plain = middleware.HTTPSRedirect(port, "/health", "/ready", "/metrics")(h)
+	}
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: plain, Protocols: protocols}
+	servers := []*http.Server{srv}
 
 	go func() {
-		log.Info().Msgf("listening %s", cfg.ListenAddr)
+		log.Info().Bool("h2c", cfg.H2C).Bool("https_redirect", redirect).Msgf("listening %s", cfg.ListenAddr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("server failed")
 		}
 	}()
 
+	if cfg.TLSListenAddr != "" {
+		certs, err := service.NewCertStore(tlsCfg.Certificates, metricsRegistry)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load tls certificates")
+		}
+		certs.Watch(bgCtx, time.Duration(tlsCfg.ReloadIntervalMs)*time.Millisecond)
+		secure := h
+		if tlsCfg.HSTS != nil {
+			secure = middleware.HSTS(*tlsCfg.HSTS)(h)
+		}
+		tlsSrv := &http.Server{Addr: cfg.TLSListenAddr, Handler: secure, TLSConfig: service.ServerTLSConfig(tlsCfg, certs, clientAuth)}
+		servers = append(servers, tlsSrv)
+		go func() {
+			log.Info().Int("certificates", len(tlsCfg.Certificates)).Str("min_version", tlsCfg.MinVersion).Msgf("listening %s (tls)", cfg.TLSListenAddr)
+			if err := tlsSrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msg("tls server failed")
+			}
+		}()
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -93,8 +201,46 @@ func main() {
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.GracefulShutdownTimeout)*time.Second)
 	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal().Err(err).Msg("server shutdown failed")
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Fatal().Err(err).Msg("server shutdown failed")
+		}
 	}
 	log.Info().Msg("server exited")
 }
+
+// chain is the middleware wrapped around the gateway's mux. Optional parts may be nil.
+type chain struct {
+	limiter     *service.Limiter
+	metrics     *metrics.Registry
+	policies    config.PolicyStore
+	rules       *service.PolicyResolver
+	routes      *service.Router
+	headers     string
+	apiKeys     *middleware.APIKeyStore
+	jwtIdentity func(http.Handler) http.Handler
+	clientAuth  *service.ClientAuth
+	cors        func(http.Handler) http.Handler
+}
+
+// wrap applies the chain to h. Callers are identified before rate limiting by
+// the first of their client certificate, API key and JWT.
+func (c chain) wrap(h http.Handler) http.Handler {
+	h = middleware.RequestID(h)
+	h = middleware.Logging(h)
+	h = middleware.RateLimit(c.limiter, c.metrics, c.policies, c.rules, c.routes, c.headers)(h)
+	if c.jwtIdentity != nil {
+		h = c.jwtIdentity(h)
+	}
+	if c.apiKeys != nil {
+		h = middleware.NewAPIKeyMiddleware(c.apiKeys).Identify()(h)
+	}
+	if c.clientAuth != nil {
+		h = middleware.ClientCert(c.clientAuth)(h)
+	}
+	h = middleware.RequestSizeLimit(middleware.MaxRequestSize)(h)
+	if c.cors != nil {
+		h = c.cors(h)
+	}
+	return h
+}
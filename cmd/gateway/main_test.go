@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+	"api-gateway/internal/middleware"
+	"api-gateway/internal/repository"
+	"api-gateway/internal/service"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestChainIdentifiesCallersForRateLimitRules(t *testing.T) {
+	secret := []byte("test-secret")
+	rules, err := service.NewPolicyResolver(config.RateLimitRules{Rules: append(config.DefaultRateLimitRules().Rules,
+		config.PolicyRule{Name: "beta", Claims: map[string]string{"groups": "beta"}, Policy: "beta"},
+	)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := chain{
+		limiter:     service.NewLimiter(repository.NewMemoryStore()),
+		metrics:     metrics.NewRegistry(),
+		policies:    config.NewPolicyStore(),
+		rules:       rules,
+		headers:     config.RateLimitHeadersLegacy,
+		apiKeys:     middleware.DefaultAPIKeys(),
+		jwtIdentity: middleware.NewJWTIdentity(secret, ""),
+	}.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "alice", "groups": []string{"beta"}, "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, path, header, value string
+		want                      string
+	}{
+		{"premium key", "/api/users", "X-API-Key", "key_admin_prod_123", "premium"},
+		{"standard key", "/api/users", "X-API-Key", "key_user_prod_456", "standard"},
+		{"key outside its paths", "/metrics", "X-API-Key", "key_user_prod_456", "standard"},
+		{"unknown key", "/api/users", "X-API-Key", "not-a-key", service.DefaultPolicyName},
+		{"jwt claims", "/api/users", "Authorization", "Bearer " + token, "beta"},
+		{"invalid jwt", "/api/users", "Authorization", "Bearer garbage", service.DefaultPolicyName},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Header.Set(tc.header, tc.value)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", tc.name, w.Code)
+		}
+		if got := w.Header().Get("X-RateLimit-Rule"); got != tc.want {
+			t.Errorf("%s: expected rule %s, got %s", tc.name, tc.want, got)
+		}
+	}
+}
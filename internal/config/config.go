@@ -18,6 +18,8 @@ type PolicyConfig struct {
 // PolicyStore loads and retrieves policies (in production, backed by DB or config service).
 type PolicyStore interface {
 	GetPolicy(key string) PolicyConfig
+	// LookupPolicy returns the policy stored under key, without the default.
+	LookupPolicy(key string) (PolicyConfig, bool)
 	SetPolicy(key string, p PolicyConfig)
 	ListPolicies() map[string]PolicyConfig
 }
@@ -37,6 +39,13 @@ func (d *dynamicPolicyStore) GetPolicy(key string) PolicyConfig {
 	return PolicyConfig{Algorithm: "tokenbucket", Capacity: 100, Rate: 100, Limit: 100}
 }
 
+func (d *dynamicPolicyStore) LookupPolicy(key string) (PolicyConfig, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	p, ok := d.policies[key]
+	return p, ok
+}
+
 func (d *dynamicPolicyStore) SetPolicy(key string, p PolicyConfig) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -65,12 +74,37 @@ func NewPolicyStore() PolicyStore {
 	return d
 }
 
+// Rate-limit header formats.
+const (
+	// RateLimitHeadersLegacy sends X-RateLimit-Limit, -Remaining and -Reset (unix time).
+	RateLimitHeadersLegacy = "legacy"
+	// RateLimitHeadersIETF sends the RateLimit and RateLimit-Policy fields of the
+	// IETF httpapi draft.
+	RateLimitHeadersIETF = "ietf"
+	// RateLimitHeadersBoth sends both sets.
+	RateLimitHeadersBoth = "both"
+)
+
 // Config holds configuration loaded from environment variables.
 type Config struct {
 	RedisAddr               string
 	DownstreamURL           string
+	RoutesFile              string
 	ListenAddr              string
 	GracefulShutdownTimeout int
+
+	// H2C serves cleartext HTTP/2 (prior knowledge) on ListenAddr, for gRPC clients.
+	H2C bool
+	// TLSListenAddr enables a TLS listener serving HTTP/1.1 and HTTP/2.
+	TLSListenAddr string
+	TLSCertFile   string
+	TLSKeyFile    string
+	// TLSConfigFile holds certificates for SNI, protocol settings and redirects.
+	TLSConfigFile string
+	// RateLimitHeaders selects the rate-limit response headers: legacy, ietf or both.
+	RateLimitHeaders string
+	// RateLimitRulesFile holds the rules that select each request's policy.
+	RateLimitRulesFile string
 }
 
 // Load reads environment variables and returns a Config with sensible defaults.
@@ -78,11 +112,25 @@ func Load() Config {
 	cfg := Config{
 		RedisAddr:     os.Getenv("REDIS_ADDR"),
 		DownstreamURL: os.Getenv("DOWNSTREAM_URL"),
+		RoutesFile:    os.Getenv("ROUTES_FILE"),
 		ListenAddr:    os.Getenv("LISTEN_ADDR"),
+		TLSListenAddr: os.Getenv("TLS_LISTEN_ADDR"),
+		TLSCertFile:   os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:    os.Getenv("TLS_KEY_FILE"),
+		TLSConfigFile: os.Getenv("TLS_CONFIG_FILE"),
+
+		RateLimitHeaders:   os.Getenv("RATE_LIMIT_HEADERS"),
+		RateLimitRulesFile: os.Getenv("RATE_LIMIT_RULES_FILE"),
 	}
+	cfg.H2C, _ = strconv.ParseBool(os.Getenv("H2C_ENABLED"))
 	if cfg.ListenAddr == "" {
 		cfg.ListenAddr = ":8080"
 	}
+	switch cfg.RateLimitHeaders {
+	case RateLimitHeadersLegacy, RateLimitHeadersIETF:
+	default:
+		cfg.RateLimitHeaders = RateLimitHeadersBoth
+	}
 	if cfg.DownstreamURL == "" {
 		cfg.DownstreamURL = "http://localhost:8081"
 	}
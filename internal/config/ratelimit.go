@@ -0,0 +1,94 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// RateLimitRules select the policy applied to each request's own limit. They are
+// loaded from RATE_LIMIT_RULES_FILE.
+//
+// Precedence: a policy stored under the exact "<client>:<path>" key wins; then
+// rules are tried by descending Priority, equal priorities in file order, and
+// the first match wins; otherwise Default applies.
+type RateLimitRules struct {
+	Rules []PolicyRule `json:"rules"`
+	// Default is the policy store key used when no rule matches; empty uses the
+	// built-in default policy.
+	Default string `json:"default,omitempty"`
+}
+
+// PolicyRule applies a stored policy to matching requests. Every condition that
+// is set must match; unset conditions match anything.
+type PolicyRule struct {
+	// Name identifies the rule in rate-limit headers and metrics.
+	Name     string `json:"name"`
+	Priority int    `json:"priority,omitempty"`
+	// Path is an exact path, a "/prefix/*" pattern or a template such as "/users/{id}".
+	Path    string   `json:"path,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+	// Tiers match the tier of the caller's API key, e.g. "premium".
+	Tiers []string `json:"tiers,omitempty"`
+	// Roles match the caller's role from a JWT, API key or client certificate.
+	Roles []string `json:"roles,omitempty"`
+	// Claims match JWT claims by value; an array claim matches if any element does.
+	Claims map[string]string `json:"claims,omitempty"`
+	// CIDRs match the client address, e.g. "10.0.0.0/8".
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Policy is the policy store key applied to matching requests.
+	Policy string `json:"policy"`
+}
+
+// DefaultRateLimitRules applies the seeded API key tier policies.
+func DefaultRateLimitRules() RateLimitRules {
+	return RateLimitRules{Rules: []PolicyRule{
+		{Name: "premium", Tiers: []string{"premium"}, Policy: "api-key:premium"},
+		{Name: "standard", Tiers: []string{"standard"}, Policy: "api-key:standard"},
+	}}
+}
+
+// LoadRateLimitRules reads and validates a JSON rate-limit rules file.
+func LoadRateLimitRules(path string) (RateLimitRules, error) {
+	var rc RateLimitRules
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rc, fmt.Errorf("read rate limit rules file: %w", err)
+	}
+	if err := json.Unmarshal(data, &rc); err != nil {
+		return rc, fmt.Errorf("parse rate limit rules file: %w", err)
+	}
+	if err := rc.Validate(); err != nil {
+		return rc, err
+	}
+	return rc, nil
+}
+
+// Validate checks that rules are uniquely named, name a policy and have valid
+// paths and CIDRs.
+func (rc RateLimitRules) Validate() error {
+	names := make(map[string]bool, len(rc.Rules))
+	for i, rule := range rc.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rate limit rule %d: empty name", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate rate limit rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Policy == "" {
+			return fmt.Errorf("rate limit rule %s: policy is required", rule.Name)
+		}
+		if rule.Path != "" && !strings.HasPrefix(rule.Path, "/") {
+			return fmt.Errorf("rate limit rule %s: path must start with /", rule.Name)
+		}
+		for _, c := range rule.CIDRs {
+			if _, _, err := net.ParseCIDR(c); err != nil {
+				return fmt.Errorf("rate limit rule %s: %w", rule.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,534 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// RouteConfig maps matching requests to a named upstream cluster.
+// Host, PathPrefix/Path and Methods are all optional; an empty field matches anything.
+type RouteConfig struct {
+	Name       string   `json:"name"`
+	Host       string   `json:"host,omitempty"`        // exact host or "*.example.com"
+	PathPrefix string   `json:"path_prefix,omitempty"` // e.g. "/api/"
+	Path       string   `json:"path,omitempty"`        // exact path or template, e.g. "/users/{id}"
+	Methods    []string `json:"methods,omitempty"`
+	Cluster    string   `json:"cluster"`
+	// Type is RouteTypeHTTP (default) or RouteTypeGRPC. gRPC routes only match
+	// gRPC calls and may select them by service and method instead of a path.
+	Type        string `json:"type,omitempty"`
+	GRPCService string `json:"grpc_service,omitempty"` // e.g. "helloworld.Greeter"
+	GRPCMethod  string `json:"grpc_method,omitempty"`  // e.g. "SayHello"; empty matches every method
+	// GRPCWeb translates gRPC-Web calls from browsers into native gRPC on a gRPC route.
+	GRPCWeb *GRPCWebConfig `json:"grpc_web,omitempty"`
+	// FlushIntervalMs flushes buffered response data to the client periodically;
+	// negative flushes after every write. text/event-stream responses and responses
+	// of unknown length are always flushed immediately.
+	FlushIntervalMs int `json:"flush_interval_ms,omitempty"`
+
+	// Retry enables automatic retries of failed upstream attempts when set.
+	Retry *RetryConfig `json:"retry,omitempty"`
+	// Timeout bounds upstream connect, response header and total request time.
+	Timeout *TimeoutConfig `json:"timeout,omitempty"`
+	// Rewrite changes the upstream path and Host; middleware still sees the public path.
+	Rewrite *RewriteConfig `json:"rewrite,omitempty"`
+	// Headers manipulates request and response headers; applied after the global rules.
+	Headers *HeaderRulesConfig `json:"headers,omitempty"`
+	// Canary sends a share of the route's traffic to another cluster.
+	Canary *CanaryConfig `json:"canary,omitempty"`
+	// Mirror copies a sample of the route's traffic to a shadow cluster.
+	Mirror *MirrorConfig `json:"mirror,omitempty"`
+	// WebSocket applies connection-level limits to upgraded connections on the route.
+	WebSocket *WebSocketConfig `json:"websocket,omitempty"`
+	// Transform rewrites JSON response bodies: drop, mask and rename fields.
+	Transform *TransformConfig `json:"transform,omitempty"`
+	// RateLimitCost is taken from every rate limit per request, e.g. 50 for a bulk
+	// export; default 1.
+	RateLimitCost int64 `json:"rate_limit_cost,omitempty"`
+}
+
+// Upstream protocols.
+const (
+	ProtocolHTTP1 = "http1"
+	ProtocolHTTP2 = "http2"
+	ProtocolH2C   = "h2c"
+)
+
+// Route types.
+const (
+	RouteTypeHTTP = "http"
+	RouteTypeGRPC = "grpc"
+)
+
+// GRPCWebConfig enables the gRPC-Web bridge and CORS for browser origins.
+type GRPCWebConfig struct {
+	AllowedOrigins []string `json:"allowed_origins,omitempty"` // "*" allows any origin
+	MaxAgeSec      int      `json:"max_age_sec,omitempty"`     // preflight cache lifetime, default 600
+}
+
+// CanaryConfig splits a route's traffic between its cluster and a canary cluster.
+type CanaryConfig struct {
+	Cluster string `json:"cluster"`
+	Weight  int    `json:"weight"` // percent of traffic sent to the canary, 0-100
+	// StickyOn keeps a client on one variant: "cookie:<name>", "api_key" or "user_id"
+	// (the authenticated principal). Empty assigns every request independently.
+	StickyOn string `json:"sticky_on,omitempty"`
+	// OverrideHeader forces a variant with the values "always" or "never"; default X-Canary.
+	OverrideHeader string `json:"override_header,omitempty"`
+}
+
+// MirrorConfig sends asynchronous copies of sampled requests to a shadow cluster.
+// Shadow responses are discarded.
+type MirrorConfig struct {
+	Cluster string  `json:"cluster"`
+	Percent float64 `json:"percent"` // share of requests mirrored, 0-100
+	// MaxBodyBytes is the largest request body that is copied; larger requests are not mirrored.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	TimeoutMs    int   `json:"timeout_ms,omitempty"`
+	// MaxConcurrent caps outstanding shadow requests; excess samples are dropped.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+}
+
+// WithDefaults fills unset mirror fields.
+func (m MirrorConfig) WithDefaults() MirrorConfig {
+	if m.MaxBodyBytes <= 0 {
+		m.MaxBodyBytes = 1 << 20
+	}
+	if m.TimeoutMs <= 0 {
+		m.TimeoutMs = 5000
+	}
+	if m.MaxConcurrent <= 0 {
+		m.MaxConcurrent = 100
+	}
+	return m
+}
+
+// WebSocketConfig limits WebSocket connections on a route. Zero values disable a limit.
+type WebSocketConfig struct {
+	MaxConnections       int `json:"max_connections,omitempty"`         // concurrent connections on the route
+	MaxConnectionsPerKey int `json:"max_connections_per_key,omitempty"` // per API key, or client IP without one
+	IdleTimeoutMs        int `json:"idle_timeout_ms,omitempty"`         // no traffic in either direction
+	MaxLifetimeMs        int `json:"max_lifetime_ms,omitempty"`
+	// MaxMessagesPerSec limits client-to-upstream messages per connection; bursts
+	// of up to one second's worth are allowed.
+	MaxMessagesPerSec float64 `json:"max_messages_per_sec,omitempty"`
+}
+
+// TransformConfig filters JSON response bodies. Paths use a JSONPath subset:
+// "$.user.ssn", "$.items[*].secret", "$.items[0].id", "$['odd key']" and "$..password".
+// Operations run in the order drop, mask, rename, all against the upstream names.
+type TransformConfig struct {
+	Drop   []string       `json:"drop,omitempty"` // object members to remove
+	Mask   []MaskConfig   `json:"mask,omitempty"`
+	Rename []RenameConfig `json:"rename,omitempty"`
+	// MaxBodyBytes is the largest (decompressed) body transformed; larger responses
+	// fail with 502 rather than leak unfiltered fields. Default 1 MiB.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+}
+
+// MaskConfig replaces the values at Path. With Pattern, only the regexp matches
+// within string values are replaced; Replacement may reference groups ("$1").
+type MaskConfig struct {
+	Path        string `json:"path"`
+	Pattern     string `json:"pattern,omitempty"`
+	Replacement string `json:"replacement,omitempty"` // default "****"
+}
+
+// RenameConfig renames the object member at Path to To.
+type RenameConfig struct {
+	Path string `json:"path"`
+	To   string `json:"to"`
+}
+
+// WithDefaults returns a copy with zero fields replaced by their defaults.
+func (t TransformConfig) WithDefaults() TransformConfig {
+	if t.MaxBodyBytes <= 0 {
+		t.MaxBodyBytes = 1 << 20
+	}
+	return t
+}
+
+// HeaderRulesConfig holds header operations for upstream requests and client responses.
+type HeaderRulesConfig struct {
+	Request  HeaderOpsConfig `json:"request,omitempty"`
+	Response HeaderOpsConfig `json:"response,omitempty"`
+}
+
+// HeaderOpsConfig lists header operations, applied as remove, set, append. Set and
+// append values are templates that may reference ${request_id}, ${client_ip},
+// ${subject}, ${role}, ${route}, ${cluster}, ${param.<name>} and ${header.<name>}.
+type HeaderOpsConfig struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Append map[string]string `json:"append,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// RewriteConfig rewrites the upstream request of a route. Steps apply in order:
+// strip prefix, regex replace, add prefix; Host is replaced independently.
+type RewriteConfig struct {
+	StripPrefix string `json:"strip_prefix,omitempty"`
+	Regex       string `json:"regex,omitempty"`
+	Replacement string `json:"replacement,omitempty"` // may reference capture groups as $1 or ${name}
+	AddPrefix   string `json:"add_prefix,omitempty"`
+	Host        string `json:"host,omitempty"`
+}
+
+// TimeoutConfig holds per-route upstream timeouts; zero disables a timeout.
+type TimeoutConfig struct {
+	ConnectMs        int64 `json:"connect_ms,omitempty"`         // establishing the upstream connection
+	ResponseHeaderMs int64 `json:"response_header_ms,omitempty"` // per attempt, until response headers arrive
+	TotalMs          int64 `json:"total_ms,omitempty"`           // whole request including retries
+}
+
+// Retry conditions accepted in RetryConfig.RetryOn.
+const (
+	RetryOnConnectFailure = "connect_failure" // the upstream connection could not be established
+	RetryOnGatewayError   = "gateway_error"   // the upstream answered 502, 503 or 504
+)
+
+// RetryConfig configures retries for a route. Non-idempotent methods are only
+// retried when the request carries an Idempotency-Key header.
+type RetryConfig struct {
+	Attempts      int      `json:"attempts"`                  // retries after the first attempt
+	RetryOn       []string `json:"retry_on,omitempty"`        // default connect_failure and gateway_error
+	StatusCodes   []int    `json:"status_codes,omitempty"`    // additional status codes to retry on
+	BaseBackoffMs int64    `json:"base_backoff_ms,omitempty"` // default 25
+	MaxBackoffMs  int64    `json:"max_backoff_ms,omitempty"`  // default 250
+	MaxBodyBytes  int64    `json:"max_body_bytes,omitempty"`  // bodies up to this size are buffered for replay, default 64KiB
+}
+
+// WithDefaults returns a copy with zero fields replaced by their defaults.
+func (rc RetryConfig) WithDefaults() RetryConfig {
+	if len(rc.RetryOn) == 0 && len(rc.StatusCodes) == 0 {
+		rc.RetryOn = []string{RetryOnConnectFailure, RetryOnGatewayError}
+	}
+	if rc.BaseBackoffMs <= 0 {
+		rc.BaseBackoffMs = 25
+	}
+	if rc.MaxBackoffMs <= 0 {
+		rc.MaxBackoffMs = 250
+	}
+	if rc.MaxBodyBytes <= 0 {
+		rc.MaxBodyBytes = 64 << 10
+	}
+	return rc
+}
+
+// TargetConfig is a single upstream endpoint within a cluster.
+type TargetConfig struct {
+	URL    string `json:"url" yaml:"url"`
+	Weight int    `json:"weight,omitempty" yaml:"weight,omitempty"` // defaults to 1
+}
+
+// ClusterConfig describes a named upstream cluster: a pool of targets and how to balance across them.
+type ClusterConfig struct {
+	Name    string         `json:"name"`
+	URL     string         `json:"url,omitempty"` // shorthand for a single target
+	Targets []TargetConfig `json:"targets,omitempty"`
+	// LoadBalancer is one of round_robin (default), weighted_round_robin,
+	// least_request, random_two_choices or consistent_hash.
+	LoadBalancer string `json:"load_balancer,omitempty"`
+	// HashOn selects the consistent hash key: "header:<name>", "cookie:<name>" or "client_ip".
+	HashOn string `json:"hash_on,omitempty"`
+	// Protocol is the upstream HTTP version: "" negotiates HTTP/1.1 or HTTP/2 over
+	// TLS, ProtocolHTTP1 and ProtocolHTTP2 force one of them, ProtocolH2C speaks
+	// cleartext HTTP/2 with prior knowledge (gRPC backends).
+	Protocol string `json:"protocol,omitempty"`
+	// Transport tunes the cluster's connection pool, dialing and upstream TLS.
+	Transport *TransportConfig `json:"transport,omitempty"`
+	// Discovery refreshes the targets from an endpoints file or DNS. Static
+	// targets, if any, serve until the first successful refresh.
+	Discovery *DiscoveryConfig `json:"discovery,omitempty"`
+	// HealthCheck enables active health checking of the targets when set.
+	HealthCheck *HealthCheckConfig `json:"health_check,omitempty"`
+	// OutlierDetection enables passive ejection of failing targets when set.
+	OutlierDetection *OutlierDetectionConfig `json:"outlier_detection,omitempty"`
+	// RetryBudget caps concurrent retries across all routes using the cluster.
+	RetryBudget *RetryBudgetConfig `json:"retry_budget,omitempty"`
+}
+
+// TransportConfig configures the HTTP transport of one cluster. Zero values keep
+// Go's defaults.
+type TransportConfig struct {
+	// CAFile is a PEM bundle trusted for upstream server certificates instead of the system roots.
+	CAFile string `json:"ca_file,omitempty"`
+	// CertFile and KeyFile are a client certificate presented to upstreams requiring mTLS.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// ServerName overrides the SNI name and the name upstream certificates are verified against.
+	ServerName string `json:"server_name,omitempty"`
+	// InsecureSkipVerify disables upstream certificate verification. Development only.
+	InsecureSkipVerify  bool  `json:"insecure_skip_verify,omitempty"`
+	MaxIdleConnsPerHost int   `json:"max_idle_conns_per_host,omitempty"` // default 2
+	IdleConnTimeoutMs   int64 `json:"idle_conn_timeout_ms,omitempty"`    // default 90000
+	// DialTimeoutMs bounds connection setup; a route's connect timeout still applies. Default 30000.
+	DialTimeoutMs int64 `json:"dial_timeout_ms,omitempty"`
+}
+
+// Discovery source types.
+const (
+	DiscoveryFile = "file"
+	DiscoveryDNS  = "dns"
+)
+
+// DNS record types for discovery.
+const (
+	RecordA   = "a"   // A/AAAA records; targets use Port
+	RecordSRV = "srv" // SRV records carry port and weight
+)
+
+// DiscoveryConfig sources a cluster's targets dynamically.
+type DiscoveryConfig struct {
+	Type string `json:"type"` // DiscoveryFile or DiscoveryDNS
+	// Path is a JSON or YAML (.yaml, .yml) file of the form {"targets": [{"url": ..., "weight": ...}]}.
+	Path string `json:"path,omitempty"`
+	// Name is the DNS name to resolve, e.g. "api.default.svc.cluster.local" for A
+	// records or "_http._tcp.api.default.svc.cluster.local" for SRV records.
+	Name       string `json:"name,omitempty"`
+	RecordType string `json:"record_type,omitempty"` // RecordA (default) or RecordSRV
+	Port       int    `json:"port,omitempty"`        // target port for A records
+	Scheme     string `json:"scheme,omitempty"`      // target URL scheme for DNS, default "http"
+	IntervalMs int64  `json:"interval_ms,omitempty"` // refresh interval, default 5000
+}
+
+// WithDefaults returns a copy with zero fields replaced by their defaults.
+func (d DiscoveryConfig) WithDefaults() DiscoveryConfig {
+	if d.RecordType == "" {
+		d.RecordType = RecordA
+	}
+	if d.Scheme == "" {
+		d.Scheme = "http"
+	}
+	if d.IntervalMs <= 0 {
+		d.IntervalMs = 5000
+	}
+	return d
+}
+
+// Validate checks that the discovery source is complete.
+func (d DiscoveryConfig) Validate() error {
+	switch d.Type {
+	case DiscoveryFile:
+		if d.Path == "" {
+			return fmt.Errorf("file discovery requires a path")
+		}
+	case DiscoveryDNS:
+		if d.Name == "" {
+			return fmt.Errorf("dns discovery requires a name")
+		}
+		switch d.RecordType {
+		case "", RecordA:
+			if d.Port <= 0 {
+				return fmt.Errorf("dns discovery of A records requires a port")
+			}
+		case RecordSRV:
+		default:
+			return fmt.Errorf("unknown dns record type %q", d.RecordType)
+		}
+	default:
+		return fmt.Errorf("unknown discovery type %q", d.Type)
+	}
+	return nil
+}
+
+// RetryBudgetConfig limits active retries to a share of active requests, so
+// retries cannot amplify an outage into a retry storm.
+type RetryBudgetConfig struct {
+	BudgetPercent       float64 `json:"budget_percent,omitempty"`        // default 20
+	MinRetryConcurrency int     `json:"min_retry_concurrency,omitempty"` // retries always allowed below this, default 3
+}
+
+// WithDefaults returns a copy with zero fields replaced by their defaults.
+func (b RetryBudgetConfig) WithDefaults() RetryBudgetConfig {
+	if b.BudgetPercent <= 0 {
+		b.BudgetPercent = 20
+	}
+	if b.MinRetryConcurrency <= 0 {
+		b.MinRetryConcurrency = 3
+	}
+	return b
+}
+
+// OutlierDetectionConfig ejects targets that return consecutive 5xx responses,
+// connection errors or timeouts. Each consecutive ejection of the same target
+// doubles its ejection period, up to MaxEjectionMs.
+type OutlierDetectionConfig struct {
+	ConsecutiveErrors  int   `json:"consecutive_errors,omitempty"`   // default 5
+	BaseEjectionMs     int64 `json:"base_ejection_ms,omitempty"`     // default 30000
+	MaxEjectionMs      int64 `json:"max_ejection_ms,omitempty"`      // default 300000
+	MaxEjectionPercent int   `json:"max_ejection_percent,omitempty"` // default 50; one target may always be ejected
+}
+
+// WithDefaults returns a copy with zero fields replaced by their defaults.
+func (o OutlierDetectionConfig) WithDefaults() OutlierDetectionConfig {
+	if o.ConsecutiveErrors <= 0 {
+		o.ConsecutiveErrors = 5
+	}
+	if o.BaseEjectionMs <= 0 {
+		o.BaseEjectionMs = 30000
+	}
+	if o.MaxEjectionMs <= 0 {
+		o.MaxEjectionMs = 300000
+	}
+	if o.MaxEjectionPercent <= 0 {
+		o.MaxEjectionPercent = 50
+	}
+	return o
+}
+
+// HealthCheckConfig configures periodic HTTP GET probes of each cluster target.
+type HealthCheckConfig struct {
+	Path               string `json:"path"`
+	IntervalMs         int64  `json:"interval_ms,omitempty"`         // default 10000
+	TimeoutMs          int64  `json:"timeout_ms,omitempty"`          // default 2000
+	HealthyThreshold   int    `json:"healthy_threshold,omitempty"`   // consecutive passes to recover, default 2
+	UnhealthyThreshold int    `json:"unhealthy_threshold,omitempty"` // consecutive failures to eject, default 3
+	ExpectedStatusMin  int    `json:"expected_status_min,omitempty"` // default 200
+	ExpectedStatusMax  int    `json:"expected_status_max,omitempty"` // default 399
+}
+
+// WithDefaults returns a copy with zero fields replaced by their defaults.
+func (h HealthCheckConfig) WithDefaults() HealthCheckConfig {
+	if h.Path == "" {
+		h.Path = "/health"
+	}
+	if h.IntervalMs <= 0 {
+		h.IntervalMs = 10000
+	}
+	if h.TimeoutMs <= 0 {
+		h.TimeoutMs = 2000
+	}
+	if h.HealthyThreshold <= 0 {
+		h.HealthyThreshold = 2
+	}
+	if h.UnhealthyThreshold <= 0 {
+		h.UnhealthyThreshold = 3
+	}
+	if h.ExpectedStatusMin == 0 {
+		h.ExpectedStatusMin = 200
+	}
+	if h.ExpectedStatusMax == 0 {
+		h.ExpectedStatusMax = 399
+	}
+	return h
+}
+
+// TargetList returns the cluster's targets, including the URL shorthand.
+func (c ClusterConfig) TargetList() []TargetConfig {
+	if c.URL == "" {
+		return c.Targets
+	}
+	return append([]TargetConfig{{URL: c.URL}}, c.Targets...)
+}
+
+// RoutesConfig is the route table and upstream clusters served by the proxy.
+type RoutesConfig struct {
+	Routes   []RouteConfig   `json:"routes"`
+	Clusters []ClusterConfig `json:"clusters"`
+	// Headers are header rules applied to every route.
+	Headers *HeaderRulesConfig `json:"headers,omitempty"`
+}
+
+// DefaultRoutes returns a catch-all route sending every request to a single downstream.
+func DefaultRoutes(downstream string) RoutesConfig {
+	return RoutesConfig{
+		Routes:   []RouteConfig{{Name: "default", PathPrefix: "/", Cluster: "default"}},
+		Clusters: []ClusterConfig{{Name: "default", URL: downstream}},
+	}
+}
+
+// LoadRoutes reads a JSON route table from path and validates it.
+func LoadRoutes(path string) (RoutesConfig, error) {
+	var rc RoutesConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rc, fmt.Errorf("read routes file: %w", err)
+	}
+	if err := json.Unmarshal(data, &rc); err != nil {
+		return rc, fmt.Errorf("parse routes file: %w", err)
+	}
+	if err := rc.Validate(); err != nil {
+		return rc, err
+	}
+	return rc, nil
+}
+
+// Validate checks that cluster names are unique and every route targets a known cluster.
+func (rc RoutesConfig) Validate() error {
+	clusters := make(map[string]bool, len(rc.Clusters))
+	for _, c := range rc.Clusters {
+		if c.Name == "" {
+			return fmt.Errorf("cluster with empty name")
+		}
+		if clusters[c.Name] {
+			return fmt.Errorf("duplicate cluster %q", c.Name)
+		}
+		if c.Discovery != nil {
+			if err := c.Discovery.Validate(); err != nil {
+				return fmt.Errorf("cluster %q: %w", c.Name, err)
+			}
+		} else if len(c.TargetList()) == 0 {
+			return fmt.Errorf("cluster %q has no targets", c.Name)
+		}
+		switch c.Protocol {
+		case "", ProtocolHTTP1, ProtocolHTTP2, ProtocolH2C:
+		default:
+			return fmt.Errorf("cluster %q: unknown protocol %q", c.Name, c.Protocol)
+		}
+		if t := c.Transport; t != nil && (t.CertFile == "") != (t.KeyFile == "") {
+			return fmt.Errorf("cluster %q: transport cert_file and key_file must be set together", c.Name)
+		}
+		clusters[c.Name] = true
+	}
+	for i, r := range rc.Routes {
+		if !clusters[r.Cluster] {
+			return fmt.Errorf("route %d (%s): unknown cluster %q", i, r.Name, r.Cluster)
+		}
+		if r.RateLimitCost < 0 {
+			return fmt.Errorf("route %d (%s): rate_limit_cost must not be negative", i, r.Name)
+		}
+		if r.Canary != nil {
+			if !clusters[r.Canary.Cluster] {
+				return fmt.Errorf("route %d (%s): unknown canary cluster %q", i, r.Name, r.Canary.Cluster)
+			}
+			if r.Canary.Weight < 0 || r.Canary.Weight > 100 {
+				return fmt.Errorf("route %d (%s): canary weight must be between 0 and 100", i, r.Name)
+			}
+		}
+		if r.Mirror != nil {
+			if !clusters[r.Mirror.Cluster] {
+				return fmt.Errorf("route %d (%s): unknown mirror cluster %q", i, r.Name, r.Mirror.Cluster)
+			}
+			if r.Mirror.Percent < 0 || r.Mirror.Percent > 100 {
+				return fmt.Errorf("route %d (%s): mirror percent must be between 0 and 100", i, r.Name)
+			}
+		}
+		if r.Retry != nil {
+			for _, cond := range r.Retry.RetryOn {
+				if cond != RetryOnConnectFailure && cond != RetryOnGatewayError {
+					return fmt.Errorf("route %d (%s): unknown retry condition %q", i, r.Name, cond)
+				}
+			}
+		}
+		switch r.Type {
+		case "", RouteTypeHTTP:
+			if r.GRPCService != "" || r.GRPCMethod != "" || r.GRPCWeb != nil {
+				return fmt.Errorf("route %d (%s): grpc_service, grpc_method and grpc_web require type grpc", i, r.Name)
+			}
+		case RouteTypeGRPC:
+			if r.GRPCService != "" && (r.Path != "" || r.PathPrefix != "") {
+				return fmt.Errorf("route %d (%s): grpc_service and path are mutually exclusive", i, r.Name)
+			}
+			if r.GRPCMethod != "" && r.GRPCService == "" {
+				return fmt.Errorf("route %d (%s): grpc_method requires grpc_service", i, r.Name)
+			}
+		default:
+			return fmt.Errorf("route %d (%s): unknown route type %q", i, r.Name, r.Type)
+		}
+		if r.Path != "" && r.PathPrefix != "" {
+			return fmt.Errorf("route %d (%s): path and path_prefix are mutually exclusive", i, r.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+// TLSConfig configures TLS termination on TLS_LISTEN_ADDR. It is loaded from
+// TLS_CONFIG_FILE; TLS_CERT_FILE/TLS_KEY_FILE add one more certificate pair.
+type TLSConfig struct {
+	// Certificates are selected by SNI against each leaf's DNS names; the first
+	// pair is served to clients that send no or an unknown server name.
+	Certificates []CertificateConfig `json:"certificates"`
+	MinVersion   string              `json:"min_version,omitempty"`   // "1.0", "1.1", "1.2" (default) or "1.3"
+	CipherSuites []string            `json:"cipher_suites,omitempty"` // Go names for TLS 1.0-1.2; empty uses Go's defaults
+	// ReloadIntervalMs is how often certificate files are checked for changes; default 10000.
+	ReloadIntervalMs int `json:"reload_interval_ms,omitempty"`
+	// RedirectHTTP answers plaintext requests on LISTEN_ADDR with a redirect to HTTPS.
+	RedirectHTTP bool `json:"redirect_http,omitempty"`
+	// HSTS adds a Strict-Transport-Security header to HTTPS responses when set.
+	HSTS *HSTSConfig `json:"hsts,omitempty"`
+	// ClientAuth verifies client certificates and maps them to gateway identities.
+	ClientAuth *ClientAuthConfig `json:"client_auth,omitempty"`
+}
+
+// CertificateConfig is a PEM certificate chain and its private key.
+type CertificateConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// HSTSConfig controls the Strict-Transport-Security header.
+type HSTSConfig struct {
+	MaxAgeSec         int  `json:"max_age_sec,omitempty"` // default 31536000 (one year)
+	IncludeSubdomains bool `json:"include_subdomains,omitempty"`
+	Preload           bool `json:"preload,omitempty"`
+}
+
+// Client certificate modes.
+const (
+	ClientAuthRequire  = "require"  // handshakes without a valid certificate fail
+	ClientAuthOptional = "optional" // a certificate is verified only when presented
+)
+
+// Certificate fields usable in identity rules.
+const (
+	CertFieldCN    = "cn"    // subject common name
+	CertFieldO     = "o"     // subject organization
+	CertFieldOU    = "ou"    // subject organizational unit
+	CertFieldDNS   = "dns"   // DNS SAN
+	CertFieldURI   = "uri"   // URI SAN, e.g. a SPIFFE ID
+	CertFieldEmail = "email" // email SAN
+)
+
+var certFields = map[string]bool{
+	CertFieldCN: true, CertFieldO: true, CertFieldOU: true,
+	CertFieldDNS: true, CertFieldURI: true, CertFieldEmail: true,
+}
+
+// ClientAuthConfig enables mutual TLS on the TLS listener.
+type ClientAuthConfig struct {
+	Mode     string   `json:"mode,omitempty"` // ClientAuthRequire (default) or ClientAuthOptional
+	CAFiles  []string `json:"ca_files"`       // PEM bundles of trusted client CAs
+	CRLFiles []string `json:"crl_files,omitempty"`
+	// Identities map certificates to a principal and role; the first matching rule
+	// wins. Certificates matching no rule get the subject CN and DefaultRole.
+	Identities  []CertIdentityRule `json:"identities,omitempty"`
+	DefaultRole string             `json:"default_role,omitempty"`
+	// Headers name the upstream headers carrying the verified certificate.
+	Headers ClientCertHeaders `json:"headers,omitempty"`
+}
+
+// CertIdentityRule matches one certificate field against a path.Match pattern,
+// e.g. {"field": "uri", "pattern": "spiffe://prod/billing/*", "role": "operator"}.
+type CertIdentityRule struct {
+	Field   string `json:"field"`
+	Pattern string `json:"pattern"`
+	Role    string `json:"role"`
+	// Principal is the field whose value names the caller; default Field.
+	Principal string `json:"principal,omitempty"`
+}
+
+// ClientCertHeaders names the headers that forward certificate details upstream.
+// The principal and role are always sent as X-User-ID and X-User-Role.
+type ClientCertHeaders struct {
+	Subject     string `json:"subject,omitempty"`     // default X-Client-Cert-Subject
+	SANs        string `json:"sans,omitempty"`        // default X-Client-Cert-SAN
+	Fingerprint string `json:"fingerprint,omitempty"` // SHA-256, default X-Client-Cert-Fingerprint
+}
+
+// WithDefaults returns a copy with zero fields replaced by their defaults.
+func (c ClientAuthConfig) WithDefaults() ClientAuthConfig {
+	if c.Mode == "" {
+		c.Mode = ClientAuthRequire
+	}
+	if c.Headers.Subject == "" {
+		c.Headers.Subject = "X-Client-Cert-Subject"
+	}
+	if c.Headers.SANs == "" {
+		c.Headers.SANs = "X-Client-Cert-SAN"
+	}
+	if c.Headers.Fingerprint == "" {
+		c.Headers.Fingerprint = "X-Client-Cert-Fingerprint"
+	}
+	return c
+}
+
+// Validate checks the mode, CA bundles and identity rules.
+func (c ClientAuthConfig) Validate() error {
+	switch c.Mode {
+	case "", ClientAuthRequire, ClientAuthOptional:
+	default:
+		return fmt.Errorf("unknown client_auth mode %q", c.Mode)
+	}
+	if len(c.CAFiles) == 0 {
+		return fmt.Errorf("client_auth requires ca_files")
+	}
+	for i, rule := range c.Identities {
+		if !certFields[rule.Field] {
+			return fmt.Errorf("client_auth identity %d: unknown certificate field %q", i, rule.Field)
+		}
+		if rule.Principal != "" && !certFields[rule.Principal] {
+			return fmt.Errorf("client_auth identity %d: unknown principal field %q", i, rule.Principal)
+		}
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("client_auth identity %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// WithDefaults returns a copy with zero fields replaced by their defaults.
+func (c TLSConfig) WithDefaults() TLSConfig {
+	if c.MinVersion == "" {
+		c.MinVersion = "1.2"
+	}
+	if c.ReloadIntervalMs <= 0 {
+		c.ReloadIntervalMs = 10000
+	}
+	if c.HSTS != nil && c.HSTS.MaxAgeSec <= 0 {
+		h := *c.HSTS
+		h.MaxAgeSec = 31536000
+		c.HSTS = &h
+	}
+	if c.ClientAuth != nil {
+		ca := c.ClientAuth.WithDefaults()
+		c.ClientAuth = &ca
+	}
+	return c
+}
+
+// LoadTLS reads and validates a TLS configuration file.
+func LoadTLS(path string) (TLSConfig, error) {
+	var tc TLSConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tc, fmt.Errorf("read tls config: %w", err)
+	}
+	if err := json.Unmarshal(data, &tc); err != nil {
+		return tc, fmt.Errorf("parse tls config: %w", err)
+	}
+	if err := tc.Validate(); err != nil {
+		return tc, err
+	}
+	return tc, nil
+}
+
+// Validate checks certificate entries, the TLS version and cipher suite names.
+func (c TLSConfig) Validate() error {
+	for i, cc := range c.Certificates {
+		if cc.CertFile == "" || cc.KeyFile == "" {
+			return fmt.Errorf("tls certificate %d: cert_file and key_file are required", i)
+		}
+	}
+	if c.MinVersion != "" {
+		if _, err := ParseTLSVersion(c.MinVersion); err != nil {
+			return err
+		}
+	}
+	if _, err := ParseCipherSuites(c.CipherSuites); err != nil {
+		return err
+	}
+	if c.ClientAuth != nil {
+		return c.ClientAuth.Validate()
+	}
+	return nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion converts "1.0" through "1.3" into a crypto/tls version constant.
+func ParseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown tls version %q", s)
+	}
+	return v, nil
+}
+
+// ParseCipherSuites converts Go cipher suite names, e.g.
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", into their IDs. Suites Go considers
+// insecure are rejected.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, n := range names {
+		id, ok := known[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", n)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
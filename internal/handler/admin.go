@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"api-gateway/internal/config"
+	"api-gateway/internal/service"
 )
 
 // AdminHandler provides simple endpoints to manage rate-limit policies at runtime.
@@ -38,3 +39,55 @@ func (a *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// CanaryAdminHandler exposes canary weights so a rollout can be advanced or
+// rolled back without a restart.
+type CanaryAdminHandler struct {
+	router *service.Router
+}
+
+func NewCanaryAdminHandler(rt *service.Router) *CanaryAdminHandler {
+	return &CanaryAdminHandler{router: rt}
+}
+
+// CanaryStatus describes the traffic split of one route.
+type CanaryStatus struct {
+	Cluster string `json:"cluster"`
+	Weight  int    `json:"weight"`
+}
+
+// ServeHTTP dispatches on method: GET lists canary weights by route, POST sets one.
+func (a *CanaryAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		out := make(map[string]CanaryStatus)
+		for _, route := range a.router.Routes() {
+			if route.Canary != nil {
+				out[route.Name] = CanaryStatus{Cluster: route.Canary.Cluster, Weight: route.Canary.Weight()}
+			}
+		}
+		json.NewEncoder(w).Encode(out)
+	case http.MethodPost:
+		var payload struct {
+			Route  string `json:"route"`
+			Weight int    `json:"weight"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		route, ok := a.router.Route(payload.Route)
+		if !ok || route.Canary == nil {
+			http.Error(w, "route has no canary", http.StatusNotFound)
+			return
+		}
+		if err := route.Canary.SetWeight(payload.Weight); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
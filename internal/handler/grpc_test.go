@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/service"
+)
+
+// newH2CServer starts a test server that accepts cleartext HTTP/2.
+func newH2CServer(h http.Handler) *httptest.Server {
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	return srv
+}
+
+func h2cClient() *http.Client {
+	t := &http.Transport{Protocols: new(http.Protocols)}
+	t.Protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: t}
+}
+
+func TestProxyHandlerGRPCTrailers(t *testing.T) {
+	upstream := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("expected HTTP/2 upstream request, got %s", r.Proto)
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write(body)
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "ok")
+	}))
+	defer upstream.Close()
+
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes: []config.RouteConfig{{
+			Name: "greeter", Type: config.RouteTypeGRPC, GRPCService: "helloworld.Greeter", Cluster: "greeter",
+		}},
+		Clusters: []config.ClusterConfig{{Name: "greeter", URL: upstream.URL, Protocol: config.ProtocolH2C}},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gw := newH2CServer(p)
+	defer gw.Close()
+
+	frame := []byte{0, 0, 0, 0, 3, 'a', 'b', 'c'}
+	req, _ := http.NewRequest("POST", gw.URL+"/helloworld.Greeter/SayHello", bytes.NewReader(frame))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := h2cClient().Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || !bytes.Equal(body, frame) {
+		t.Fatalf("expected HTTP/2 echo, got %s %x", resp.Proto, body)
+	}
+	if resp.Trailer.Get("Grpc-Status") != "0" || resp.Trailer.Get("Grpc-Message") != "ok" {
+		t.Fatalf("expected trailers to be preserved, got %v", resp.Trailer)
+	}
+}
+
+func TestProxyHandlerGRPCUnavailable(t *testing.T) {
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes: []config.RouteConfig{{Type: config.RouteTypeGRPC, GRPCService: "helloworld.Greeter", Cluster: "greeter"}},
+		Clusters: []config.ClusterConfig{{
+			Name: "greeter", URL: "http://127.0.0.1:1", Protocol: config.ProtocolH2C,
+			OutlierDetection: &config.OutlierDetectionConfig{ConsecutiveErrors: 1},
+		}},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The first call trips outlier detection; the second finds the circuit open.
+	for i, want := range []string{"14", "14"} {
+		req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+		req.Header.Set("Content-Type", "application/grpc")
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Header().Get("Grpc-Status") != want {
+			t.Fatalf("call %d: expected grpc-status %s, got %d %v", i, want, rr.Code, rr.Header())
+		}
+	}
+}
+
+// grpcEcho is a native gRPC upstream that echoes the request body.
+func grpcEcho(t *testing.T) *httptest.Server {
+	return newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/grpc+proto" {
+			t.Errorf("expected native gRPC request, got %q", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write(body)
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "ok")
+	}))
+}
+
+func newGRPCWebProxy(t *testing.T, upstream string) *ProxyHandler {
+	t.Helper()
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes: []config.RouteConfig{{
+			Name: "greeter", Type: config.RouteTypeGRPC, GRPCService: "helloworld.Greeter", Cluster: "greeter",
+			GRPCWeb: &config.GRPCWebConfig{AllowedOrigins: []string{"https://app.example.com"}},
+		}},
+		Clusters: []config.ClusterConfig{{Name: "greeter", URL: upstream, Protocol: config.ProtocolH2C}},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return p
+}
+
+func TestProxyHandlerGRPCWeb(t *testing.T) {
+	upstream := grpcEcho(t)
+	defer upstream.Close()
+	p := newGRPCWebProxy(t, upstream.URL)
+
+	msg := []byte{0, 0, 0, 0, 3, 'a', 'b', 'c'}
+	trailer := "grpc-message: ok\r\ngrpc-status: 0\r\n"
+	wantBody := append(append(append([]byte{}, msg...), 0x80, 0, 0, 0, byte(len(trailer))), trailer...)
+
+	t.Run("binary", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", bytes.NewReader(msg))
+		req.Header.Set("Content-Type", "application/grpc-web+proto")
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, req)
+		if ct := rr.Header().Get("Content-Type"); ct != "application/grpc-web+proto" {
+			t.Fatalf("unexpected content type %q", ct)
+		}
+		if !bytes.Equal(rr.Body.Bytes(), wantBody) {
+			t.Fatalf("unexpected body %q", rr.Body.Bytes())
+		}
+		if len(rr.Result().Trailer) != 0 {
+			t.Fatalf("trailers must be moved into the body, got %v", rr.Result().Trailer)
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", strings.NewReader(base64.StdEncoding.EncodeToString(msg)))
+		req.Header.Set("Content-Type", "application/grpc-web-text+proto")
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, req)
+		if ct := rr.Header().Get("Content-Type"); ct != "application/grpc-web-text+proto" {
+			t.Fatalf("unexpected content type %q", ct)
+		}
+		// the body is a sequence of independently padded base64 chunks
+		var got []byte
+		for s := rr.Body.String(); len(s) > 0; s = s[4:] {
+			b, err := base64.StdEncoding.DecodeString(s[:4])
+			if err != nil {
+				t.Fatalf("invalid base64 body %q: %v", rr.Body.String(), err)
+			}
+			got = append(got, b...)
+		}
+		if !bytes.Equal(got, wantBody) {
+			t.Fatalf("unexpected decoded body %q", got)
+		}
+	})
+}
+
+func TestProxyHandlerGRPCWebCORS(t *testing.T) {
+	p := newGRPCWebProxy(t, "http://127.0.0.1:1")
+	rejecting := p.CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		service.RejectGRPC(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+	}))
+
+	pre := httptest.NewRequest("OPTIONS", "/helloworld.Greeter/SayHello", nil)
+	pre.Header.Set("Origin", "https://app.example.com")
+	pre.Header.Set("Access-Control-Request-Method", "POST")
+	pre.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+	rr := httptest.NewRecorder()
+	rejecting.ServeHTTP(rr, pre)
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rr.Header().Get("Access-Control-Allow-Headers") != "content-type,x-grpc-web" {
+		t.Fatalf("unexpected preflight response %d %v", rr.Code, rr.Header())
+	}
+
+	pre.Header.Set("Origin", "https://evil.example.com")
+	rr = httptest.NewRecorder()
+	rejecting.ServeHTTP(rr, pre)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("disallowed origin must not get CORS headers")
+	}
+
+	// gateway rejections of gRPC-Web calls stay readable by the browser
+	req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Content-Type", "application/grpc-web-text")
+	rr = httptest.NewRecorder()
+	rejecting.ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		!strings.Contains(rr.Header().Get("Access-Control-Expose-Headers"), "Grpc-Status") {
+		t.Fatalf("expected CORS headers on rejection, got %v", rr.Header())
+	}
+	if rr.Header().Get("Grpc-Status") != "8" || rr.Header().Get("Content-Type") != "application/grpc-web-text" {
+		t.Fatalf("expected gRPC-Web RESOURCE_EXHAUSTED, got %v", rr.Header())
+	}
+}
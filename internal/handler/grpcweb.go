@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"api-gateway/internal/service"
+)
+
+const (
+	// grpcWebAllowHeaders are allowed in preflights that do not list request headers.
+	grpcWebAllowHeaders = "Content-Type, X-Grpc-Web, X-User-Agent, Grpc-Timeout, Authorization, X-API-Key"
+	// grpcWebExposeHeaders lets browsers read the status of trailers-only responses.
+	grpcWebExposeHeaders = "Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin"
+)
+
+// CORS answers preflight requests for gRPC-Web routes and adds CORS headers to
+// gRPC-Web calls from allowed origins. It wraps the whole middleware chain so
+// that gateway rejections (e.g. rate limiting) are readable by the browser too.
+func (p *ProxyHandler) CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			gw := p.grpcWebRoute(r)
+			if gw == nil || !gw.AllowOrigin(origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin")
+			h.Set("Access-Control-Allow-Methods", http.MethodPost)
+			allow := r.Header.Get("Access-Control-Request-Headers")
+			if allow == "" {
+				allow = grpcWebAllowHeaders
+			}
+			h.Set("Access-Control-Allow-Headers", allow)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(gw.MaxAgeSec))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if service.IsGRPCWebRequest(r) {
+			if m, ok := p.router.Match(r); ok && m.Route.GRPCWeb != nil && m.Route.GRPCWeb.AllowOrigin(origin) {
+				h := w.Header()
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Add("Vary", "Origin")
+				h.Set("Access-Control-Expose-Headers", grpcWebExposeHeaders)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcWebRoute returns the gRPC-Web settings of the route a preflighted call would match.
+func (p *ProxyHandler) grpcWebRoute(r *http.Request) *service.GRPCWeb {
+	probe := r.WithContext(r.Context())
+	probe.Method = http.MethodPost
+	probe.Header = r.Header.Clone()
+	probe.Header.Set("Content-Type", service.GRPCWebContentType)
+	if m, ok := p.router.Match(probe); ok {
+		return m.Route.GRPCWeb
+	}
+	return nil
+}
+
+// serveGRPCWeb translates a gRPC-Web call into native gRPC and the response back.
+func (p *ProxyHandler) serveGRPCWeb(w http.ResponseWriter, r *http.Request, match *service.RouteMatch) {
+	ct := r.Header.Get("Content-Type")
+	text := service.IsGRPCWebText(ct)
+	suffix := strings.TrimPrefix(strings.TrimPrefix(ct, service.GRPCWebTextContentType), service.GRPCWebContentType)
+
+	out := r.Clone(r.Context())
+	out.Header.Set("Content-Type", "application/grpc"+suffix)
+	out.Header.Set("Te", "trailers")
+	if text {
+		out.Body = io.NopCloser(base64.NewDecoder(base64.StdEncoding, r.Body))
+		out.ContentLength = -1
+		out.Header.Del("Content-Length")
+	}
+
+	gw := &grpcWebWriter{ResponseWriter: w, text: text}
+	p.proxy(match).ServeHTTP(gw, out)
+	gw.finish()
+}
+
+// grpcWebWriter turns a native gRPC response into gRPC-Web: the content type is
+// rewritten, trailers are appended to the body as a trailer frame, and for
+// grpc-web-text every write is base64 encoded.
+type grpcWebWriter struct {
+	http.ResponseWriter
+	text        bool
+	wroteHeader bool
+	trailers    []string // trailer names announced by the upstream
+}
+
+func (g *grpcWebWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	h := g.Header()
+	for _, v := range h.Values("Trailer") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				g.trailers = append(g.trailers, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	h.Del("Trailer")
+	h.Del("Content-Length")
+	suffix := ""
+	if ct := h.Get("Content-Type"); strings.HasPrefix(ct, "application/grpc") && !strings.HasPrefix(ct, service.GRPCWebContentType) {
+		suffix = strings.TrimPrefix(ct, "application/grpc")
+	}
+	if g.text {
+		h.Set("Content-Type", service.GRPCWebTextContentType+suffix)
+	} else {
+		h.Set("Content-Type", service.GRPCWebContentType+suffix)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *grpcWebWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if !g.text {
+		return g.ResponseWriter.Write(b)
+	}
+	// each write is padded on its own so streamed messages reach the client promptly
+	if _, err := io.WriteString(g.ResponseWriter, base64.StdEncoding.EncodeToString(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to flush).
+func (g *grpcWebWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// finish writes the upstream trailers as a gRPC-Web trailer frame. Trailers-only
+// responses already carry their status in the headers and get no frame.
+func (g *grpcWebWriter) finish() {
+	h := g.Header()
+	var block bytes.Buffer
+	add := func(name string, values []string) {
+		for _, v := range values {
+			block.WriteString(strings.ToLower(name) + ": " + v + "\r\n")
+		}
+	}
+	// the reverse proxy announces trailers in map order; sort for a stable frame
+	names := append([]string(nil), g.trailers...)
+	for k := range h {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			names = append(names, k)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.TrimPrefix(names[i], http.TrailerPrefix) < strings.TrimPrefix(names[j], http.TrailerPrefix)
+	})
+	for _, k := range names {
+		add(strings.TrimPrefix(k, http.TrailerPrefix), h.Values(k))
+		h.Del(k)
+	}
+	if block.Len() == 0 {
+		return
+	}
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = 0x80 // trailer frame flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	g.Write(append(frame, block.Bytes()...))
+}
@@ -4,10 +4,18 @@ import (
 	"encoding/json"
 	"net/http"
 	"time"
+
+	"api-gateway/internal/service"
 )
 
 // HealthHandler handles health check requests.
-type HealthHandler struct{}
+type HealthHandler struct {
+	// Clusters are the upstream clusters whose target health feeds readiness and status.
+	Clusters map[string]*service.Cluster
+	// Routes, when set, limit readiness to the primary clusters of its routes, so
+	// canary, mirror and unused clusters are reported without failing readiness.
+	Routes *service.Router
+}
 
 // LivenessResponse represents liveness probe response.
 type LivenessResponse struct {
@@ -17,8 +25,9 @@ type LivenessResponse struct {
 
 // ReadinessResponse represents readiness probe response.
 type ReadinessResponse struct {
-	Status string `json:"status"`
-	Redis  string `json:"redis"`
+	Status    string            `json:"status"`
+	Redis     string            `json:"redis"`
+	Upstreams map[string]string `json:"upstreams,omitempty"`
 }
 
 // Liveness returns 200 if the service is running.
@@ -30,26 +39,56 @@ func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// Readiness returns 200 if the service is ready to serve traffic.
+// Readiness returns 200 if the service is ready to serve traffic, i.e. every
+// route's primary cluster has at least one healthy target, and 503 otherwise.
 // In production, check Redis connectivity, database, etc.
 func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	redisStatus := "ok"
 	// In production, perform actual health check on Redis
-	json.NewEncoder(w).Encode(ReadinessResponse{
+	resp := ReadinessResponse{
 		Status: "ready",
 		Redis:  redisStatus,
-	})
+	}
+	if len(h.Clusters) > 0 {
+		var primary map[string]bool
+		if h.Routes != nil {
+			primary = make(map[string]bool)
+			for _, route := range h.Routes.Routes() {
+				primary[route.Cluster] = true
+			}
+		}
+		resp.Upstreams = make(map[string]string, len(h.Clusters))
+		for name, c := range h.Clusters {
+			if c.Ready() {
+				resp.Upstreams[name] = "ok"
+				continue
+			}
+			resp.Upstreams[name] = "unavailable"
+			if primary == nil || primary[name] {
+				resp.Status = "not_ready"
+			}
+		}
+	}
+	if resp.Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
 }
 
 // Status returns detailed status information.
 func (h *HealthHandler) Status(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	upstreams := make(map[string][]service.TargetStatus, len(h.Clusters))
+	for name, c := range h.Clusters {
+		upstreams[name] = c.Status()
+	}
 	status := map[string]interface{}{
 		"service":   "api-gateway",
 		"version":   "1.0.0",
 		"timestamp": time.Now().Unix(),
 		"uptime":    time.Since(startTime).Seconds(),
+		"upstreams": upstreams,
 	}
 	json.NewEncoder(w).Encode(status)
 }
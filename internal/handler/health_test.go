@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/service"
+)
+
+func TestReadinessReflectsUpstreamHealth(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	c, err := service.NewCluster(config.ClusterConfig{
+		Name:        "api",
+		URL:         down.URL,
+		HealthCheck: &config.HealthCheckConfig{IntervalMs: 5, UnhealthyThreshold: 1},
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := &HealthHandler{Clusters: map[string]*service.Cluster{"api": c}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.StartHealthChecks(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Ready() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	rr := httptest.NewRecorder()
+	h.Readiness(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rr.Code)
+	}
+	var resp ReadinessResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Status != "not_ready" || resp.Upstreams["api"] != "unavailable" {
+		t.Fatalf("unexpected readiness response: %+v", resp)
+	}
+
+	rr = httptest.NewRecorder()
+	h.Status(rr, httptest.NewRequest("GET", "/status", nil))
+	var status struct {
+		Upstreams map[string][]service.TargetStatus `json:"upstreams"`
+	}
+	json.NewDecoder(rr.Body).Decode(&status)
+	if len(status.Upstreams["api"]) != 1 || status.Upstreams["api"][0].Healthy {
+		t.Fatalf("unexpected status upstreams: %+v", status.Upstreams)
+	}
+}
+
+func TestReadinessIgnoresNonPrimaryClusters(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	clusters := map[string]*service.Cluster{}
+	for name, url := range map[string]string{"api": up.URL, "shadow": down.URL, "canary": down.URL} {
+		c, err := service.NewCluster(config.ClusterConfig{
+			Name:        name,
+			URL:         url,
+			HealthCheck: &config.HealthCheckConfig{IntervalMs: 5, UnhealthyThreshold: 1},
+		}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		clusters[name] = c
+	}
+	router, err := service.NewRouter([]config.RouteConfig{{
+		Name:    "api",
+		Cluster: "api",
+		Canary:  &config.CanaryConfig{Cluster: "canary", Weight: 10},
+		Mirror:  &config.MirrorConfig{Cluster: "shadow"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := &HealthHandler{Clusters: clusters, Routes: router}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, c := range clusters {
+		c.StartHealthChecks(ctx)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for (clusters["shadow"].Ready() || clusters["canary"].Ready()) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	rr := httptest.NewRecorder()
+	h.Readiness(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 while the primary cluster is up, got %d", rr.Code)
+	}
+	var resp ReadinessResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Status != "ready" || resp.Upstreams["api"] != "ok" || resp.Upstreams["shadow"] != "unavailable" || resp.Upstreams["canary"] != "unavailable" {
+		t.Fatalf("unexpected readiness response: %+v", resp)
+	}
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api-gateway/internal/service"
+
+	"github.com/rs/zerolog/log"
+)
+
+// mirrorResult is the outcome of a shadow request.
+type mirrorResult struct {
+	status  int
+	latency time.Duration
+	err     error
+	dropped string // reason the shadow was not sent, if any
+}
+
+// mirrorStart is a shadow request waiting for the primary to read the body.
+type mirrorStart struct {
+	body   *service.MirrorBody
+	result <-chan mirrorResult
+}
+
+// startMirror sends a shadow copy of r to the route's mirror cluster in the
+// background. It returns nil when the request is not mirrored. The body is
+// copied while the primary reads it and the shadow is sent once it has been
+// read in full, so the primary is never delayed. Shadow failures never reach
+// the client and go through a plain transport: no retries, no outlier ejection.
+func (p *ProxyHandler) startMirror(r *http.Request, match *service.RouteMatch) *mirrorStart {
+	m := match.Route.Mirror
+	if m == nil || r.Header.Get("Upgrade") != "" || !m.Sample() {
+		return nil
+	}
+	if !m.Acquire() {
+		p.mirrorDropped(match.Route.Name, "concurrency")
+		return nil
+	}
+	tee := m.Tee(r)
+	if tee == nil {
+		m.Release()
+		p.mirrorDropped(match.Route.Name, "body_too_large")
+		return nil
+	}
+	req, cancel := m.Request(r, match)
+	p.director(req)
+	cluster := p.clusters[m.Cluster]
+
+	done := make(chan mirrorResult, 1)
+	go func() {
+		defer m.Release()
+		defer cancel()
+		body, reason := tee.Wait()
+		if reason != "" {
+			done <- mirrorResult{dropped: reason}
+			return
+		}
+		service.SetMirrorBody(req, body)
+		start := time.Now()
+		resp, err := cluster.Forward(req)
+		if err != nil {
+			done <- mirrorResult{err: err}
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		done <- mirrorResult{status: resp.StatusCode, latency: time.Since(start)}
+	}()
+	return &mirrorStart{body: tee, result: done}
+}
+
+// recordMirror waits for the shadow outcome and records it next to the primary one.
+func (p *ProxyHandler) recordMirror(route string, status int, latency time.Duration, shadow <-chan mirrorResult) {
+	res := <-shadow
+	if res.dropped != "" {
+		p.mirrorDropped(route, res.dropped)
+		return
+	}
+	if res.err != nil {
+		log.Debug().Err(res.err).Str("route", route).Msg("shadow request failed")
+	}
+	if p.metrics == nil {
+		return
+	}
+	shadowCode := "error"
+	if res.err == nil {
+		shadowCode = strconv.Itoa(res.status)
+		p.metrics.MirrorLatency.WithLabelValues(route, "shadow").Observe(res.latency.Seconds())
+	}
+	p.metrics.MirrorLatency.WithLabelValues(route, "primary").Observe(latency.Seconds())
+	p.metrics.MirrorResponses.WithLabelValues(route, strconv.Itoa(status), shadowCode).Inc()
+}
+
+func (p *ProxyHandler) mirrorDropped(route, reason string) {
+	if p.metrics != nil {
+		p.metrics.MirrorDropped.WithLabelValues(route, reason).Inc()
+	}
+}
+
+// statusRecorder captures the final status code written to the client.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 && code >= 200 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to flush).
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -1,31 +1,254 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
+	"time"
 
+	"api-gateway/internal/config"
 	"api-gateway/internal/metrics"
 	"api-gateway/internal/service"
+
+	"github.com/rs/zerolog/log"
 )
 
-// ProxyHandler forwards requests to a downstream service after rate-limiting.
+// ProxyHandler routes requests to upstream clusters after rate-limiting.
 type ProxyHandler struct {
-	proxy   *httputil.ReverseProxy
-	limiter *service.Limiter
-	metrics *metrics.Registry
+	router   *service.Router
+	clusters map[string]*service.Cluster
+	proxies  map[routeCluster]*httputil.ReverseProxy
+	headers  *service.HeaderRules // global header rules, may be nil
+	limiter  *service.Limiter
+	metrics  *metrics.Registry
+}
+
+// NewProxyHandler builds a proxy for every cluster in the route table.
+func NewProxyHandler(rc config.RoutesConfig, l *service.Limiter, m *metrics.Registry) (*ProxyHandler, error) {
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
+	router, err := service.NewRouter(rc.Routes)
+	if err != nil {
+		return nil, err
+	}
+	p := &ProxyHandler{
+		router:   router,
+		clusters: make(map[string]*service.Cluster, len(rc.Clusters)),
+		proxies:  make(map[routeCluster]*httputil.ReverseProxy),
+		limiter:  l,
+		metrics:  m,
+	}
+	if rc.Headers != nil {
+		if p.headers, err = service.NewHeaderRules(*rc.Headers); err != nil {
+			return nil, err
+		}
+	}
+	for _, cc := range rc.Clusters {
+		c, err := service.NewCluster(cc, m)
+		if err != nil {
+			return nil, err
+		}
+		p.clusters[c.Name] = c
+	}
+	// one proxy per route and cluster so each route keeps its own flush interval
+	for _, route := range router.Routes() {
+		names := []string{route.Cluster}
+		if route.Canary != nil {
+			names = append(names, route.Canary.Cluster)
+		}
+		for _, name := range names {
+			p.proxies[routeCluster{route, name}] = &httputil.ReverseProxy{
+				Director:       p.director,
+				Transport:      p.clusters[name],
+				FlushInterval:  route.FlushInterval,
+				ModifyResponse: p.modifyResponse,
+				ErrorHandler:   p.proxyError,
+			}
+		}
+	}
+	return p, nil
 }
 
-func NewProxyHandler(downstream string, l *service.Limiter, m *metrics.Registry) *ProxyHandler {
-	u, _ := url.Parse(downstream)
-	rp := httputil.NewSingleHostReverseProxy(u)
-	return &ProxyHandler{proxy: rp, limiter: l, metrics: m}
+// routeCluster identifies the reverse proxy serving a route through one of its clusters.
+type routeCluster struct {
+	route   *service.Route
+	cluster string
+}
+
+// proxy returns the reverse proxy for a route match.
+func (p *ProxyHandler) proxy(m *service.RouteMatch) *httputil.ReverseProxy {
+	return p.proxies[routeCluster{m.Route, m.Cluster}]
+}
+
+// Router returns the route table.
+func (p *ProxyHandler) Router() *service.Router {
+	return p.router
+}
+
+// Clusters returns the upstream clusters by name.
+func (p *ProxyHandler) Clusters() map[string]*service.Cluster {
+	return p.clusters
 }
 
 func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Proxy directly; rate limiting handled by middleware earlier.
-	// Attach any metrics or headers if needed.
-	ctx := r.Context()
-	_ = ctx
-	p.proxy.ServeHTTP(w, r)
+	// Rate limiting is handled by middleware earlier.
+	match, ok := p.router.Match(r)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "route_not_found", "no route matches request")
+		return
+	}
+	if c := match.Route.Canary; c != nil {
+		variant, cookie := c.Choose(r)
+		if cookie != nil {
+			http.SetCookie(w, cookie)
+		}
+		match.Variant = variant
+		if variant == service.VariantCanary {
+			match.Cluster = c.Cluster
+		}
+	}
+	service.AddLogField(r.Context(), "route", match.Route.Name)
+	service.AddLogField(r.Context(), "cluster", match.Cluster)
+	service.AddLogField(r.Context(), "variant", match.Variant)
+	if p.metrics != nil {
+		p.metrics.RouteRequests.WithLabelValues(match.Route.Name, match.Variant).Inc()
+	}
+
+	upgrade := match.Route.WebSocket != nil && service.IsWebSocketUpgrade(r)
+	ctx := service.WithRouteMatch(r.Context(), match)
+	// WebSocket connections are bounded by their lifetime limit, not the request timeout.
+	if timeout := requestTimeout(r, match.Route); timeout > 0 && !upgrade {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	r = r.WithContext(ctx)
+	if match.Route.Rewrite != nil {
+		r = match.Route.Rewrite.Apply(r)
+	}
+	if upgrade {
+		p.serveWebSocket(w, r, match)
+		return
+	}
+	if match.Route.GRPCWeb != nil && service.IsGRPCWebRequest(r) {
+		p.serveGRPCWeb(w, r, match)
+		return
+	}
+	if shadow := p.startMirror(r, match); shadow != nil {
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		p.proxy(match).ServeHTTP(rec, r)
+		shadow.body.Abandon()
+		go p.recordMirror(match.Route.Name, rec.status, time.Since(start), shadow.result)
+		return
+	}
+	p.proxy(match).ServeHTTP(w, r)
+}
+
+// requestTimeout returns the total deadline for a request: the route's total
+// timeout, shortened (never extended) by a client X-Request-Timeout header.
+func requestTimeout(r *http.Request, route *service.Route) time.Duration {
+	timeout := time.Duration(route.Timeout.TotalMs) * time.Millisecond
+	if v := r.Header.Get(service.RequestTimeoutHeader); v != "" {
+		if d, ok := service.ParseRequestTimeout(v); ok && (timeout == 0 || d < timeout) {
+			timeout = d
+		}
+	}
+	return timeout
+}
+
+// director prepares the outbound request; the cluster transport fills in the target.
+func (p *ProxyHandler) director(req *http.Request) {
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// explicitly disable User-Agent so it's not set to default value
+		req.Header.Set("User-Agent", "")
+	}
+	vars := service.TemplateVars(req)
+	if p.headers != nil {
+		p.headers.Request.Apply(req.Header, vars)
+	}
+	if m, ok := service.RouteMatchFromContext(req.Context()); ok {
+		if m.Route.Headers != nil {
+			m.Route.Headers.Request.Apply(req.Header, vars)
+		}
+		if m.Route.Transform != nil {
+			m.Route.Transform.PrepareRequest(req)
+		}
+	}
+}
+
+// modifyResponse applies response header rules and the route's body transform
+// before the response is written to the client.
+func (p *ProxyHandler) modifyResponse(resp *http.Response) error {
+	vars := service.TemplateVars(resp.Request)
+	if p.headers != nil {
+		p.headers.Response.Apply(resp.Header, vars)
+	}
+	m, ok := service.RouteMatchFromContext(resp.Request.Context())
+	if !ok {
+		return nil
+	}
+	if m.Route.Headers != nil {
+		m.Route.Headers.Response.Apply(resp.Header, vars)
+	}
+	if m.Route.Transform != nil {
+		return m.Route.Transform.Response(resp)
+	}
+	return nil
+}
+
+// proxyError maps upstream failures to JSON errors.
+func (p *ProxyHandler) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Warn().Err(err).Str("path", r.URL.Path).Str("request_id", r.Header.Get("X-Request-ID")).Msg("upstream request failed")
+	if errors.Is(err, service.ErrNoHealthyUpstream) {
+		writeError(w, r, http.StatusServiceUnavailable, "no_healthy_upstream", err.Error())
+		return
+	}
+	if kind := timeoutKind(r, err); kind != "" {
+		if p.metrics != nil {
+			route := ""
+			if m, ok := service.RouteMatchFromContext(r.Context()); ok {
+				route = m.Route.Name
+			}
+			p.metrics.UpstreamTimeouts.WithLabelValues(route, kind).Inc()
+		}
+		writeError(w, r, http.StatusGatewayTimeout, "upstream_timeout", "upstream "+kind+" timeout exceeded")
+		return
+	}
+	if errors.Is(err, service.ErrResponseTransform) {
+		writeError(w, r, http.StatusBadGateway, "response_transform_failed", "upstream response could not be transformed")
+		return
+	}
+	writeError(w, r, http.StatusBadGateway, "upstream_error", "upstream request failed")
+}
+
+// timeoutKind classifies err as a total, response header or connect timeout.
+func timeoutKind(r *http.Request, err error) string {
+	switch {
+	case errors.Is(r.Context().Err(), context.DeadlineExceeded):
+		return "total"
+	case errors.Is(err, service.ErrUpstreamHeaderTimeout):
+		return "response_header"
+	case service.IsConnectTimeout(err):
+		return "connect"
+	}
+	return ""
+}
+
+// writeError writes a JSON error body in the same shape as the middleware rejections.
+// gRPC requests get the equivalent grpc-status instead.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, msg string) {
+	if service.RejectGRPC(w, r, status, msg) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      code,
+		"message":    msg,
+		"request_id": r.Header.Get("X-Request-ID"),
+	})
 }
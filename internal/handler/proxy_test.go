@@ -0,0 +1,466 @@
+package handler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+	"api-gateway/internal/service"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProxyHandlerRoutesToCluster(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("users"))
+	}))
+	defer users.Close()
+	orders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("orders"))
+	}))
+	defer orders.Close()
+
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes: []config.RouteConfig{
+			{Path: "/users/{id}", Cluster: "users"},
+			{PathPrefix: "/orders", Cluster: "orders"},
+		},
+		Clusters: []config.ClusterConfig{
+			{Name: "users", URL: users.URL},
+			{Name: "orders", URL: orders.URL},
+		},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for path, want := range map[string]string{"/users/1": "users", "/orders/9": "orders"} {
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != want {
+			t.Fatalf("%s: expected 200 %q, got %d %q", path, want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestProxyHandlerNoRoute(t *testing.T) {
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes:   []config.RouteConfig{{PathPrefix: "/api", Cluster: "api"}},
+		Clusters: []config.ClusterConfig{{Name: "api", URL: "http://127.0.0.1:1"}},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/other", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if body["error"] != "route_not_found" || body["request_id"] != "req-1" {
+		t.Fatalf("unexpected body: %v", body)
+	}
+}
+
+func TestNewProxyHandlerUnknownCluster(t *testing.T) {
+	_, err := NewProxyHandler(config.RoutesConfig{
+		Routes: []config.RouteConfig{{PathPrefix: "/", Cluster: "missing"}},
+	}, nil, nil)
+	if err == nil {
+		t.Fatal("expected error for unknown cluster")
+	}
+}
+
+func TestProxyHandlerTimeouts(t *testing.T) {
+	var gotTimeout atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTimeout.Store(r.Header.Get("X-Request-Timeout"))
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	m := metrics.NewRegistry()
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes: []config.RouteConfig{
+			{Name: "slow", PathPrefix: "/slow", Cluster: "api", Timeout: &config.TimeoutConfig{TotalMs: 1000}},
+			{Name: "header", PathPrefix: "/header", Cluster: "api", Timeout: &config.TimeoutConfig{ResponseHeaderMs: 20}},
+		},
+		Clusters: []config.ClusterConfig{{Name: "api", URL: backend.URL}},
+	}, nil, m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		path, clientTimeout, kind string
+	}{
+		{"/slow", "50ms", "total"},
+		{"/slow", "30", "total"},
+		{"/header", "", "response_header"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("X-Request-ID", "req-timeout")
+		if tt.clientTimeout != "" {
+			req.Header.Set("X-Request-Timeout", tt.clientTimeout)
+		}
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, req)
+		if rr.Code != http.StatusGatewayTimeout {
+			t.Fatalf("%s: expected 504, got %d", tt.path, rr.Code)
+		}
+		var body map[string]string
+		json.NewDecoder(rr.Body).Decode(&body)
+		if body["error"] != "upstream_timeout" || body["request_id"] != "req-timeout" {
+			t.Fatalf("%s: unexpected body %v", tt.path, body)
+		}
+		if got := testutil.ToFloat64(m.UpstreamTimeouts.WithLabelValues(strings.TrimPrefix(tt.path, "/"), tt.kind)); got == 0 {
+			t.Fatalf("%s: expected %s timeout to be counted", tt.path, tt.kind)
+		}
+	}
+
+	// the remaining deadline is forwarded upstream in milliseconds
+	if v, _ := strconv.Atoi(gotTimeout.Load().(string)); v != 0 {
+		t.Fatalf("header route has no deadline, expected no forwarded timeout, got %d", v)
+	}
+	req := httptest.NewRequest("GET", "/slow", nil)
+	req.Header.Set("X-Request-Timeout", "5s") // cannot extend the route's 1s
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if v, _ := strconv.Atoi(gotTimeout.Load().(string)); v <= 0 || v > 1000 {
+		t.Fatalf("expected forwarded deadline within route timeout, got %d", v)
+	}
+}
+
+func TestProxyHandlerRewritesUpstreamPath(t *testing.T) {
+	var gotPath, gotHost string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotHost = r.URL.Path, r.Host
+	}))
+	defer backend.Close()
+
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes: []config.RouteConfig{{
+			PathPrefix: "/api/v1/orders",
+			Cluster:    "orders",
+			Rewrite:    &config.RewriteConfig{StripPrefix: "/api/v1", Host: "orders.internal"},
+		}},
+		Clusters: []config.ClusterConfig{{Name: "orders", URL: backend.URL}},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a middleware in front of the proxy keeps seeing the public path
+	var seenByMiddleware string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.ServeHTTP(w, r)
+		seenByMiddleware = r.URL.Path
+	})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/orders/7", nil))
+	if gotPath != "/orders/7" || gotHost != "orders.internal" {
+		t.Fatalf("unexpected upstream request: path=%s host=%s", gotPath, gotHost)
+	}
+	if seenByMiddleware != "/api/v1/orders/7" {
+		t.Fatalf("middleware saw rewritten path %s", seenByMiddleware)
+	}
+}
+
+func TestProxyHandlerHeaderRules(t *testing.T) {
+	var upstream http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+		w.Header().Set("Server", "orders/1.2")
+		w.Header().Set("X-Debug", "internal")
+	}))
+	defer backend.Close()
+
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Headers: &config.HeaderRulesConfig{
+			Request:  config.HeaderOpsConfig{Set: map[string]string{"X-Gateway-Request-ID": "${request_id}"}},
+			Response: config.HeaderOpsConfig{Remove: []string{"Server"}},
+		},
+		Routes: []config.RouteConfig{{
+			Name:    "user-orders",
+			Path:    "/users/{id}/orders",
+			Cluster: "orders",
+			Headers: &config.HeaderRulesConfig{
+				Request:  config.HeaderOpsConfig{Set: map[string]string{"X-User": "${param.id}"}},
+				Response: config.HeaderOpsConfig{Set: map[string]string{"X-Route": "${route}"}, Remove: []string{"X-Debug"}},
+			},
+		}},
+		Clusters: []config.ClusterConfig{{Name: "orders", URL: backend.URL}},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/users/42/orders", nil)
+	req.Header.Set("X-Request-ID", "req-9")
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+
+	if upstream.Get("X-Gateway-Request-ID") != "req-9" || upstream.Get("X-User") != "42" {
+		t.Fatalf("unexpected upstream headers: %v", upstream)
+	}
+	if rr.Header().Get("Server") != "" || rr.Header().Get("X-Debug") != "" {
+		t.Fatalf("expected response headers to be removed: %v", rr.Header())
+	}
+	if rr.Header().Get("X-Route") != "user-orders" {
+		t.Fatalf("expected X-Route=user-orders, got %q", rr.Header().Get("X-Route"))
+	}
+}
+
+func TestProxyHandlerTransformsJSON(t *testing.T) {
+	var accept string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept-Encoding")
+		body := []byte(`{"id":"u1","email":"ada@example.com","password_hash":"x"}`)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/users/broken" {
+			body = body[:10]
+		}
+		if strings.Contains(accept, "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			zw.Write(body)
+			zw.Close()
+			return
+		}
+		w.Write(body)
+	}))
+	defer backend.Close()
+
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes: []config.RouteConfig{{
+			PathPrefix: "/users",
+			Cluster:    "users",
+			Transform: &config.TransformConfig{
+				Drop:   []string{"$.password_hash"},
+				Mask:   []config.MaskConfig{{Path: "$.email", Pattern: "^[^@]+"}},
+				Rename: []config.RenameConfig{{Path: "$.id", To: "user_id"}},
+			},
+		}},
+		Clusters: []config.ClusterConfig{{Name: "users", URL: backend.URL}},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	const want = `{"email":"****@example.com","user_id":"u1"}`
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	if accept != "gzip" || rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip round trip, upstream saw %q", accept)
+	}
+	if rr.Header().Get("Content-Length") != strconv.Itoa(rr.Body.Len()) {
+		t.Fatalf("Content-Length %s does not match body of %d bytes", rr.Header().Get("Content-Length"), rr.Body.Len())
+	}
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("expected a gzip body: %v", err)
+	}
+	if b, _ := io.ReadAll(zr); string(b) != want {
+		t.Fatalf("expected %s, got %s", want, b)
+	}
+
+	// clients without gzip get a plain body; the transport decompresses upstream gzip
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/users/1", nil))
+	if rr.Body.String() != want || rr.Header().Get("Content-Length") != strconv.Itoa(len(want)) {
+		t.Fatalf("expected %s with matching Content-Length, got %s (%s)", want, rr.Body.String(), rr.Header().Get("Content-Length"))
+	}
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/users/broken", nil))
+	if rr.Code != http.StatusBadGateway || !strings.Contains(rr.Body.String(), "response_transform_failed") {
+		t.Fatalf("expected 502 response_transform_failed, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestProxyHandlerCanarySplit(t *testing.T) {
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("stable"))
+	}))
+	defer stable.Close()
+	canary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("canary"))
+	}))
+	defer canary.Close()
+
+	m := metrics.NewRegistry()
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes: []config.RouteConfig{{
+			Name: "api", PathPrefix: "/", Cluster: "v1",
+			Canary: &config.CanaryConfig{Cluster: "v2", Weight: 0, StickyOn: "cookie:release"},
+		}},
+		Clusters: []config.ClusterConfig{
+			{Name: "v1", URL: stable.URL},
+			{Name: "v2", URL: canary.URL},
+		},
+	}, nil, m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Body.String() != "stable" {
+		t.Fatalf("expected stable at weight 0, got %q", rr.Body.String())
+	}
+	ck := rr.Result().Cookies()
+	if len(ck) != 1 {
+		t.Fatalf("expected sticky cookie, got %v", ck)
+	}
+	if b, err := strconv.Atoi(ck[0].Value); err != nil || b < 0 || b > 99 {
+		t.Fatalf("expected the sticky cookie to hold a bucket, got %q", ck[0].Value)
+	}
+
+	// Shift all traffic through the admin endpoint.
+	admin := NewCanaryAdminHandler(p.Router())
+	ar := httptest.NewRecorder()
+	admin.ServeHTTP(ar, httptest.NewRequest("POST", "/admin/canaries", strings.NewReader(`{"route":"api","weight":100}`)))
+	if ar.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", ar.Code)
+	}
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Body.String() != "canary" {
+		t.Fatalf("expected canary at weight 100, got %q", rr.Body.String())
+	}
+
+	if got := testutil.ToFloat64(m.RouteRequests.WithLabelValues("api", "stable")); got != 1 {
+		t.Fatalf("expected 1 stable request, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.RouteRequests.WithLabelValues("api", "canary")); got != 1 {
+		t.Fatalf("expected 1 canary request, got %v", got)
+	}
+
+	ar = httptest.NewRecorder()
+	admin.ServeHTTP(ar, httptest.NewRequest("POST", "/admin/canaries", strings.NewReader(`{"route":"api","weight":150}`)))
+	if ar.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid weight, got %d", ar.Code)
+	}
+}
+
+func TestProxyHandlerMirror(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	defer primary.Close()
+	shadowBody := make(chan string, 1)
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		shadowBody <- string(b) + " " + r.Header.Get(service.MirrorHeader)
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	m := metrics.NewRegistry()
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes: []config.RouteConfig{{
+			Name: "api", PathPrefix: "/", Cluster: "v1",
+			Mirror: &config.MirrorConfig{Cluster: "v2", Percent: 100},
+		}},
+		Clusters: []config.ClusterConfig{
+			{Name: "v1", URL: primary.URL},
+			{Name: "v2", URL: shadow.URL, OutlierDetection: &config.OutlierDetectionConfig{ConsecutiveErrors: 1}},
+		},
+	}, nil, m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The primary response must not wait for the (blocked, failing) shadow.
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("POST", "/orders", strings.NewReader("hello")))
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello" {
+		t.Fatalf("expected primary 200 hello, got %d %q", rr.Code, rr.Body.String())
+	}
+	select {
+	case got := <-shadowBody:
+		if got != "hello true" {
+			t.Fatalf("unexpected shadow request %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shadow request not sent")
+	}
+	release <- struct{}{}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(m.MirrorResponses.WithLabelValues("api", "200", "500")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected mirror comparison metric for 200 vs 500")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := testutil.ToFloat64(m.UpstreamEjections.WithLabelValues("v2", strings.TrimPrefix(shadow.URL, "http://"))); n != 0 {
+		t.Fatalf("shadow failures must not eject targets, got %v ejections", n)
+	}
+}
+
+func TestProxyHandlerStreamsServerSentEvents(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes:   []config.RouteConfig{{PathPrefix: "/", Cluster: "events", FlushIntervalMs: 1000}},
+		Clusters: []config.ClusterConfig{{Name: "events", URL: upstream.URL}},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	got := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		got <- line
+	}()
+	select {
+	case line := <-got:
+		if line != "data: first\n" {
+			t.Fatalf("unexpected event %q", line)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("event was buffered instead of flushed immediately")
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+
+	"api-gateway/internal/service"
+)
+
+// serveWebSocket proxies an upgrade request on a route with WebSocket limits.
+// It returns once the connection has closed.
+func (p *ProxyHandler) serveWebSocket(w http.ResponseWriter, r *http.Request, match *service.RouteMatch) {
+	route := match.Route.Name
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = service.ClientIP(r)
+	}
+	release, err := match.Route.WebSocket.Acquire(key)
+	if err != nil {
+		limit, status := "route", http.StatusServiceUnavailable
+		if errors.Is(err, service.ErrWebSocketKeyLimit) {
+			limit, status = "key", http.StatusTooManyRequests
+		}
+		if p.metrics != nil {
+			p.metrics.WebSocketRejected.WithLabelValues(route, limit).Inc()
+		}
+		writeError(w, r, status, "too_many_connections", err.Error())
+		return
+	}
+	defer release()
+
+	hw := &hijackWriter{ResponseWriter: w, ws: match.Route.WebSocket, onUpgrade: func() {
+		if p.metrics != nil {
+			p.metrics.WebSocketConnections.WithLabelValues(route).Inc()
+		}
+	}}
+	p.proxy(match).ServeHTTP(hw, r)
+	if hw.conn == nil {
+		return // upstream refused the upgrade
+	}
+
+	in, out, reason := hw.conn.Stats()
+	service.AddLogField(r.Context(), "websocket_bytes_in", in)
+	service.AddLogField(r.Context(), "websocket_bytes_out", out)
+	service.AddLogField(r.Context(), "websocket_close_reason", reason)
+	if p.metrics != nil {
+		p.metrics.WebSocketConnections.WithLabelValues(route).Dec()
+		p.metrics.WebSocketDuration.WithLabelValues(route).Observe(hw.conn.Duration().Seconds())
+		p.metrics.WebSocketBytes.WithLabelValues(route, "in").Add(float64(in))
+		p.metrics.WebSocketBytes.WithLabelValues(route, "out").Add(float64(out))
+		p.metrics.WebSocketClosed.WithLabelValues(route, reason).Inc()
+	}
+}
+
+// hijackWriter hands the reverse proxy a client connection with the route's
+// WebSocket limits applied.
+type hijackWriter struct {
+	http.ResponseWriter
+	ws        *service.WebSocket
+	onUpgrade func()
+	conn      *service.WebSocketConn
+}
+
+func (h *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(h.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the server may have read client frames sent right after the handshake
+	var buffered []byte
+	if n := brw.Reader.Buffered(); n > 0 {
+		peek, _ := brw.Reader.Peek(n)
+		buffered = append(buffered, peek...)
+	}
+	h.conn = h.ws.Wrap(conn, buffered)
+	h.onUpgrade()
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), brw.Writer), nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (h *hijackWriter) Unwrap() http.ResponseWriter {
+	return h.ResponseWriter
+}
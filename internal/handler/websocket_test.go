@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// echoWebSocket accepts any upgrade and echoes raw bytes back.
+func echoWebSocket() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}))
+}
+
+func newWebSocketProxy(t *testing.T, upstream string, ws config.WebSocketConfig, m *metrics.Registry) *httptest.Server {
+	t.Helper()
+	p, err := NewProxyHandler(config.RoutesConfig{
+		Routes:   []config.RouteConfig{{Name: "ws", PathPrefix: "/", Cluster: "ws", WebSocket: &ws, Timeout: &config.TimeoutConfig{TotalMs: 50}}},
+		Clusters: []config.ClusterConfig{{Name: "ws", URL: upstream}},
+	}, nil, m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return httptest.NewServer(p)
+}
+
+// dialWebSocket performs a handshake and returns the connection with its status.
+func dialWebSocket(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET /chat HTTP/1.1\r\nHost: gw\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+	}
+	return conn, br, resp.StatusCode
+}
+
+// maskedFrame builds a masked client text frame with an all-zero mask.
+func maskedFrame(payload string) []byte {
+	return append([]byte{0x81, 0x80 | byte(len(payload)), 0, 0, 0, 0}, payload...)
+}
+
+func TestProxyHandlerWebSocketRelayAndMetrics(t *testing.T) {
+	upstream := echoWebSocket()
+	defer upstream.Close()
+	m := metrics.NewRegistry()
+	srv := newWebSocketProxy(t, upstream.URL, config.WebSocketConfig{MaxConnectionsPerKey: 1}, m)
+	defer srv.Close()
+
+	conn, br, status := dialWebSocket(t, srv)
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", status)
+	}
+	// the route's 50ms total timeout must not cut the connection
+	time.Sleep(100 * time.Millisecond)
+	frame := maskedFrame("hello")
+	conn.Write(frame)
+	echo := make([]byte, len(frame))
+	if _, err := io.ReadFull(br, echo); err != nil || !bytes.Equal(echo, frame) {
+		t.Fatalf("expected echo, got %x (%v)", echo, err)
+	}
+	if got := testutil.ToFloat64(m.WebSocketConnections.WithLabelValues("ws")); got != 1 {
+		t.Fatalf("expected 1 open connection, got %v", got)
+	}
+
+	// a second connection from the same client exceeds the per-key limit
+	second, _, status := dialWebSocket(t, srv)
+	second.Close()
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 for second connection, got %d", status)
+	}
+
+	conn.Close()
+	waitFor(t, func() bool { return testutil.ToFloat64(m.WebSocketClosed.WithLabelValues("ws", "normal")) == 1 })
+	if got := testutil.ToFloat64(m.WebSocketBytes.WithLabelValues("ws", "in")); got != float64(len(frame)) {
+		t.Fatalf("expected %d bytes in, got %v", len(frame), got)
+	}
+	if got := testutil.ToFloat64(m.WebSocketRejected.WithLabelValues("ws", "key")); got != 1 {
+		t.Fatalf("expected 1 rejected upgrade, got %v", got)
+	}
+}
+
+func TestProxyHandlerWebSocketMessageRate(t *testing.T) {
+	upstream := echoWebSocket()
+	defer upstream.Close()
+	m := metrics.NewRegistry()
+	srv := newWebSocketProxy(t, upstream.URL, config.WebSocketConfig{MaxMessagesPerSec: 1}, m)
+	defer srv.Close()
+
+	conn, br, status := dialWebSocket(t, srv)
+	defer conn.Close()
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", status)
+	}
+	for i := 0; i < 3; i++ {
+		conn.Write(maskedFrame("x"))
+		time.Sleep(10 * time.Millisecond)
+	}
+	rest, _ := io.ReadAll(br)
+	if i := bytes.IndexByte(rest, 0x88); i < 0 || len(rest) < i+4 || int(rest[i+2])<<8|int(rest[i+3]) != 1008 {
+		t.Fatalf("expected policy violation close frame, got %x", rest)
+	}
+	waitFor(t, func() bool { return testutil.ToFloat64(m.WebSocketClosed.WithLabelValues("ws", "message_rate")) == 1 })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -4,15 +4,66 @@ import (
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Registry struct {
 	Requests    prometheus.Counter
 	RateLimited prometheus.Counter
-	// in production you would add histograms for latency and gauges etc.
+	// RateLimitDecisions counts rate-limit decisions per matched policy rule.
+	RateLimitDecisions *prometheus.CounterVec
+
+	// RouteRequests counts proxied requests per route and traffic split variant.
+	RouteRequests *prometheus.CounterVec
+	// MirrorResponses pairs primary and shadow status codes of mirrored requests.
+	MirrorResponses *prometheus.CounterVec
+	// MirrorLatency observes primary and shadow latency of mirrored requests.
+	MirrorLatency *prometheus.HistogramVec
+	// MirrorDropped counts sampled requests that were not mirrored.
+	MirrorDropped *prometheus.CounterVec
+	// WebSocketConnections tracks open WebSocket connections per route.
+	WebSocketConnections *prometheus.GaugeVec
+	// WebSocketDuration observes how long WebSocket connections stay open.
+	WebSocketDuration *prometheus.HistogramVec
+	// WebSocketBytes counts bytes relayed over WebSocket connections.
+	WebSocketBytes *prometheus.CounterVec
+	// WebSocketClosed counts closed WebSocket connections by reason.
+	WebSocketClosed *prometheus.CounterVec
+	// WebSocketRejected counts upgrades refused by connection limits.
+	WebSocketRejected *prometheus.CounterVec
+	// TLSCertificateExpiry is the NotAfter time of each loaded TLS certificate.
+	TLSCertificateExpiry *prometheus.GaugeVec
+	// UpstreamInflight tracks requests currently outstanding per upstream target.
+	UpstreamInflight *prometheus.GaugeVec
+	// UpstreamRequests counts completed upstream attempts per target and status code.
+	UpstreamRequests *prometheus.CounterVec
+	// UpstreamConnections tracks open upstream connections per cluster.
+	UpstreamConnections *prometheus.GaugeVec
+	// UpstreamConnectionsUsed counts connections taken from the pool, by whether they were reused.
+	UpstreamConnectionsUsed *prometheus.CounterVec
+	// UpstreamDialErrors counts failed connection attempts per cluster.
+	UpstreamDialErrors *prometheus.CounterVec
+	// UpstreamTargets tracks the number of targets per cluster.
+	UpstreamTargets *prometheus.GaugeVec
+	// DiscoveryRefreshes counts service discovery rounds per cluster and result.
+	DiscoveryRefreshes *prometheus.CounterVec
+	// UpstreamHealthy is 1 while a target passes active health checks, 0 otherwise.
+	UpstreamHealthy *prometheus.GaugeVec
+	// UpstreamEjections counts outlier-detection ejections per upstream target.
+	UpstreamEjections *prometheus.CounterVec
+	// UpstreamRetries counts retried upstream attempts per cluster.
+	UpstreamRetries *prometheus.CounterVec
+	// UpstreamRetryBudgetExhausted counts retries skipped because the cluster's budget was spent.
+	UpstreamRetryBudgetExhausted *prometheus.CounterVec
+	// UpstreamTimeouts counts requests answered with 504 per route and timeout kind.
+	UpstreamTimeouts *prometheus.CounterVec
+
+	reg *prometheus.Registry
 }
 
+// NewRegistry creates a Registry backed by its own prometheus registry, so several
+// instances (e.g. in tests) can coexist.
 func NewRegistry() *Registry {
 	r := &Registry{
 		Requests: prometheus.NewCounter(prometheus.CounterOpts{
@@ -23,11 +74,117 @@ func NewRegistry() *Registry {
 			Name: "gateway_rate_limited_total",
 			Help: "Total rate limited responses",
 		}),
+		RateLimitDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_rate_limit_decisions_total",
+			Help: "Rate limit decisions per matched policy rule and result (allowed, limited)",
+		}, []string{"rule", "result"}),
+		RouteRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_route_requests_total",
+			Help: "Proxied requests per route and variant (stable, canary)",
+		}, []string{"route", "variant"}),
+		MirrorResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_mirror_responses_total",
+			Help: "Mirrored requests by primary and shadow status code (shadow_code=error on failure)",
+		}, []string{"route", "primary_code", "shadow_code"}),
+		MirrorLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gateway_mirror_latency_seconds",
+			Help:    "Latency of mirrored requests by side (primary, shadow)",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "side"}),
+		MirrorDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_mirror_dropped_total",
+			Help: "Sampled requests not mirrored, by reason (concurrency, body_too_large, body_incomplete)",
+		}, []string{"route", "reason"}),
+		WebSocketConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_websocket_connections",
+			Help: "Open WebSocket connections per route",
+		}, []string{"route"}),
+		WebSocketDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gateway_websocket_connection_duration_seconds",
+			Help:    "Lifetime of WebSocket connections",
+			Buckets: []float64{1, 5, 30, 60, 300, 900, 1800, 3600, 14400},
+		}, []string{"route"}),
+		WebSocketBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_websocket_bytes_total",
+			Help: "Bytes relayed over WebSocket connections by direction (in: from client, out: to client)",
+		}, []string{"route", "direction"}),
+		WebSocketClosed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_websocket_closed_total",
+			Help: "Closed WebSocket connections by reason (normal, idle_timeout, max_lifetime, message_rate)",
+		}, []string{"route", "reason"}),
+		WebSocketRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_websocket_rejected_total",
+			Help: "WebSocket upgrades rejected by connection limits (route, key)",
+		}, []string{"route", "limit"}),
+		TLSCertificateExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_tls_certificate_expiry_timestamp_seconds",
+			Help: "Expiry time of loaded TLS certificates as a Unix timestamp",
+		}, []string{"certificate", "subject"}),
+		UpstreamInflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_upstream_inflight_requests",
+			Help: "Requests currently in flight per upstream target",
+		}, []string{"cluster", "target"}),
+		UpstreamRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_upstream_requests_total",
+			Help: "Upstream attempts per target and status code",
+		}, []string{"cluster", "target", "code"}),
+		UpstreamConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_upstream_connections",
+			Help: "Open upstream connections per cluster",
+		}, []string{"cluster"}),
+		UpstreamConnectionsUsed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_upstream_connections_used_total",
+			Help: "Upstream connections obtained for requests, by reuse from the idle pool (true, false)",
+		}, []string{"cluster", "reused"}),
+		UpstreamDialErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_upstream_dial_errors_total",
+			Help: "Failed upstream connection attempts per cluster",
+		}, []string{"cluster"}),
+		UpstreamTargets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_upstream_targets",
+			Help: "Targets per upstream cluster",
+		}, []string{"cluster"}),
+		DiscoveryRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_discovery_refreshes_total",
+			Help: "Service discovery rounds per cluster and result (ok, error)",
+		}, []string{"cluster", "result"}),
+		UpstreamHealthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gateway_upstream_healthy",
+			Help: "Active health check state per upstream target (1 healthy, 0 unhealthy)",
+		}, []string{"cluster", "target"}),
+		UpstreamEjections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_upstream_ejections_total",
+			Help: "Outlier detection ejections per upstream target",
+		}, []string{"cluster", "target"}),
+		UpstreamRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_upstream_retries_total",
+			Help: "Retried upstream attempts per cluster",
+		}, []string{"cluster"}),
+		UpstreamRetryBudgetExhausted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_upstream_retry_budget_exhausted_total",
+			Help: "Retries skipped because the cluster retry budget was exhausted",
+		}, []string{"cluster"}),
+		UpstreamTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_upstream_timeouts_total",
+			Help: "Upstream timeouts per route and kind (connect, response_header, total)",
+		}, []string{"route", "kind"}),
+		reg: prometheus.NewRegistry(),
 	}
-	prometheus.MustRegister(r.Requests, r.RateLimited)
+	r.reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		r.Requests, r.RateLimited, r.RateLimitDecisions,
+		r.RouteRequests, r.MirrorResponses, r.MirrorLatency, r.MirrorDropped,
+		r.WebSocketConnections, r.WebSocketDuration, r.WebSocketBytes, r.WebSocketClosed, r.WebSocketRejected,
+		r.TLSCertificateExpiry,
+		r.UpstreamInflight, r.UpstreamRequests, r.UpstreamHealthy, r.UpstreamEjections,
+		r.UpstreamConnections, r.UpstreamConnectionsUsed, r.UpstreamDialErrors,
+		r.UpstreamTargets, r.DiscoveryRefreshes,
+		r.UpstreamRetries, r.UpstreamRetryBudgetExhausted, r.UpstreamTimeouts,
+	)
 	return r
 }
 
 func (r *Registry) Handler() http.Handler {
-	return promhttp.Handler()
+	return promhttp.HandlerFor(r.reg, promhttp.HandlerOpts{})
 }
@@ -4,6 +4,8 @@ import (
 	"log"
 	"net/http"
 	"sync"
+
+	"api-gateway/internal/service"
 )
 
 // APIKeyStore manages API keys and their permissions
@@ -20,6 +22,7 @@ type APIKey struct {
 	Enabled   bool     // Whether the key is active
 	Paths     []string // Allowed paths (if empty, all allowed for role)
 	RateLimit int      // Requests per second (0 = unlimited)
+	Tier      string   // Rate limit tier matched by policy rules, e.g. "premium"
 }
 
 // NewAPIKeyStore creates a new API key store
@@ -135,7 +138,7 @@ func (am *APIKeyMiddleware) Handler() func(http.Handler) http.Handler {
 			key, err := am.store.ValidateKey(apiKey, r.URL.Path)
 			if err != nil {
 				log.Printf("API key validation failed: %v", err)
-				http.Error(w, "Unauthorized: invalid API key", http.StatusUnauthorized)
+				httpError(w, r, "Unauthorized: invalid API key", http.StatusUnauthorized)
 				return
 			}
 
@@ -144,6 +147,28 @@ func (am *APIKeyMiddleware) Handler() func(http.Handler) http.Handler {
 			r.Header.Set("X-API-Key-Name", key.Name)
 			r.Header.Set("X-Auth-Method", "api-key")
 
+			id := service.Identity{Principal: key.Name, Role: key.Role, Tier: key.Tier}
+			next.ServeHTTP(w, r.WithContext(service.WithIdentity(r.Context(), id)))
+		})
+	}
+}
+
+// Identify returns a middleware that stores the identity of an enabled API key,
+// including its tier, in the request context without rejecting anything:
+// requests with an unknown key continue unauthenticated, and the key still
+// identifies them to the rate limiter. The key's paths are not checked, so a key
+// keeps its tier on paths it may not use until Handler rejects the request.
+func (am *APIKeyMiddleware) Identify() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := service.IdentityFromContext(r.Context()); !ok {
+				if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+					if key, ok := am.store.GetKey(apiKey); ok && key.Enabled {
+						id := service.Identity{Principal: key.Name, Role: key.Role, Tier: key.Tier}
+						r = r.WithContext(service.WithIdentity(r.Context(), id))
+					}
+				}
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -155,6 +180,7 @@ func DefaultAPIKeys() *APIKeyStore {
 	store.AddKey(&APIKey{
 		Key:       "key_admin_prod_123",
 		Name:      "Admin Production Key",
+		Tier:      "premium",
 		Role:      "admin",
 		Enabled:   true,
 		Paths:     []string{"/admin/*", "/api/*", "/metrics"},
@@ -163,6 +189,7 @@ func DefaultAPIKeys() *APIKeyStore {
 	store.AddKey(&APIKey{
 		Key:       "key_user_prod_456",
 		Name:      "User Production Key",
+		Tier:      "standard",
 		Role:      "user",
 		Enabled:   true,
 		Paths:     []string{"/api/*"},
@@ -171,6 +198,7 @@ func DefaultAPIKeys() *APIKeyStore {
 	store.AddKey(&APIKey{
 		Key:       "key_viewer_prod_789",
 		Name:      "Viewer Key",
+		Tier:      "standard",
 		Role:      "viewer",
 		Enabled:   true,
 		Paths:     []string{"/metrics", "/health"},
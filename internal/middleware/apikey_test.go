@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"api-gateway/internal/service"
 )
 
 func TestAPIKeyMiddleware_ValidKey(t *testing.T) {
@@ -14,6 +16,7 @@ func TestAPIKeyMiddleware_ValidKey(t *testing.T) {
 		Role:    "user",
 		Enabled: true,
 		Paths:   []string{"/api/*"},
+		Tier:    "premium",
 	})
 
 	am := NewAPIKeyMiddleware(store)
@@ -23,6 +26,9 @@ func TestAPIKeyMiddleware_ValidKey(t *testing.T) {
 		if role != "user" {
 			t.Errorf("expected role 'user', got %s", role)
 		}
+		if id, _ := service.IdentityFromContext(r.Context()); id.Role != "user" || id.Tier != "premium" {
+			t.Errorf("expected the key's role and tier in the identity, got %+v", id)
+		}
 	}))
 
 	req := httptest.NewRequest("GET", "/api/users", nil)
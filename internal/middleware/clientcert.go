@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"api-gateway/internal/service"
+)
+
+// ClientCert turns a verified TLS client certificate into the caller's identity.
+// It stores the identity in the request context, sets X-User-ID and X-User-Role
+// for RBAC and the upstream, and forwards certificate details in the configured
+// headers. Those headers are always removed from incoming requests first, so
+// plaintext clients cannot forge them.
+func ClientCert(auth *service.ClientAuth) func(http.Handler) http.Handler {
+	h := auth.Headers()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Del(h.Subject)
+			r.Header.Del(h.SANs)
+			r.Header.Del(h.Fingerprint)
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id := auth.Identify(r.TLS.VerifiedChains[0][0])
+			r2 := r.Clone(service.WithIdentity(r.Context(), id))
+			r2.Header.Set("X-User-ID", id.Principal)
+			r2.Header.Del("X-User-Role")
+			if id.Role != "" {
+				r2.Header.Set("X-User-Role", id.Role)
+			}
+			r2.Header.Set(h.Subject, id.Subject)
+			if len(id.SANs) > 0 {
+				r2.Header.Set(h.SANs, strings.Join(id.SANs, ","))
+			}
+			r2.Header.Set(h.Fingerprint, id.Fingerprint)
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/service"
+)
+
+func TestClientCert(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "billing", OrganizationalUnit: []string{"ops"}},
+		DNSNames:     []string{"billing.internal"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+
+	auth, err := service.NewClientAuth(config.ClientAuthConfig{
+		CAFiles:    []string{caFile},
+		Identities: []config.CertIdentityRule{{Field: config.CertFieldOU, Pattern: "ops", Role: "operator", Principal: config.CertFieldDNS}},
+		Headers:    config.ClientCertHeaders{Subject: "X-Cert-Subject"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var upstream http.Header
+	var id service.Identity
+	rbac := NewRBACMiddleware(map[string][]string{"operator": {"/api/*"}})
+	h := ClientCert(auth)(rbac.Handler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header
+		id, _ = service.IdentityFromContext(r.Context())
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "https://gw/api/invoices", nil)
+	req.Header.Set("X-User-Role", "admin")
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if id.Principal != "billing.internal" || id.Role != "operator" {
+		t.Errorf("unexpected identity %+v", id)
+	}
+	if upstream.Get("X-User-ID") != "billing.internal" || upstream.Get("X-User-Role") != "operator" {
+		t.Errorf("unexpected identity headers %v", upstream)
+	}
+	if upstream.Get("X-Cert-Subject") != "CN=billing,OU=ops" || upstream.Get("X-Client-Cert-SAN") != "billing.internal" {
+		t.Errorf("unexpected certificate headers %v", upstream)
+	}
+
+	// certificate headers sent by plaintext clients are dropped
+	req = httptest.NewRequest(http.MethodGet, "http://gw/api/invoices", nil)
+	req.Header.Set("X-User-Role", "operator")
+	req.Header.Set("X-Cert-Subject", "CN=forged")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if upstream.Get("X-Cert-Subject") != "" {
+		t.Errorf("forged certificate header reached the upstream: %v", upstream)
+	}
+}
@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"net/http"
+
+	"api-gateway/internal/service"
+)
+
+// httpError is http.Error for gateway rejections: gRPC clients get the
+// matching grpc-status instead of a plain-text body.
+func httpError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+	if service.RejectGRPC(w, r, status, msg) {
+		return
+	}
+	http.Error(w, msg, status)
+}
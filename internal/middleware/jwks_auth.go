@@ -131,12 +131,12 @@ func NewJWKSMiddleware(jwksClient *JWKSClient, expectedIssuer, expectedAudience
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			auth := r.Header.Get("Authorization")
 			if auth == "" {
-				writeUnauthorized(w, "missing Authorization header")
+				writeUnauthorized(w, r, "missing Authorization header")
 				return
 			}
 			parts := strings.Fields(auth)
 			if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-				writeUnauthorized(w, "invalid Authorization header format")
+				writeUnauthorized(w, r, "invalid Authorization header format")
 				return
 			}
 			tokenStr := parts[1]
@@ -164,21 +164,21 @@ func NewJWKSMiddleware(jwksClient *JWKSClient, expectedIssuer, expectedAudience
 				return pubKey, nil
 			})
 			if err != nil {
-				writeUnauthorized(w, "invalid token: "+err.Error())
+				writeUnauthorized(w, r, "invalid token: "+err.Error())
 				return
 			}
 			if !token.Valid {
-				writeUnauthorized(w, "invalid token")
+				writeUnauthorized(w, r, "invalid token")
 				return
 			}
 
 			// Validate claims
 			if claims.ExpiresAt == nil || time.Now().After(claims.ExpiresAt.Time) {
-				writeUnauthorized(w, "token is expired")
+				writeUnauthorized(w, r, "token is expired")
 				return
 			}
 			if expectedIssuer != "" && claims.Issuer != expectedIssuer {
-				writeUnauthorized(w, "invalid token issuer")
+				writeUnauthorized(w, r, "invalid token issuer")
 				return
 			}
 			if expectedAudience != "" {
@@ -191,7 +191,7 @@ func NewJWKSMiddleware(jwksClient *JWKSClient, expectedIssuer, expectedAudience
 					}
 				}
 				if !found {
-					writeUnauthorized(w, "invalid token audience")
+					writeUnauthorized(w, r, "invalid token audience")
 					return
 				}
 			}
@@ -8,6 +8,8 @@ import (
 	"strings"
 	"time"
 
+	"api-gateway/internal/service"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -19,68 +21,93 @@ type CustomClaims struct {
 
 // NewJWTMiddleware returns a middleware that validates JWT tokens signed with HMAC.
 // It checks the signing method, the token expiration and issuer (`iss`).
-// On success it injects `X-User-ID` (from `sub`) and `X-User-Role` into request headers.
+// On success it injects `X-User-ID` (from `sub`) and `X-User-Role` into request headers
+// and stores the caller's identity, including all claims, in the request context.
 func NewJWTMiddleware(secret []byte, expectedIssuer string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			auth := r.Header.Get("Authorization")
-			if auth == "" {
-				writeUnauthorized(w, "missing Authorization header")
+			id, msg := authenticateJWT(r, secret, expectedIssuer)
+			if msg != "" {
+				writeUnauthorized(w, r, msg)
 				return
 			}
-			parts := strings.Fields(auth)
-			if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-				writeUnauthorized(w, "invalid Authorization header format")
-				return
+			r2 := r.Clone(service.WithIdentity(r.Context(), id))
+			if id.Principal != "" {
+				r2.Header.Set("X-User-ID", id.Principal)
 			}
-			tokenStr := parts[1]
-
-			var claims CustomClaims
-			token, err := jwt.ParseWithClaims(tokenStr, &claims, func(t *jwt.Token) (interface{}, error) {
-				// enforce HMAC
-				if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-				}
-				return secret, nil
-			})
-			if err != nil {
-				writeUnauthorized(w, "invalid token: "+err.Error())
-				return
-			}
-			if !token.Valid {
-				writeUnauthorized(w, "invalid token")
-				return
+			if id.Role != "" {
+				r2.Header.Set("X-User-Role", id.Role)
 			}
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
 
-			// Validate registered claims: exp and iss
-			if claims.ExpiresAt == nil {
-				writeUnauthorized(w, "token missing exp claim")
-				return
-			}
-			if time.Now().After(claims.ExpiresAt.Time) {
-				writeUnauthorized(w, "token is expired")
-				return
-			}
-			if expectedIssuer != "" {
-				if claims.Issuer != expectedIssuer {
-					writeUnauthorized(w, "invalid token issuer")
-					return
+// NewJWTIdentity stores the identity of a valid bearer token in the request
+// context, as NewJWTMiddleware does, but never rejects: requests without a
+// valid token continue unauthenticated. It lets rate-limit rules match JWT
+// roles and claims on routes that do not require a token.
+func NewJWTIdentity(secret []byte, expectedIssuer string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := service.IdentityFromContext(r.Context()); !ok && r.Header.Get("Authorization") != "" {
+				if id, msg := authenticateJWT(r, secret, expectedIssuer); msg == "" {
+					r = r.WithContext(service.WithIdentity(r.Context(), id))
 				}
 			}
-
-			// Inject headers and continue
-			r2 := r.Clone(r.Context())
-			if claims.Subject != "" {
-				r2.Header.Set("X-User-ID", claims.Subject)
-			}
-			if claims.Role != "" {
-				r2.Header.Set("X-User-Role", claims.Role)
-			}
-			next.ServeHTTP(w, r2)
+			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// authenticateJWT validates the request's bearer token and returns the caller's
+// identity, with all claims for rate-limit rules, or why the token was rejected.
+func authenticateJWT(r *http.Request, secret []byte, expectedIssuer string) (service.Identity, string) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return service.Identity{}, "missing Authorization header"
+	}
+	parts := strings.Fields(auth)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return service.Identity{}, "invalid Authorization header format"
+	}
+	tokenStr := parts[1]
+
+	// one verified parse yields both the registered claims and the full set
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		// enforce HMAC
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return service.Identity{}, "invalid token: " + err.Error()
+	}
+	if !token.Valid {
+		return service.Identity{}, "invalid token"
+	}
+
+	// Validate registered claims: exp and iss
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return service.Identity{}, "token missing exp claim"
+	}
+	if time.Now().After(exp.Time) {
+		return service.Identity{}, "token is expired"
+	}
+	if expectedIssuer != "" {
+		if iss, _ := claims.GetIssuer(); iss != expectedIssuer {
+			return service.Identity{}, "invalid token issuer"
+		}
+	}
+
+	sub, _ := claims.GetSubject()
+	role, _ := claims["role"].(string)
+	return service.Identity{Principal: sub, Role: role, Claims: claims}, ""
+}
+
 // NewJWTMiddlewareFromEnv reads `JWT_SECRET` and `JWT_ISS` from environment and
 // returns the middleware. If `JWT_SECRET` is missing it returns an error.
 func NewJWTMiddlewareFromEnv() (func(http.Handler) http.Handler, error) {
@@ -92,7 +119,10 @@ func NewJWTMiddlewareFromEnv() (func(http.Handler) http.Handler, error) {
 	return NewJWTMiddleware([]byte(secret), issuer), nil
 }
 
-func writeUnauthorized(w http.ResponseWriter, msg string) {
+func writeUnauthorized(w http.ResponseWriter, r *http.Request, msg string) {
+	if service.RejectGRPC(w, r, http.StatusUnauthorized, msg) {
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusUnauthorized)
 	_ = json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized", "message": msg})
@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"api-gateway/internal/service"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -43,6 +45,10 @@ func TestJWTMiddleware_Valid(t *testing.T) {
 		if got := r.Header.Get("X-User-Role"); got != "admin" {
 			t.Fatalf("expected X-User-Role=admin got=%s", got)
 		}
+		id, ok := service.IdentityFromContext(r.Context())
+		if !ok || id.Principal != "user123" || id.Role != "admin" || id.Claims["iss"] != issuer {
+			t.Fatalf("expected the identity with its claims in the context, got %+v", id)
+		}
 		w.WriteHeader(http.StatusOK)
 	}))
 
@@ -108,3 +114,21 @@ func TestJWTMiddleware_Invalid(t *testing.T) {
 	// create env RSA keys quickly (not necessary to validate signing method here, check rejection)
 	os.Setenv("JWT_SECRET", "")
 }
+
+func TestJWTMiddleware_GRPCUnauthenticated(t *testing.T) {
+	mw := NewJWTMiddleware([]byte("test-secret"), "")
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler must not be called")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/helloworld.Greeter/SayHello", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Grpc-Status") != "16" {
+		t.Fatalf("expected UNAUTHENTICATED trailers, got %d %v", rr.Code, rr.Header())
+	}
+	if rr.Header().Get("Grpc-Message") != "missing Authorization header" {
+		t.Fatalf("unexpected grpc-message %q", rr.Header().Get("Grpc-Message"))
+	}
+}
@@ -20,7 +20,7 @@ func RequestSizeLimit(maxBytes int64) func(http.Handler) http.Handler {
 					Int64("content_length", r.ContentLength).
 					Int64("max_size", maxBytes).
 					Msg("request body too large")
-				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				httpError(w, r, "request body too large", http.StatusRequestEntityTooLarge)
 				return
 			}
 			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
@@ -82,7 +82,7 @@ func (cb *CircuitBreaker) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if cb.IsOpen() {
 			log.Warn().Msg("circuit breaker open")
-			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			httpError(w, r, "service unavailable", http.StatusServiceUnavailable)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -4,19 +4,85 @@ import (
 	"net/http"
 	"time"
 
+	"api-gateway/internal/service"
+
 	"github.com/rs/zerolog/log"
 )
 
 // Logging is a middleware that logs requests as structured JSON including request id and latency.
+// Inner handlers can add fields (e.g. the matched route) with service.AddLogField.
+// Streamed responses are logged once the stream closes, with the final byte count;
+// WebSocket upgrades are logged when requested and again once the connection closes.
 func Logging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
+		if service.IsWebSocketUpgrade(r) {
+			log.Info().Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Str("request_id", r.Header.Get("X-Request-ID")).
+				Msg("websocket upgrade requested")
+		}
+		ctx, fields := service.WithLogFields(r.Context())
+		lw := &logResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r.WithContext(ctx))
 		dur := time.Since(start)
-		log.Info().Str("method", r.Method).
+		extra := fields.Map()
+		msg := "request completed"
+		if _, ok := extra["websocket_close_reason"]; ok {
+			msg = "websocket connection closed"
+			lw.status = http.StatusSwitchingProtocols
+		}
+		ev := log.Info().Str("method", r.Method).
 			Str("path", r.URL.Path).
 			Str("request_id", r.Header.Get("X-Request-ID")).
-			Dur("latency", dur).
-			Msg("request completed")
+			Int("status", lw.status).
+			Int64("bytes", lw.bytes).
+			Fields(extra)
+		if lw.flushed {
+			ev = ev.Bool("streamed", true).Dur("ttfb", lw.firstByte.Sub(start))
+		}
+		ev.Dur("latency", dur).Msg(msg)
 	})
 }
+
+// logResponseWriter records the status and body size of a response. It
+// forwards flushes so streamed responses are not buffered.
+type logResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	bytes     int64
+	flushed   bool
+	firstByte time.Time
+}
+
+func (w *logResponseWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *logResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.firstByte.IsZero() {
+		w.firstByte = time.Now()
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *logResponseWriter) Flush() {
+	if w.firstByte.IsZero() {
+		w.firstByte = time.Now()
+	}
+	w.flushed = true
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to hijack).
+func (w *logResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
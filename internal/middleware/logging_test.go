@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestLogging_StreamedResponse(t *testing.T) {
+	var buf bytes.Buffer
+	orig := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = orig }()
+
+	handler := Logging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: a\n\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("data: b\n\n"))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+
+	if !rec.Flushed {
+		t.Error("expected flush to reach the underlying writer")
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid log line %q: %v", buf.String(), err)
+	}
+	if entry["status"] != float64(200) || entry["bytes"] != float64(18) || entry["streamed"] != true {
+		t.Errorf("unexpected log entry: %v", entry)
+	}
+}
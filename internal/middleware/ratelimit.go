@@ -3,7 +3,7 @@ package middleware
 import (
 	"context"
 	"encoding/json"
-	"net"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -16,45 +16,74 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ClientPolicyName names policies stored for one client and path ("<client>:<path>").
+const ClientPolicyName = "client"
+
+// CostHeader is the upstream response header reporting the true cost of a
+// request; the part exceeding the cost already taken is debited afterwards.
+const CostHeader = "X-RateLimit-Cost"
+
 // RateLimit builds a middleware using the given limiter service and policy store.
-func RateLimit(l *service.Limiter, m *metrics.Registry, ps config.PolicyStore) func(http.Handler) http.Handler {
+// rules select the policy of each client (nil applies only exact "<client>:<path>"
+// policies and the default); routes set the cost of each request (nil costs one);
+// headers selects the response header format (config.RateLimitHeaders*).
+func RateLimit(l *service.Limiter, m *metrics.Registry, ps config.PolicyStore, rules *service.PolicyResolver, routes *service.Router, headers string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			key := r.Header.Get("X-API-Key")
 			if key == "" {
-				key = clientIP(r)
+				if id, ok := service.IdentityFromContext(r.Context()); ok && id.Principal != "" {
+					key = "principal:" + id.Principal
+				} else {
+					key = service.ClientIP(r)
+				}
 			}
 			lookup := strings.Join([]string{key, r.URL.Path}, ":")
 
-			// lookup policy
-			pc := ps.GetPolicy(lookup)
-			// map to service.Policy
-			p := service.Policy{
-				Algorithm: service.AlgorithmType(pc.Algorithm),
-				Capacity:  pc.Capacity,
-				Rate:      pc.Rate,
-				WindowMs:  pc.WindowMs,
-				Limit:     pc.Limit,
+			// the client's own policy: an exact override, else the first matching
+			// rule; the cost comes from the route whichever policy applies
+			match := rules.Resolve(r)
+			cost := routes.RateLimitCost(r)
+			pc, ok := ps.LookupPolicy(lookup)
+			if ok {
+				match.Name = ClientPolicyName
+			} else {
+				pc = ps.GetPolicy(match.Policy)
+			}
+			rule := match.Name
+			// the client's counter is prefixed like the shared dimensions so that
+			// no API key can share state with them
+			limits := []service.Limit{{Key: "client:" + lookup, Policy: policyFromConfig(rule, pc), Cost: cost}}
+			// then the shared dimensions that are configured
+			for _, dim := range []struct{ name, policy, key string }{
+				{"ip", "ip", "ip:" + service.ClientIP(r)},
+				{"endpoint", "endpoint:" + r.URL.Path, "endpoint:" + r.URL.Path},
+				{"global", "global", "global"},
+			} {
+				if pc, ok := ps.LookupPolicy(dim.policy); ok {
+					limits = append(limits, service.Limit{Key: dim.key, Policy: policyFromConfig(dim.name, pc), Cost: cost})
+				}
 			}
 
 			ctx, cancel := context.WithTimeout(r.Context(), 50*time.Millisecond)
 			defer cancel()
-			allowed, remaining, err := l.Allow(ctx, lookup, p)
+			d, err := l.EvaluateAll(ctx, limits)
 			if err != nil {
 				log.Error().Err(err).Msg("rate limit evaluation error")
-				http.Error(w, "internal", http.StatusInternalServerError)
+				httpError(w, r, "internal", http.StatusInternalServerError)
 				return
 			}
-
-			// attach rate-limit headers
-			w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(p.Capacity, 10))
-			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
-			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(1*time.Second).Unix(), 10))
+			setRateLimitHeaders(w.Header(), headers, d, time.Now())
+			w.Header().Set("X-RateLimit-Rule", rule)
 
 			m.Requests.Inc()
-			if !allowed {
+			if !d.Allowed {
 				m.RateLimited.Inc()
-				w.Header().Set("Retry-After", "1")
+				m.RateLimitDecisions.WithLabelValues(rule, "limited").Inc()
+				w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(d.RetryAfter, 1), 10))
+				if service.RejectGRPC(w, r, http.StatusTooManyRequests, "rate limit exceeded") {
+					return
+				}
 				w.WriteHeader(http.StatusTooManyRequests)
 				json.NewEncoder(w).Encode(map[string]interface{}{
 					"error":      "rate_limited",
@@ -63,20 +92,117 @@ func RateLimit(l *service.Limiter, m *metrics.Registry, ps config.PolicyStore) f
 				})
 				return
 			}
-			next.ServeHTTP(w, r)
+			m.RateLimitDecisions.WithLabelValues(rule, "allowed").Inc()
+			cw := &costResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+			if !cw.wroteHeader {
+				cw.takeCost()
+			}
+
+			// debit what the upstream reports beyond the cost taken, capped at the
+			// client's quota; lower costs are not refunded
+			reported := cw.cost
+			if quota := limits[0].Policy.Quota(); quota > 0 && reported > quota {
+				log.Warn().Int64("cost", reported).Int64("quota", quota).Str("rule", rule).Msg("capping rate limit cost at the quota")
+				reported = quota
+			}
+			if extra := reported - cost; extra > 0 {
+				for i := range limits {
+					limits[i].Cost = extra
+				}
+				dctx, dcancel := context.WithTimeout(context.WithoutCancel(r.Context()), 50*time.Millisecond)
+				defer dcancel()
+				if err := l.Debit(dctx, limits); err != nil {
+					log.Error().Err(err).Int64("cost", cw.cost).Msg("rate limit debit error")
+				}
+			}
 		})
 	}
 }
 
-// clientIP attempts to extract the remote IP address.
-func clientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
+// costResponseWriter takes CostHeader out of the response and records its value;
+// values that are not positive integers are ignored.
+// It forwards flushes so streamed responses are not buffered.
+type costResponseWriter struct {
+	http.ResponseWriter
+	cost        int64
+	wroteHeader bool
+}
+
+func (w *costResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= 200 {
+		w.wroteHeader = true
+		w.takeCost()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// takeCost records and removes CostHeader. It also runs after handlers that
+// write nothing, whose headers are sent once they return.
+func (w *costResponseWriter) takeCost() {
+	if v := w.Header().Get(CostHeader); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			w.cost = n
+		}
+		w.Header().Del(CostHeader)
+	}
+}
+
+func (w *costResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *costResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
 	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to hijack).
+func (w *costResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// policyFromConfig maps a stored policy to a limiter policy.
+func policyFromConfig(name string, pc config.PolicyConfig) service.Policy {
+	return service.Policy{
+		Name:      name,
+		Algorithm: service.AlgorithmType(pc.Algorithm),
+		Capacity:  pc.Capacity,
+		Rate:      pc.Rate,
+		WindowMs:  pc.WindowMs,
+		Limit:     pc.Limit,
+	}
+}
+
+// setRateLimitHeaders reports a decision in the legacy X-RateLimit-* headers
+// and/or the IETF RateLimit and RateLimit-Policy fields.
+func setRateLimitHeaders(h http.Header, format string, d service.Decision, now time.Time) {
+	if format != config.RateLimitHeadersIETF {
+		h.Set("X-RateLimit-Limit", strconv.FormatInt(d.Policy.Quota(), 10))
+		h.Set("X-RateLimit-Remaining", strconv.FormatInt(d.Remaining, 10))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(now.Unix()+ceilSeconds(d.Reset+time.Duration(now.Nanosecond()), 0), 10))
+	}
+	if format != config.RateLimitHeadersLegacy {
+		name := d.Policy.Name
+		if name == "" {
+			name = "default"
+		}
+		name = strconv.Quote(name)
+		h.Set("RateLimit-Policy", fmt.Sprintf("%s;q=%d;w=%d", name, d.Policy.Quota(), ceilSeconds(d.Policy.Window(), 1)))
+		h.Set("RateLimit", fmt.Sprintf("%s;r=%d;t=%d", name, d.Remaining, ceilSeconds(d.Reset, 0)))
+	}
+}
+
+// ceilSeconds rounds d up to whole seconds, returning at least floor.
+func ceilSeconds(d time.Duration, floor int64) int64 {
+	s := int64((d + time.Second - 1) / time.Second)
+	if s < floor {
+		return floor
 	}
-	return host
+	return s
 }
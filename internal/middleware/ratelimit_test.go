@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+	"api-gateway/internal/repository"
+	"api-gateway/internal/service"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+	ps := config.NewPolicyStore()
+	ps.SetPolicy("k1:/search", config.PolicyConfig{Algorithm: "slidingwindow", WindowMs: 60000, Limit: 2})
+	lim := service.NewLimiter(repository.NewMemoryStore())
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	h := RateLimit(lim, metrics.NewRegistry(), ps, nil, nil, config.RateLimitHeadersBoth)(next)
+	do := func(h http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/search", nil)
+		req.Header.Set("X-API-Key", "k1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(h)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if got := w.Header().Get("X-RateLimit-Limit"); got != "2" {
+		t.Errorf("expected the sliding window limit, got %q", got)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "1" {
+		t.Errorf("expected 1 remaining, got %q", got)
+	}
+	reset, _ := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+	if d := time.Until(time.Unix(reset, 0)); d < 59*time.Second || d > 61*time.Second {
+		t.Errorf("expected reset about a window from now, got %v", d)
+	}
+	if got := w.Header().Get("RateLimit-Policy"); got != `"client";q=2;w=60` {
+		t.Errorf("unexpected RateLimit-Policy %q", got)
+	}
+	if got := w.Header().Get("RateLimit"); got != `"client";r=1;t=60` {
+		t.Errorf("unexpected RateLimit %q", got)
+	}
+
+	do(h)
+	w = do(h)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	retry, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+	if retry < 59 || retry > 60 {
+		t.Errorf("expected Retry-After until the first request leaves the window, got %q", w.Header().Get("Retry-After"))
+	}
+
+	w = do(RateLimit(lim, metrics.NewRegistry(), ps, nil, nil, config.RateLimitHeadersLegacy)(next))
+	if w.Header().Get("X-RateLimit-Limit") == "" || w.Header().Get("RateLimit") != "" {
+		t.Errorf("expected only legacy headers, got %v", w.Header())
+	}
+	w = do(RateLimit(lim, metrics.NewRegistry(), ps, nil, nil, config.RateLimitHeadersIETF)(next))
+	if w.Header().Get("X-RateLimit-Limit") != "" || w.Header().Get("RateLimit-Policy") == "" {
+		t.Errorf("expected only IETF headers, got %v", w.Header())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After in both formats")
+	}
+}
+
+func TestRateLimitTokenBucketWindow(t *testing.T) {
+	d := service.Decision{
+		Result: repository.Result{Remaining: 7, Reset: 1500 * time.Millisecond},
+		Policy: service.Policy{Name: "premium", Algorithm: service.TokenBucketAlg, Capacity: 100, Rate: 10},
+	}
+	h := http.Header{}
+	setRateLimitHeaders(h, config.RateLimitHeadersBoth, d, time.Unix(1000, 0))
+	if got := h.Get("RateLimit-Policy"); got != `"premium";q=100;w=10` {
+		t.Errorf("unexpected RateLimit-Policy %q", got)
+	}
+	if got := h.Get("RateLimit"); got != `"premium";r=7;t=2` {
+		t.Errorf("unexpected RateLimit %q", got)
+	}
+	if got := h.Get("X-RateLimit-Reset"); got != "1002" {
+		t.Errorf("expected reset rounded up to 1002, got %q", got)
+	}
+}
+
+func TestRateLimitDimensions(t *testing.T) {
+	ps := config.NewPolicyStore()
+	ps.SetPolicy("ip", config.PolicyConfig{Algorithm: "gcra", Capacity: 2, Rate: 1})
+	ps.SetPolicy("global", config.PolicyConfig{Algorithm: "slidingwindowcounter", WindowMs: 60000, Limit: 100})
+	lim := service.NewLimiter(repository.NewMemoryStore())
+	h := RateLimit(lim, metrics.NewRegistry(), ps, nil, nil, config.RateLimitHeadersIETF)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// different API keys from the same address share the per-IP limit
+	if w := do("a"); w.Code != http.StatusOK || w.Header().Get("RateLimit") != `"ip";r=1;t=1` {
+		t.Fatalf("expected the ip limit to be reported, got %d %q", w.Code, w.Header().Get("RateLimit"))
+	}
+	do("b")
+	w := do("c")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("RateLimit-Policy") != `"ip";q=2;w=2` {
+		t.Fatalf("expected the ip limit to deny, got %d %q", w.Code, w.Header().Get("RateLimit-Policy"))
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After 1, got %q", got)
+	}
+
+	// the denied request consumed nothing from the API key or global limits
+	d, err := lim.EvaluateAll(context.Background(), []service.Limit{
+		{Key: "client:c:/orders", Policy: policyFromConfig("", ps.GetPolicy("c:/orders"))},
+		{Key: "global", Policy: policyFromConfig("global", config.PolicyConfig{Algorithm: "slidingwindowcounter", WindowMs: 60000, Limit: 100})},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Policy.Name != "global" || d.Remaining != 97 {
+		t.Fatalf("expected 2 requests counted globally, got %+v", d)
+	}
+}
+
+func TestRateLimitClientKeyDoesNotCollide(t *testing.T) {
+	// API key "endpoint" on /orders looks up the endpoint policy as its own,
+	// but its counter must stay separate from the endpoint's
+	ps := config.NewPolicyStore()
+	ps.SetPolicy("endpoint:/orders", config.PolicyConfig{Algorithm: "slidingwindow", WindowMs: 60000, Limit: 2})
+	h := RateLimit(service.NewLimiter(repository.NewMemoryStore()), metrics.NewRegistry(), ps, nil, nil, config.RateLimitHeadersIETF)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("X-API-Key", "endpoint")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("request %d: expected %d, got %d", i+1, want, w.Code)
+		}
+	}
+}
+
+func TestRateLimitRules(t *testing.T) {
+	ps := config.NewPolicyStore()
+	ps.SetPolicy("bulk", config.PolicyConfig{Algorithm: "slidingwindow", WindowMs: 60000, Limit: 1})
+	rules, err := service.NewPolicyResolver(config.RateLimitRules{Rules: append(config.DefaultRateLimitRules().Rules,
+		config.PolicyRule{Name: "bulk-export", Priority: 10, Path: "/export/*", Methods: []string{"POST"}, Policy: "bulk"},
+	)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reg := metrics.NewRegistry()
+	keys := NewAPIKeyStore()
+	keys.AddKey(&APIKey{Key: "gold", Name: "Gold", Enabled: true, Tier: "premium"})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := NewAPIKeyMiddleware(keys).Handler()(RateLimit(service.NewLimiter(repository.NewMemoryStore()), reg, ps, rules, nil, config.RateLimitHeadersBoth)(next))
+	do := func(method, path, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodGet, "/orders", "gold")
+	if got := w.Header().Get("X-RateLimit-Rule"); got != "premium" {
+		t.Fatalf("expected the premium tier rule, got %q", got)
+	}
+	if got := w.Header().Get("RateLimit-Policy"); got != `"premium";q=1000;w=1` {
+		t.Errorf("expected the seeded premium policy, got %q", got)
+	}
+	// the higher-priority rule wins over the tier rule
+	if w = do(http.MethodPost, "/export/users", "gold"); w.Header().Get("X-RateLimit-Rule") != "bulk-export" {
+		t.Fatalf("expected the bulk-export rule, got %q", w.Header().Get("X-RateLimit-Rule"))
+	}
+	if w = do(http.MethodPost, "/export/users", "gold"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the bulk policy to deny, got %d", w.Code)
+	}
+	if w = do(http.MethodGet, "/export/users", ""); w.Header().Get("X-RateLimit-Rule") != service.DefaultPolicyName {
+		t.Fatalf("expected the default policy, got %q", w.Header().Get("X-RateLimit-Rule"))
+	}
+
+	if got := testutil.ToFloat64(reg.RateLimitDecisions.WithLabelValues("bulk-export", "limited")); got != 1 {
+		t.Errorf("expected one limited decision for bulk-export, got %v", got)
+	}
+	if got := testutil.ToFloat64(reg.RateLimitDecisions.WithLabelValues("premium", "allowed")); got != 1 {
+		t.Errorf("expected one allowed decision for premium, got %v", got)
+	}
+}
+
+func TestRateLimitCost(t *testing.T) {
+	ps := config.NewPolicyStore()
+	ps.SetPolicy("export", config.PolicyConfig{Algorithm: "tokenbucket", Capacity: 100, Rate: 1})
+	// a tier rule selects the policy ahead of the path rule; the cost is the route's
+	rules, err := service.NewPolicyResolver(config.RateLimitRules{Rules: []config.PolicyRule{
+		{Name: "premium", Priority: 10, Tiers: []string{"premium"}, Policy: "export"},
+		{Name: "bulk-export", Path: "/export", Policy: "export"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	routes, err := service.NewRouter([]config.RouteConfig{
+		{Name: "export", Path: "/export", Cluster: "api", RateLimitCost: 50},
+		{Name: "api", Cluster: "api"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(CostHeader, "80")
+		w.Write([]byte("ok"))
+	})
+	h := RateLimit(service.NewLimiter(repository.NewMemoryStore()), metrics.NewRegistry(), ps, rules, routes, config.RateLimitHeadersLegacy)(next)
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/export", nil)
+		req.Header.Set("X-API-Key", "k1")
+		req = req.WithContext(service.WithIdentity(req.Context(), service.Identity{Tier: "premium"}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do()
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "50" {
+		t.Fatalf("expected the request to cost 50 of 100, got %d %q", w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+	if got := w.Header().Get(CostHeader); got != "" {
+		t.Errorf("expected the upstream cost header to be removed, got %q", got)
+	}
+
+	// the upstream reported 80, so 30 more were debited: 20 left, too few for 50
+	w = do()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the deferred debit to deny the next request, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("expected Retry-After 30 for the 30 missing tokens, got %q", got)
+	}
+}
+
+func TestRateLimitCostInvalid(t *testing.T) {
+	ps := config.NewPolicyStore()
+	ps.SetPolicy("k1:/export", config.PolicyConfig{Algorithm: "slidingwindow", WindowMs: 60000, Limit: 100})
+	for _, cost := range []string{"0", "99999999999999999999", "-5", "-9223372036854775808", "lots"} {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(CostHeader, cost)
+		})
+		h := RateLimit(service.NewLimiter(repository.NewMemoryStore()), metrics.NewRegistry(), ps, nil, nil, config.RateLimitHeadersLegacy)(next)
+		for i, want := range []string{"99", "98"} {
+			req := httptest.NewRequest(http.MethodPost, "/export", nil)
+			req.Header.Set("X-API-Key", "k1")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != want {
+				t.Fatalf("cost %s, request %d: expected the cost to be ignored, got %d with %q remaining",
+					cost, i+1, w.Code, w.Header().Get("X-RateLimit-Remaining"))
+			}
+			if w.Header().Get(CostHeader) != "" {
+				t.Fatalf("cost %s: expected the header to be removed", cost)
+			}
+		}
+	}
+}
+
+func TestRateLimitCostAboveQuota(t *testing.T) {
+	ps := config.NewPolicyStore()
+	ps.SetPolicy("k1:/export", config.PolicyConfig{Algorithm: "slidingwindow", WindowMs: 60000, Limit: 100})
+	for _, cost := range []string{"101", "9223372036854775807"} {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(CostHeader, cost)
+		})
+		h := RateLimit(service.NewLimiter(repository.NewMemoryStore()), metrics.NewRegistry(), ps, nil, nil, config.RateLimitHeadersLegacy)(next)
+		do := func() *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/export", nil)
+			req.Header.Set("X-API-Key", "k1")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			return w
+		}
+		if w := do(); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "99" {
+			t.Fatalf("cost %s: expected the first request to be admitted, got %d with %q remaining",
+				cost, w.Code, w.Header().Get("X-RateLimit-Remaining"))
+		}
+		// the reported cost was capped at the quota of 100, filling the window
+		if w := do(); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+			t.Fatalf("cost %s: expected the capped debit to deny for the window, got %d after %q",
+				cost, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -30,14 +30,14 @@ func (rm *RBACMiddleware) Handler() func(http.Handler) http.Handler {
 
 			// If no role, deny access
 			if role == "" {
-				http.Error(w, "Unauthorized: no role specified", http.StatusUnauthorized)
+				httpError(w, r, "Unauthorized: no role specified", http.StatusUnauthorized)
 				return
 			}
 
 			// Check if role has access to this path
 			if !rm.hasAccessToPath(role, r.URL.Path) {
 				log.Printf("RBAC denied: role=%s path=%s", role, r.URL.Path)
-				http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+				httpError(w, r, "Forbidden: insufficient permissions", http.StatusForbidden)
 				return
 			}
 
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"api-gateway/internal/config"
+)
+
+// HTTPSRedirect redirects plaintext requests to the same URL over HTTPS on
+// httpsPort, except for the exempt paths (e.g. health probes).
+func HTTPSRedirect(httpsPort string, exempt ...string) func(http.Handler) http.Handler {
+	skip := make(map[string]bool, len(exempt))
+	for _, p := range exempt {
+		skip[p] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil || skip[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if httpsPort != "" && httpsPort != "443" {
+				host = net.JoinHostPort(host, httpsPort)
+			}
+			u := *r.URL
+			u.Scheme = "https"
+			u.Host = host
+			http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+		})
+	}
+}
+
+// HSTS sets Strict-Transport-Security on responses to requests received over TLS.
+func HSTS(cfg config.HSTSConfig) func(http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(cfg.MaxAgeSec)
+	if cfg.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if cfg.Preload {
+		value += "; preload"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", value)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
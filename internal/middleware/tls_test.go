@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	h := HTTPSRedirect("8443", "/health")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "http://api.example.com:8080/v1/orders?id=7", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusPermanentRedirect {
+		t.Fatalf("expected 308, got %d", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "https://api.example.com:8443/v1/orders?id=7" {
+		t.Errorf("unexpected location %q", loc)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://api.example.com/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected exempt path to pass through, got %d", w.Code)
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	h := HSTS(config.HSTSConfig{MaxAgeSec: 600, IncludeSubdomains: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+	req.TLS = &tls.ConnectionState{}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=600; includeSubDomains" {
+		t.Errorf("unexpected HSTS header %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://api.example.com/", nil))
+	if got := w.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("HSTS must not be sent over plaintext, got %q", got)
+	}
+}
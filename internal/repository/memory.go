@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"sync"
 	"time"
 )
@@ -14,21 +16,42 @@ type memBucket struct {
 type memoryStore struct {
 	mu      sync.Mutex
 	buckets map[string]*memBucket
-	sw      map[string][]int64
+	sw      map[string][]swEvent
+	tat     map[string]int64 // GCRA theoretical arrival times, unix nanoseconds
+	swc     map[string]*windowCounts
+	now     func() time.Time
 }
 
 // NewMemoryStore returns an in-memory Store for local development/testing.
 func NewMemoryStore() Store {
 	return &memoryStore{
 		buckets: make(map[string]*memBucket),
-		sw:      make(map[string][]int64),
+		sw:      make(map[string][]swEvent),
+		tat:     make(map[string]int64),
+		swc:     make(map[string]*windowCounts),
+		now:     time.Now,
 	}
 }
 
-func (m *memoryStore) TokenBucket(ctx context.Context, key string, capacity int64, refillRate float64, tokens int64) (bool, int64, error) {
+// mode selects what an algorithm does with an event.
+type mode int
+
+const (
+	peek  mode = iota // report what taking the event would do
+	take              // record the event if the limit allows it
+	force             // record the event regardless of the limit
+)
+
+func (m *memoryStore) TokenBucket(ctx context.Context, key string, capacity int64, refillRate float64, tokens int64) (Result, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return m.tokenBucket(key, capacity, refillRate, tokens, m.now(), take), nil
+}
+
+// tokenBucket refills the bucket and takes tokens from it as md says. Forced
+// takes may leave a negative balance.
+func (m *memoryStore) tokenBucket(key string, capacity int64, refillRate float64, tokens int64, at time.Time, md mode) Result {
+	now := at.UnixMilli()
 	b, ok := m.buckets[key]
 	if !ok {
 		b = &memBucket{tokens: capacity, last: now}
@@ -42,29 +65,298 @@ func (m *memoryStore) TokenBucket(ctx context.Context, key string, capacity int6
 			b.tokens = capacity
 		}
 		b.last = now
+		delta = 0
+	}
+	var res Result
+	left := b.tokens
+	if left >= tokens || md == force {
+		left -= tokens
+		res.Allowed = true
+		if md != peek {
+			b.tokens = left
+		}
+	} else {
+		res.RetryAfter = refillTime(tokens-left, delta, refillRate)
+	}
+	res.Remaining = max(left, 0)
+	res.Reset = refillTime(capacity-left, delta, refillRate)
+	return res
+}
+
+// refillTime returns how long until n more tokens are refilled when elapsed
+// milliseconds have already accrued towards the next refill.
+func refillTime(n, elapsed int64, rate float64) time.Duration {
+	if n <= 0 || rate <= 0 {
+		return 0
 	}
-	if b.tokens >= tokens {
-		b.tokens -= tokens
-		return true, b.tokens, nil
+	ms := int64(math.Ceil(float64(n)*1000/rate)) - elapsed
+	if ms < 0 {
+		ms = 0
 	}
-	return false, b.tokens, nil
+	return time.Duration(ms) * time.Millisecond
 }
 
-func (m *memoryStore) SlidingWindow(ctx context.Context, key string, windowMillis int64) (int64, error) {
+func (m *memoryStore) SlidingWindow(ctx context.Context, key string, windowMillis, limit int64) (Result, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return m.slidingWindow(key, windowMillis, limit, 1, m.now(), take), nil
+}
+
+// swEvent is one logged event of a sliding window and the cost it took.
+type swEvent struct {
+	at, weight int64
+}
+
+// slidingWindow records an event of weight cost as md says.
+func (m *memoryStore) slidingWindow(key string, windowMillis, limit, cost int64, at time.Time, md mode) Result {
+	now := at.UnixMilli()
 	arr := m.sw[key]
 	cutoff := now - windowMillis
-	// remove old
+	// remove events that have left the window
 	i := 0
 	for ; i < len(arr); i++ {
-		if arr[i] >= cutoff {
+		if arr[i].at > cutoff {
 			break
 		}
 	}
 	arr = arr[i:]
-	arr = append(arr, now)
-	m.sw[key] = arr
-	return int64(len(arr)), nil
+	var res Result
+	var count int64
+	for _, e := range arr {
+		count += e.weight
+	}
+	switch {
+	case count+cost <= limit || md == force:
+		if md != peek {
+			arr = append(arr, swEvent{at: now, weight: cost})
+		}
+		count += cost
+		res.Allowed = true
+		res.Reset = time.Duration(windowMillis) * time.Millisecond
+	case limit >= cost:
+		// admitted once the oldest events weighing count+cost-limit have left the window
+		excess := count + cost - limit
+		for _, e := range arr {
+			if excess -= e.weight; excess <= 0 {
+				res.RetryAfter = time.Duration(e.at+windowMillis-now) * time.Millisecond
+				break
+			}
+		}
+	default:
+		res.RetryAfter = time.Duration(windowMillis) * time.Millisecond
+	}
+	if !res.Allowed && len(arr) > 0 {
+		res.Reset = time.Duration(arr[len(arr)-1].at+windowMillis-now) * time.Millisecond
+	}
+	if len(arr) > 0 {
+		m.sw[key] = arr
+	} else {
+		delete(m.sw, key)
+	}
+	res.Remaining = max(limit-count, 0)
+	return res
+}
+
+func (m *memoryStore) GCRA(ctx context.Context, key string, burst int64, rate float64, cost int64) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gcra(key, burst, rate, cost, m.now(), take)
+}
+
+func (m *memoryStore) gcra(key string, burst int64, rate float64, cost int64, at time.Time, md mode) (Result, error) {
+	interval, err := gcraInterval(burst, rate, time.Nanosecond)
+	if err != nil {
+		return Result{}, err
+	}
+	now := at.UnixNano()
+	tat, res := gcra(now, m.tat[key], interval, burst, cost, md == force)
+	switch {
+	case md == peek:
+	case tat > now:
+		m.tat[key] = tat
+	default:
+		delete(m.tat, key)
+	}
+	return res, nil
+}
+
+// gcraInterval returns the emission interval of rate per second in units of unit.
+func gcraInterval(burst int64, rate float64, unit time.Duration) (int64, error) {
+	if burst <= 0 || rate <= 0 {
+		return 0, fmt.Errorf("gcra: burst and rate must be positive")
+	}
+	interval := int64(float64(time.Second/unit) / rate)
+	if interval < 1 {
+		interval = 1
+	}
+	return interval, nil
+}
+
+// gcra runs one GCRA step with all times in the same unit. tat is the stored
+// theoretical arrival time (zero when unset); the returned one replaces it.
+// RetryAfter and Reset are in that unit too. force admits the request even
+// beyond the burst. gcraLua mirrors this.
+func gcra(now, tat, interval, burst, cost int64, force bool) (int64, Result) {
+	if tat < now {
+		tat = now
+	}
+	tolerance := interval * burst
+	next := tat + interval*cost
+	if allowAt := next - tolerance; now < allowAt && !force {
+		return tat, Result{
+			Remaining:  max((tolerance-(tat-now))/interval, 0),
+			RetryAfter: time.Duration(allowAt - now),
+			Reset:      time.Duration(tat - now),
+		}
+	}
+	return next, Result{
+		Allowed:   true,
+		Remaining: max((tolerance-(next-now))/interval, 0),
+		Reset:     time.Duration(next - now),
+	}
+}
+
+// windowCounts holds the counts of the fixed window with the given index and the one before it.
+type windowCounts struct {
+	index, prev, curr int64
+}
+
+func (m *memoryStore) SlidingWindowCounter(ctx context.Context, key string, windowMillis, limit, cost int64) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.slidingWindowCounter(key, windowMillis, limit, cost, m.now(), take)
+}
+
+func (m *memoryStore) slidingWindowCounter(key string, windowMillis, limit, cost int64, at time.Time, md mode) (Result, error) {
+	if windowMillis <= 0 {
+		return Result{}, fmt.Errorf("sliding window counter: window must be positive")
+	}
+	now := at.UnixMilli()
+	index := now / windowMillis
+	c, ok := m.swc[key]
+	switch {
+	case !ok:
+		c = &windowCounts{index: index}
+	case c.index == index-1:
+		c.index, c.prev, c.curr = index, c.curr, 0
+	case c.index != index:
+		c.index, c.prev, c.curr = index, 0, 0
+	}
+	res := slidingWindowCounter(now-index*windowMillis, windowMillis, limit, cost, c.prev, c.curr, md == force)
+	if res.Allowed && md != peek {
+		c.curr += cost
+	}
+	// counts more than a window old no longer matter
+	if c.prev == 0 && c.curr == 0 {
+		delete(m.swc, key)
+	} else {
+		m.swc[key] = c
+	}
+	return res, nil
+}
+
+// slidingWindowCounter decides one event in milliseconds. The previous window's
+// count is weighted by the share of it still inside the sliding window; elapsed
+// is the time since the current window began; force admits the event beyond the
+// limit. slidingWindowCounterLua mirrors this.
+func slidingWindowCounter(elapsed, window, limit, cost, prev, curr int64, force bool) Result {
+	estimate := float64(prev)*float64(window-elapsed)/float64(window) + float64(curr)
+	if estimate+float64(cost) <= float64(limit) || force {
+		reset := int64(0)
+		if curr+cost > 0 {
+			reset = 2*window - elapsed
+		} else if prev > 0 {
+			reset = window - elapsed
+		}
+		return Result{
+			Allowed:   true,
+			Remaining: max(int64(float64(limit)-estimate-float64(cost)), 0),
+			Reset:     time.Duration(reset) * time.Millisecond,
+		}
+	}
+	var retry int64
+	if budget := limit - curr - cost; budget >= 0 && prev > 0 {
+		// wait until enough of the previous window has slid out
+		retry = ceilDiv(window*(prev-budget), prev) - elapsed
+	} else {
+		// wait for the next window, in which the current count is the previous one
+		retry = window - elapsed
+		if budget = limit - cost; budget < 0 {
+			retry += window
+		} else if curr > budget {
+			retry += ceilDiv(window*(curr-budget), curr)
+		}
+	}
+	reset := window - elapsed
+	if curr > 0 {
+		reset += window
+	}
+	remaining := int64(float64(limit) - estimate)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return Result{
+		Remaining:  remaining,
+		RetryAfter: time.Duration(retry) * time.Millisecond,
+		Reset:      time.Duration(reset) * time.Millisecond,
+	}
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// Multi evaluates all checks at one instant under the store lock and takes from
+// them only if every check allows the request.
+func (m *memoryStore) Multi(ctx context.Context, checks []Check) ([]Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	results := make([]Result, len(checks))
+	allowed := true
+	for i, c := range checks {
+		res, err := m.check(c, now, peek)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = res
+		allowed = allowed && res.Allowed
+	}
+	if !allowed {
+		return results, nil
+	}
+	for i, c := range checks {
+		results[i], _ = m.check(c, now, take)
+	}
+	return results, nil
+}
+
+// Debit forces every check's cost into the store under one lock.
+func (m *memoryStore) Debit(ctx context.Context, checks []Check) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for _, c := range checks {
+		if _, err := m.check(c, now, peek); err != nil {
+			return err
+		}
+	}
+	for _, c := range checks {
+		m.check(c, now, force)
+	}
+	return nil
+}
+
+func (m *memoryStore) check(c Check, now time.Time, md mode) (Result, error) {
+	switch c.Algorithm {
+	case AlgTokenBucket:
+		return m.tokenBucket(c.Key, c.Limit, c.Rate, c.Cost, now, md), nil
+	case AlgSlidingWindow:
+		return m.slidingWindow(c.Key, c.WindowMillis, c.Limit, c.Cost, now, md), nil
+	case AlgGCRA:
+		return m.gcra(c.Key, c.Limit, c.Rate, c.Cost, now, md)
+	case AlgSlidingWindowCounter:
+		return m.slidingWindowCounter(c.Key, c.WindowMillis, c.Limit, c.Cost, now, md)
+	}
+	return Result{}, fmt.Errorf("unknown algorithm %s", c.Algorithm)
 }
@@ -11,41 +11,44 @@ func TestMemoryStoreTokenBucket(t *testing.T) {
 	ctx := context.Background()
 
 	// Test: First request should succeed
-	allowed, remaining, err := mem.TokenBucket(ctx, "user:1", 10, 10, 1)
+	res, err := mem.TokenBucket(ctx, "user:1", 10, 10, 1)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !allowed {
+	if !res.Allowed {
 		t.Fatal("first request should be allowed")
 	}
-	if remaining != 9 {
-		t.Fatalf("expected remaining 9, got %d", remaining)
+	if res.Remaining != 9 {
+		t.Fatalf("expected remaining 9, got %d", res.Remaining)
 	}
 
 	// Test: Rapid fire within capacity
 	for i := 0; i < 9; i++ {
-		ok, _, _ := mem.TokenBucket(ctx, "user:1", 10, 10, 1)
-		if !ok {
+		res, _ := mem.TokenBucket(ctx, "user:1", 10, 10, 1)
+		if !res.Allowed {
 			t.Fatalf("request %d should be allowed", i+2)
 		}
 	}
 
 	// Test: Exceed capacity
-	allowed, _, err = mem.TokenBucket(ctx, "user:1", 10, 10, 1)
+	res, err = mem.TokenBucket(ctx, "user:1", 10, 10, 1)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if allowed {
+	if res.Allowed {
 		t.Fatal("11th request should be denied")
 	}
+	if res.RetryAfter <= 0 || res.RetryAfter > 100*time.Millisecond || res.Reset <= 900*time.Millisecond {
+		t.Fatalf("expected retry within one refill and reset near 1s, got %+v", res)
+	}
 
 	// Test: Refill after delay
 	time.Sleep(100 * time.Millisecond)
-	allowed, _, err = mem.TokenBucket(ctx, "user:1", 10, 10, 1)
+	res, err = mem.TokenBucket(ctx, "user:1", 10, 10, 1)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !allowed {
+	if !res.Allowed {
 		t.Fatal("should have refilled after delay")
 	}
 }
@@ -56,22 +59,335 @@ func TestMemoryStoreSlidingWindow(t *testing.T) {
 
 	// Test: First few events within window
 	for i := 0; i < 5; i++ {
-		count, err := mem.SlidingWindow(ctx, "endpoint:/api/users", 1000)
+		res, err := mem.SlidingWindow(ctx, "endpoint:/api/users", 1000, 5)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if count != int64(i+1) {
-			t.Fatalf("expected count %d, got %d", i+1, count)
+		if !res.Allowed || res.Remaining != int64(4-i) {
+			t.Fatalf("expected remaining %d, got %+v", 4-i, res)
 		}
 	}
 
+	// Test: Denied events are not recorded
+	res, err := mem.SlidingWindow(ctx, "endpoint:/api/users", 1000, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > time.Second {
+		t.Fatalf("expected denial with retry within the window, got %+v", res)
+	}
+
 	// Test: Events outside window are cleaned up
 	time.Sleep(1100 * time.Millisecond)
-	count, err := mem.SlidingWindow(ctx, "endpoint:/api/users", 1000)
+	res, err = mem.SlidingWindow(ctx, "endpoint:/api/users", 1000, 5)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if count != 1 {
-		t.Fatalf("expected count 1 after window expiry, got %d", count)
+	if !res.Allowed || res.Remaining != 4 {
+		t.Fatalf("expected remaining 4 after window expiry, got %+v", res)
+	}
+}
+
+func TestMemoryStoreGCRA(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// 10 req/s with a burst of 5: one request every 100ms
+	for i := 0; i < 5; i++ {
+		res, err := store.GCRA(ctx, "ip:1", 5, 10, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !res.Allowed || res.Remaining != int64(4-i) {
+			t.Fatalf("request %d: expected allowed with %d remaining, got %+v", i+1, 4-i, res)
+		}
+	}
+	res, _ := store.GCRA(ctx, "ip:1", 5, 10, 1)
+	if res.Allowed || res.Remaining != 0 || res.RetryAfter != 100*time.Millisecond || res.Reset != 500*time.Millisecond {
+		t.Fatalf("expected denial with retry after 100ms and reset 500ms, got %+v", res)
+	}
+
+	now = now.Add(30 * time.Millisecond)
+	res, _ = store.GCRA(ctx, "ip:1", 5, 10, 1)
+	if res.Allowed || res.RetryAfter != 70*time.Millisecond {
+		t.Fatalf("expected retry after 70ms, got %+v", res)
+	}
+	now = now.Add(70 * time.Millisecond)
+	res, _ = store.GCRA(ctx, "ip:1", 5, 10, 1)
+	if !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("expected one emission interval to admit a request, got %+v", res)
+	}
+
+	// a cost larger than the remaining burst is denied without consuming anything
+	now = now.Add(200 * time.Millisecond)
+	res, _ = store.GCRA(ctx, "ip:1", 5, 10, 3)
+	if res.Allowed || res.Remaining != 2 || res.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("expected cost 3 to wait 100ms with 2 remaining, got %+v", res)
+	}
+
+	now = now.Add(time.Second)
+	if res, _ = store.GCRA(ctx, "ip:1", 5, 10, 1); !res.Allowed || res.Remaining != 4 {
+		t.Fatalf("expected a full burst after idling, got %+v", res)
+	}
+	if _, err := store.GCRA(ctx, "ip:2", 0, 10, 1); err == nil {
+		t.Fatal("expected a zero burst to be rejected")
+	}
+}
+
+func TestMemoryStoreSlidingWindowCounter(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	now := time.UnixMilli(1700000000000)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// events in the same millisecond are all counted
+	for i := 0; i < 10; i++ {
+		res, err := store.SlidingWindowCounter(ctx, "ip:1", 1000, 10, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !res.Allowed || res.Remaining != int64(9-i) {
+			t.Fatalf("event %d: expected allowed with %d remaining, got %+v", i+1, 9-i, res)
+		}
+	}
+	res, _ := store.SlidingWindowCounter(ctx, "ip:1", 1000, 10, 1)
+	if res.Allowed || res.RetryAfter != 1100*time.Millisecond || res.Reset != 2000*time.Millisecond {
+		t.Fatalf("expected retry after 1.1s and reset 2s, got %+v", res)
+	}
+
+	// 100ms into the next window the previous window still weighs 9 events
+	now = now.Add(1100 * time.Millisecond)
+	if res, _ = store.SlidingWindowCounter(ctx, "ip:1", 1000, 10, 1); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("expected the 10th slot to open, got %+v", res)
+	}
+	res, _ = store.SlidingWindowCounter(ctx, "ip:1", 1000, 10, 1)
+	if res.Allowed || res.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("expected retry after 100ms, got %+v", res)
+	}
+	now = now.Add(100 * time.Millisecond)
+	if res, _ = store.SlidingWindowCounter(ctx, "ip:1", 1000, 10, 1); !res.Allowed {
+		t.Fatalf("expected the retry to be admitted, got %+v", res)
+	}
+
+	now = now.Add(5 * time.Second)
+	if res, _ = store.SlidingWindowCounter(ctx, "ip:1", 1000, 10, 1); !res.Allowed || res.Remaining != 9 {
+		t.Fatalf("expected a fresh window after idling, got %+v", res)
+	}
+	if c := store.swc["ip:1"]; c.prev != 0 || c.curr != 1 {
+		t.Fatalf("expected stale counts to be cleared, got %+v", c)
+	}
+
+	// entries more than a window old are evicted, and peeks never create one
+	now = now.Add(2 * time.Second)
+	if res, _ = store.slidingWindowCounter("ip:1", 1000, 10, 1, now, peek); !res.Allowed || res.Remaining != 9 {
+		t.Fatalf("expected an empty window, got %+v", res)
+	}
+	store.slidingWindowCounter("ip:2", 1000, 10, 1, now, peek)
+	if len(store.swc) != 0 {
+		t.Fatalf("expected stale and peeked entries to be evicted, got %v", store.swc)
+	}
+}
+
+func TestMemoryStoreSlidingWindowRetryAfter(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	now := time.UnixMilli(1700000000000)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for _, step := range []time.Duration{0, 200 * time.Millisecond, 300 * time.Millisecond} {
+		now = now.Add(step)
+		if res, _ := store.SlidingWindow(ctx, "k", 1000, 3); !res.Allowed {
+			t.Fatalf("expected event at +%v to be allowed", step)
+		}
+	}
+	// events at 0, 200 and 500ms: the first leaves the window at 1000ms
+	res, _ := store.SlidingWindow(ctx, "k", 1000, 3)
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond || res.Reset != time.Second {
+		t.Fatalf("expected retry after 500ms and reset 1s, got %+v", res)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if res, _ = store.SlidingWindow(ctx, "k", 1000, 3); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("expected the retry to be admitted, got %+v", res)
+	}
+}
+
+func TestMemoryStoreTokenBucketRetryAfter(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	now := time.UnixMilli(1700000000000)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// 2 tokens, refilled at 4 per second
+	store.TokenBucket(ctx, "k", 2, 4, 1)
+	store.TokenBucket(ctx, "k", 2, 4, 1)
+	now = now.Add(100 * time.Millisecond)
+	res, _ := store.TokenBucket(ctx, "k", 2, 4, 1)
+	if res.Allowed || res.RetryAfter != 150*time.Millisecond || res.Reset != 400*time.Millisecond {
+		t.Fatalf("expected retry after 150ms and reset 400ms, got %+v", res)
+	}
+	now = now.Add(150 * time.Millisecond)
+	if res, _ = store.TokenBucket(ctx, "k", 2, 4, 1); !res.Allowed {
+		t.Fatalf("expected the retry to be admitted, got %+v", res)
+	}
+}
+
+func TestMemoryStoreMulti(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	now := time.UnixMilli(1700000000000)
+	store.now = func() time.Time { return now }
+	testStoreMulti(t, store)
+}
+
+// testStoreMulti checks that a denied multi-limit evaluation consumes nothing.
+func testStoreMulti(t *testing.T, store Store) {
+	ctx := context.Background()
+	checks := []Check{
+		{Algorithm: AlgGCRA, Key: "ip", Limit: 5, Rate: 10, Cost: 1},
+		{Algorithm: AlgTokenBucket, Key: "apikey", Limit: 3, Rate: 1, Cost: 1},
+		{Algorithm: AlgSlidingWindowCounter, Key: "route", Limit: 100, WindowMillis: 1000, Cost: 1},
+		{Algorithm: AlgSlidingWindow, Key: "global", Limit: 100, WindowMillis: 1000, Cost: 1},
+	}
+	for i := 0; i < 3; i++ {
+		results, err := store.Multi(ctx, checks)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for j, res := range results {
+			if !res.Allowed {
+				t.Fatalf("request %d: check %d denied: %+v", i+1, j, res)
+			}
+		}
+		if results[1].Remaining != int64(2-i) || results[2].Remaining != int64(99-i) {
+			t.Fatalf("request %d: unexpected remaining %+v", i+1, results)
+		}
+	}
+
+	results, err := store.Multi(ctx, checks)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results[1].Allowed || results[1].RetryAfter != time.Second {
+		t.Fatalf("expected the API key bucket to deny for 1s, got %+v", results[1])
+	}
+	if !results[0].Allowed || results[0].Remaining != 1 {
+		t.Fatalf("expected the IP check to report what it would allow, got %+v", results[0])
+	}
+
+	// the denied request took nothing from the other limits
+	if res, _ := store.GCRA(ctx, "ip", 5, 10, 1); res.Remaining != 1 {
+		t.Errorf("expected 1 GCRA slot left, got %+v", res)
+	}
+	if res, _ := store.SlidingWindowCounter(ctx, "route", 1000, 100, 1); res.Remaining != 96 {
+		t.Errorf("expected 96 left in the window counter, got %+v", res)
+	}
+	if res, _ := store.SlidingWindow(ctx, "global", 1000, 100); res.Remaining != 96 {
+		t.Errorf("expected 96 left in the sliding window, got %+v", res)
+	}
+
+	if _, err := store.Multi(ctx, []Check{{Algorithm: "fixedwindow", Key: "x"}}); err == nil {
+		t.Error("expected an unknown algorithm to be rejected")
+	}
+}
+
+func TestMemoryStoreDebit(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	now := time.UnixMilli(1700000000000)
+	store.now = func() time.Time { return now }
+	testStoreDebit(t, store)
+	if n := len(store.sw["sw"]); n != 2 {
+		t.Errorf("expected one logged event per take, got %d", n)
+	}
+}
+
+func TestMemoryStoreSlidingWindowWeights(t *testing.T) {
+	store := NewMemoryStore().(*memoryStore)
+	store.now = func() time.Time { return time.UnixMilli(1700000000000) }
+	testStoreSlidingWindowWeights(t, store, func(at time.Time) { store.now = func() time.Time { return at } })
+}
+
+// testStoreSlidingWindowWeights checks that sliding window events count with
+// their cost, including when they leave the window.
+func testStoreSlidingWindowWeights(t *testing.T, store Store, setNow func(time.Time)) {
+	ctx := context.Background()
+	start := time.UnixMilli(1700000000000)
+	take := func(at time.Duration, cost int64) Result {
+		setNow(start.Add(at))
+		results, err := store.Multi(ctx, []Check{{Algorithm: AlgSlidingWindow, Key: "w", Limit: 10, WindowMillis: 60000, Cost: cost}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return results[0]
+	}
+	for i, c := range []struct {
+		at   time.Duration
+		cost int64
+	}{{0, 3}, {10 * time.Second, 5}, {20 * time.Second, 2}} {
+		if res := take(c.at, c.cost); !res.Allowed {
+			t.Fatalf("event %d: expected cost %d to be admitted, got %+v", i+1, c.cost, res)
+		}
+	}
+	// 4 more need the events weighing 3 and 5 to leave the window, at 70s
+	res := take(30*time.Second, 4)
+	if res.Allowed || res.Remaining != 0 || res.RetryAfter != 40*time.Second || res.Reset != 50*time.Second {
+		t.Fatalf("expected cost 4 to wait 40s, got %+v", res)
+	}
+	if res = take(61*time.Second, 4); res.Allowed || res.RetryAfter != 9*time.Second {
+		t.Fatalf("expected cost 4 to wait for the second event after the first left, got %+v", res)
+	}
+	if res = take(70*time.Second, 4); !res.Allowed || res.Remaining != 4 {
+		t.Fatalf("expected cost 4 to be admitted with 4 left, got %+v", res)
+	}
+}
+
+// testStoreDebit checks weighted costs and that debits beyond the limit delay
+// later requests.
+func testStoreDebit(t *testing.T, store Store) {
+	ctx := context.Background()
+	checks := func(cost int64) []Check {
+		return []Check{
+			{Algorithm: AlgTokenBucket, Key: "tb", Limit: 10, Rate: 1, Cost: cost},
+			{Algorithm: AlgSlidingWindow, Key: "sw", Limit: 10, WindowMillis: 60000, Cost: cost},
+			{Algorithm: AlgSlidingWindowCounter, Key: "swc", Limit: 10, WindowMillis: 60000, Cost: cost},
+			{Algorithm: AlgGCRA, Key: "gcra", Limit: 10, Rate: 1, Cost: cost},
+		}
+	}
+	results, err := store.Multi(ctx, checks(4))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, res := range results {
+		if !res.Allowed || res.Remaining != 6 {
+			t.Fatalf("check %d: expected a cost of 4 to leave 6, got %+v", i, res)
+		}
+	}
+
+	// the true cost turned out to be 16: debit the other 12, beyond every limit
+	if err := store.Debit(ctx, checks(12)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results, err = store.Multi(ctx, checks(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, res := range results {
+		if res.Allowed || res.Remaining != 0 {
+			t.Fatalf("check %d: expected the debt to deny, got %+v", i, res)
+		}
+	}
+	// the bucket is 6 tokens in debt and needs 7 to admit one request
+	if results[0].RetryAfter != 7*time.Second {
+		t.Errorf("expected the token bucket to wait 7s, got %v", results[0].RetryAfter)
+	}
+	if results[1].RetryAfter != time.Minute {
+		t.Errorf("expected the sliding window to wait for all debited events, got %v", results[1].RetryAfter)
+	}
+	if results[3].RetryAfter != 7*time.Second {
+		t.Errorf("expected GCRA to wait 7s, got %v", results[3].RetryAfter)
+	}
+
+	if err := store.Debit(ctx, []Check{{Algorithm: "fixedwindow", Key: "x"}}); err == nil {
+		t.Error("expected an unknown algorithm to be rejected")
 	}
 }
@@ -3,6 +3,8 @@ package repository
 import (
 	"context"
 	"fmt"
+	"math/rand/v2"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -10,6 +12,7 @@ import (
 
 type redisStore struct {
 	client *redis.Client
+	now    func() time.Time
 }
 
 // NewRedisStore connects to Redis and returns a Store implementation.
@@ -21,68 +24,361 @@ func NewRedisStore(addr string) (Store, error) {
 	if err := client.Ping(context.Background()).Err(); err != nil {
 		return nil, fmt.Errorf("redis ping: %w", err)
 	}
-	return &redisStore{client: client}, nil
+	return &redisStore{client: client, now: time.Now}, nil
 }
 
-// tokenBucketLua implements refill + take atomically.
-var tokenBucketLua = redis.NewScript(`
-local key = KEYS[1]
-local capacity = tonumber(ARGV[1])
-local rate = tonumber(ARGV[2])
-local now = tonumber(ARGV[3])
-local requested = tonumber(ARGV[4])
-
-local data = redis.call('HMGET', key, 'tokens', 'last')
-local tokens = tonumber(data[1]) or capacity
-local last = tonumber(data[2]) or now
-
-local delta = math.max(0, now - last)
-local refill = delta * rate
-tokens = math.min(capacity, tokens + refill)
-local allowed = 0
-if tokens >= requested then
-  tokens = tokens - requested
-  allowed = 1
+// The algorithms are Lua functions shared by the single-algorithm scripts,
+// multiLua and debitLua. Each returns {allowed, remaining, retry_after, reset}.
+// mode is 0 to only report (peek), 1 to record an allowed event (take) and 2 to
+// record it regardless of the limit (force). GCRA works in microseconds, the
+// others in milliseconds.
+
+// luaTokenBucket implements refill + take.
+const luaTokenBucket = `
+local function token_bucket(key, capacity, rate, requested, now, mode)
+  local data = redis.call('HMGET', key, 'tokens', 'last')
+  local tokens = tonumber(data[1]) or capacity
+  local last = tonumber(data[2]) or now
+
+  local delta = math.max(0, now - last)
+  local refill = delta * rate
+  tokens = math.min(capacity, tokens + refill)
+  local allowed = 0
+  local retry = 0
+  if tokens >= requested or mode == 2 then
+    tokens = tokens - requested
+    allowed = 1
+  else
+    retry = math.ceil((requested - tokens) / rate)
+  end
+  if mode > 0 then
+    redis.call('HMSET', key, 'tokens', tokens, 'last', now)
+    redis.call('PEXPIRE', key, math.ceil((capacity / rate) * 1000 * 2))
+  end
+  return {allowed, math.max(0, math.floor(tokens)), retry, math.ceil((capacity - tokens) / rate)}
+end
+`
+
+// luaSlidingWindow counts the events within the window and records an event of
+// weight cost only if it is admitted. Members are "<event>:<weight>" and carry a
+// random part so events in the same millisecond are all kept; members without a
+// weight count once.
+const luaSlidingWindow = `
+local function event_weight(m)
+  local w = string.match(m, ':(%d+)$')
+  if w then
+    return tonumber(w)
+  end
+  return 1
+end
+
+local function sliding_window(key, window, limit, cost, now, member, mode)
+  local cutoff = now - window
+  if mode > 0 then
+    redis.call('ZREMRANGEBYSCORE', key, '-inf', cutoff)
+  end
+  local events = redis.call('ZRANGEBYSCORE', key, '(' .. cutoff, '+inf', 'WITHSCORES')
+  local count = 0
+  for j = 1, #events, 2 do
+    count = count + event_weight(events[j])
+  end
+  local allowed = 0
+  local retry = 0
+  local reset = 0
+  if count + cost <= limit or mode == 2 then
+    if mode > 0 then
+      redis.call('ZADD', key, now, member .. ':' .. string.format('%d', cost))
+      redis.call('PEXPIRE', key, window * 2)
+    end
+    count = count + cost
+    allowed = 1
+    reset = window
+  else
+    if limit >= cost then
+      local excess = count + cost - limit
+      for j = 1, #events, 2 do
+        excess = excess - event_weight(events[j])
+        if excess <= 0 then
+          retry = tonumber(events[j + 1]) + window - now
+          break
+        end
+      end
+    else
+      retry = window
+    end
+    if count > 0 then
+      reset = tonumber(events[#events]) + window - now
+    end
+  end
+  return {allowed, math.max(0, limit - count), retry, reset}
+end
+`
+
+// luaGCRA implements one GCRA step (see gcra). The theoretical arrival time is
+// the only state and expires once it is in the past.
+const luaGCRA = `
+local function gcra(key, interval, burst, cost, now, mode)
+  local tat = tonumber(redis.call('GET', key)) or now
+  if tat < now then
+    tat = now
+  end
+  local tolerance = interval * burst
+  local nxt = tat + interval * cost
+  local allow_at = nxt - tolerance
+  if now < allow_at and mode < 2 then
+    return {0, math.max(0, math.floor((tolerance - (tat - now)) / interval)), allow_at - now, tat - now}
+  end
+  if mode > 0 and nxt > now then
+    redis.call('SET', key, string.format('%d', nxt), 'PX', math.ceil((nxt - now) / 1000))
+  end
+  return {1, math.max(0, math.floor((tolerance - (nxt - now)) / interval)), 0, nxt - now}
 end
-redis.call('HMSET', key, 'tokens', tokens, 'last', now)
-redis.call('PEXPIRE', key, math.ceil((capacity / rate) * 1000 * 2))
-return {allowed, tokens}
+`
+
+// luaSlidingWindowCounter decides one event (see slidingWindowCounter). The
+// counts are hash fields named by fixed-window index; only the current and the
+// previous window are kept, and the hash expires after two idle windows.
+const luaSlidingWindowCounter = `
+local function sliding_window_counter(key, window, limit, cost, now, mode)
+  local index = math.floor(now / window)
+  local elapsed = now - index * window
+  local field = string.format('%d', index)
+  local counts = redis.call('HMGET', key, field, string.format('%d', index - 1))
+  local curr = tonumber(counts[1]) or 0
+  local prev = tonumber(counts[2]) or 0
+  local estimate = prev * (window - elapsed) / window + curr
+  if estimate + cost <= limit or mode == 2 then
+    local reset = 0
+    if curr + cost > 0 then
+      reset = 2 * window - elapsed
+      if mode > 0 then
+        redis.call('HINCRBY', key, field, cost)
+        redis.call('HDEL', key, string.format('%d', index - 2))
+        redis.call('PEXPIRE', key, 2 * window)
+      end
+    elseif prev > 0 then
+      reset = window - elapsed
+    end
+    return {1, math.max(0, math.floor(limit - estimate - cost)), 0, reset}
+  end
+
+  local retry
+  local budget = limit - curr - cost
+  if budget >= 0 and prev > 0 then
+    retry = math.ceil(window * (prev - budget) / prev) - elapsed
+  else
+    retry = window - elapsed
+    budget = limit - cost
+    if budget < 0 then
+      retry = retry + window
+    elseif curr > budget then
+      retry = retry + math.ceil(window * (curr - budget) / curr)
+    end
+  end
+  local reset = window - elapsed
+  if curr > 0 then
+    reset = reset + window
+  end
+  return {0, math.max(0, math.floor(limit - estimate)), retry, reset}
+end
+`
+
+var tokenBucketLua = redis.NewScript(luaTokenBucket + `
+return token_bucket(KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[4]), tonumber(ARGV[3]), 1)
 `)
 
-func (r *redisStore) TokenBucket(ctx context.Context, key string, capacity int64, refillRate float64, tokens int64) (bool, int64, error) {
-	now := time.Now().UnixNano() / int64(time.Millisecond)
-	res, err := tokenBucketLua.Run(ctx, r.client, []string{key}, capacity, refillRate/1000.0, now, tokens).Result()
+func (r *redisStore) TokenBucket(ctx context.Context, key string, capacity int64, refillRate float64, tokens int64) (Result, error) {
+	now := r.now().UnixMilli()
+	res, err := tokenBucketLua.Run(ctx, r.client, []string{key}, capacity, refillRate/1000.0, now, tokens).Int64Slice()
 	if err != nil {
-		return false, 0, err
+		return Result{}, err
 	}
-	arr, ok := res.([]interface{})
-	if !ok || len(arr) < 2 {
-		return false, 0, fmt.Errorf("unexpected redis response: %v", res)
+	return parseResult(res, time.Millisecond)
+}
+
+var slidingWindowLua = redis.NewScript(luaSlidingWindow + `
+return sliding_window(KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2]), 1, tonumber(ARGV[3]), ARGV[4], 1)
+`)
+
+func (r *redisStore) SlidingWindow(ctx context.Context, key string, windowMillis, limit int64) (Result, error) {
+	now := r.now().UnixMilli()
+	res, err := slidingWindowLua.Run(ctx, r.client, []string{key + ":sw"}, windowMillis, limit, now, eventMember(now)).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return parseResult(res, time.Millisecond)
+}
+
+// eventMember returns a unique sorted-set member for an event at now.
+func eventMember(now int64) string {
+	return strconv.FormatInt(now, 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+}
+
+var gcraLua = redis.NewScript(luaGCRA + `
+return gcra(KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4]), 1)
+`)
+
+func (r *redisStore) GCRA(ctx context.Context, key string, burst int64, rate float64, cost int64) (Result, error) {
+	interval, err := gcraInterval(burst, rate, time.Microsecond)
+	if err != nil {
+		return Result{}, err
 	}
-	allowed := arr[0].(int64) == 1
-	remaining := int64(0)
-	switch v := arr[1].(type) {
-	case int64:
-		remaining = v
-	case string:
-		// redis may return string
-		var parsed int64
-		fmt.Sscanf(v, "%d", &parsed)
-		remaining = parsed
+	now := r.now().UnixMicro()
+	res, err := gcraLua.Run(ctx, r.client, []string{key}, interval, burst, cost, now).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return parseResult(res, time.Microsecond)
+}
+
+var slidingWindowCounterLua = redis.NewScript(luaSlidingWindowCounter + `
+return sliding_window_counter(KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4]), 1)
+`)
+
+func (r *redisStore) SlidingWindowCounter(ctx context.Context, key string, windowMillis, limit, cost int64) (Result, error) {
+	if windowMillis <= 0 {
+		return Result{}, fmt.Errorf("sliding window counter: window must be positive")
+	}
+	now := r.now().UnixMilli()
+	res, err := slidingWindowCounterLua.Run(ctx, r.client, []string{key}, windowMillis, limit, cost, now).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return parseResult(res, time.Millisecond)
+}
+
+// luaChecks defines run(i, mode), which applies the algorithm of check i to
+// KEYS[i]. ARGV[1] is the time in microseconds, followed by six arguments per
+// check: algorithm, limit, rate, window, cost and event member. rate is in tokens
+// per millisecond for token bucket and is the emission interval in microseconds
+// for GCRA.
+const luaChecks = luaTokenBucket + luaSlidingWindow + luaGCRA + luaSlidingWindowCounter + `
+local now_us = tonumber(ARGV[1])
+local now_ms = math.floor(now_us / 1000)
+local function run(i, mode)
+  local a = 2 + (i - 1) * 6
+  local alg = ARGV[a]
+  local limit = tonumber(ARGV[a + 1])
+  local rate = tonumber(ARGV[a + 2])
+  local window = tonumber(ARGV[a + 3])
+  local cost = tonumber(ARGV[a + 4])
+  if alg == 'tokenbucket' then
+    return token_bucket(KEYS[i], limit, rate, cost, now_ms, mode)
+  elseif alg == 'slidingwindow' then
+    return sliding_window(KEYS[i], window, limit, cost, now_ms, ARGV[a + 5], mode)
+  elseif alg == 'gcra' then
+    return gcra(KEYS[i], rate, limit, cost, now_us, mode)
+  end
+  return sliding_window_counter(KEYS[i], window, limit, cost, now_ms, mode)
+end
+`
+
+// multiLua evaluates every check and takes from all of them only if every check
+// allows the event.
+var multiLua = redis.NewScript(luaChecks + `
+local results = {}
+local allowed = true
+for i = 1, #KEYS do
+  results[i] = run(i, 0)
+  if results[i][1] == 0 then
+    allowed = false
+  end
+end
+if allowed then
+  for i = 1, #KEYS do
+    results[i] = run(i, 1)
+  end
+end
+return results
+`)
+
+// debitLua forces the cost of every check.
+var debitLua = redis.NewScript(luaChecks + `
+for i = 1, #KEYS do
+  run(i, 2)
+end
+return 0
+`)
+
+func (r *redisStore) Multi(ctx context.Context, checks []Check) ([]Result, error) {
+	keys, args, err := checkArgs(checks, r.now())
+	if err != nil {
+		return nil, err
+	}
+	raw, err := multiLua.Run(ctx, r.client, keys, args...).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != len(checks) {
+		return nil, fmt.Errorf("unexpected redis response: %v", raw)
+	}
+	results := make([]Result, len(checks))
+	for i, v := range raw {
+		vals, _ := v.([]interface{})
+		res := make([]int64, len(vals))
+		for j, x := range vals {
+			res[j], _ = x.(int64)
+		}
+		unit := time.Millisecond
+		if checks[i].Algorithm == AlgGCRA {
+			unit = time.Microsecond
+		}
+		if results[i], err = parseResult(res, unit); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func (r *redisStore) Debit(ctx context.Context, checks []Check) error {
+	keys, args, err := checkArgs(checks, r.now())
+	if err != nil {
+		return err
+	}
+	return debitLua.Run(ctx, r.client, keys, args...).Err()
+}
+
+// checkArgs encodes checks as the KEYS and ARGV of luaChecks.
+func checkArgs(checks []Check, now time.Time) ([]string, []interface{}, error) {
+	keys := make([]string, len(checks))
+	args := make([]interface{}, 1, 1+6*len(checks))
+	args[0] = now.UnixMicro()
+	for i, c := range checks {
+		keys[i] = c.Key
+		var rate float64
+		switch c.Algorithm {
+		case AlgTokenBucket:
+			rate = c.Rate / 1000.0
+		case AlgSlidingWindow:
+			keys[i] = c.Key + ":sw"
+		case AlgGCRA:
+			interval, err := gcraInterval(c.Limit, c.Rate, time.Microsecond)
+			if err != nil {
+				return nil, nil, err
+			}
+			rate = float64(interval)
+		case AlgSlidingWindowCounter:
+			if c.WindowMillis <= 0 {
+				return nil, nil, fmt.Errorf("sliding window counter: window must be positive")
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown algorithm %s", c.Algorithm)
+		}
+		args = append(args, string(c.Algorithm), c.Limit, rate, c.WindowMillis, c.Cost, eventMember(now.UnixMilli()))
 	}
-	return allowed, remaining, nil
+	return keys, args, nil
 }
 
-func (r *redisStore) SlidingWindow(ctx context.Context, key string, windowMillis int64) (int64, error) {
-	now := time.Now().UnixNano() / int64(time.Millisecond)
-	zkey := key + ":sw"
-	pipe := r.client.TxPipeline()
-	pipe.ZAdd(ctx, zkey, redis.Z{Score: float64(now), Member: now})
-	pipe.ZRemRangeByScore(ctx, zkey, "-inf", fmt.Sprintf("%d", now-windowMillis))
-	cnt := pipe.ZCard(ctx, zkey)
-	pipe.PExpire(ctx, zkey, time.Duration(windowMillis*2)*time.Millisecond)
-	if _, err := pipe.Exec(ctx); err != nil {
-		return 0, err
+// parseResult decodes the {allowed, remaining, retry_after, reset} reply of the
+// scripts, with durations in unit.
+func parseResult(res []int64, unit time.Duration) (Result, error) {
+	if len(res) != 4 {
+		return Result{}, fmt.Errorf("unexpected redis response: %v", res)
 	}
-	return cnt.Val(), nil
+	return Result{
+		Allowed:    res[0] == 1,
+		Remaining:  res[1],
+		RetryAfter: time.Duration(res[2]) * unit,
+		Reset:      time.Duration(res[3]) * unit,
+	}, nil
 }
@@ -24,30 +24,33 @@ func TestRedisStoreTokenBucket(t *testing.T) {
 	ctx := context.Background()
 
 	// First request succeeds
-	allowed, _, err := store.TokenBucket(ctx, "user:1", 10, 10, 1)
+	res, err := store.TokenBucket(ctx, "user:1", 10, 10, 1)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !allowed {
+	if !res.Allowed {
 		t.Fatal("first request should be allowed")
 	}
 
 	// Rapid fire within capacity
 	for i := 0; i < 9; i++ {
-		ok, _, _ := store.TokenBucket(ctx, "user:1", 10, 10, 1)
-		if !ok {
+		res, _ := store.TokenBucket(ctx, "user:1", 10, 10, 1)
+		if !res.Allowed {
 			t.Fatalf("request %d should be allowed", i+2)
 		}
 	}
 
 	// Exceed capacity
-	allowed, _, err = store.TokenBucket(ctx, "user:1", 10, 10, 1)
+	res, err = store.TokenBucket(ctx, "user:1", 10, 10, 1)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if allowed {
+	if res.Allowed {
 		t.Fatal("11th request should be denied")
 	}
+	if res.RetryAfter <= 0 || res.RetryAfter > 100*time.Millisecond || res.Reset <= 900*time.Millisecond {
+		t.Fatalf("expected retry within one refill and reset near 1s, got %+v", res)
+	}
 }
 
 // TestRedisStoreSlidingWindow tests Redis-backed sliding window with miniredis.
@@ -66,19 +69,198 @@ func TestRedisStoreSlidingWindow(t *testing.T) {
 	ctx := context.Background()
 
 	// Add 5 events within window
-	var count int64
+	var res Result
 	for i := 0; i < 5; i++ {
 		var err error
-		count, err = store.SlidingWindow(ctx, "endpoint:/api/users", 1000)
+		res, err = store.SlidingWindow(ctx, "endpoint:/api/users", 1000, 5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !res.Allowed {
+			t.Fatalf("event %d should be allowed", i+1)
+		}
+	}
+
+	// Verify all 5 events were kept, even within the same millisecond
+	if res.Remaining != 0 {
+		t.Fatalf("expected remaining 0, got %d", res.Remaining)
+	}
+	res, err = store.SlidingWindow(ctx, "endpoint:/api/users", 1000, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > time.Second {
+		t.Fatalf("expected denial with retry within the window, got %+v", res)
+	}
+	if members, _ := mr.ZMembers("endpoint:/api/users:sw"); len(members) != 5 {
+		t.Fatalf("expected 5 recorded events, got %d", len(members))
+	}
+}
+
+// TestRedisStoreGCRA tests the GCRA script with miniredis.
+func TestRedisStoreGCRA(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis run failed: %v", err)
+	}
+	defer mr.Close()
+
+	s, err := NewRedisStore(mr.Addr())
+	if err != nil {
+		t.Fatalf("failed to create redis store: %v", err)
+	}
+	store := s.(*redisStore)
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		res, err := store.GCRA(ctx, "gcra:ip:1", 5, 10, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !res.Allowed || res.Remaining != int64(4-i) {
+			t.Fatalf("request %d: expected allowed with %d remaining, got %+v", i+1, 4-i, res)
+		}
+	}
+	res, err := store.GCRA(ctx, "gcra:ip:1", 5, 10, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Allowed || res.RetryAfter != 100*time.Millisecond || res.Reset != 500*time.Millisecond {
+		t.Fatalf("expected denial with retry after 100ms and reset 500ms, got %+v", res)
+	}
+
+	// a single timestamp, expiring once the key has fully recovered
+	if got := mr.Keys(); len(got) != 1 {
+		t.Fatalf("expected one key, got %v", got)
+	}
+	if ttl := mr.TTL("gcra:ip:1"); ttl != 500*time.Millisecond {
+		t.Fatalf("expected a 500ms TTL, got %v", ttl)
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if res, _ = store.GCRA(ctx, "gcra:ip:1", 5, 10, 1); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("expected one emission interval to admit a request, got %+v", res)
+	}
+}
+
+// TestRedisStoreSlidingWindowCounter tests the sliding window counter script with miniredis.
+func TestRedisStoreSlidingWindowCounter(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis run failed: %v", err)
+	}
+	defer mr.Close()
+
+	s, err := NewRedisStore(mr.Addr())
+	if err != nil {
+		t.Fatalf("failed to create redis store: %v", err)
+	}
+	store := s.(*redisStore)
+	now := time.UnixMilli(1700000000000)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		res, err := store.SlidingWindowCounter(ctx, "swc:ip:1", 1000, 10, 1)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		time.Sleep(10 * time.Millisecond) // Small delay between events
+		if !res.Allowed || res.Remaining != int64(9-i) {
+			t.Fatalf("event %d: expected allowed with %d remaining, got %+v", i+1, 9-i, res)
+		}
+	}
+	res, err := store.SlidingWindowCounter(ctx, "swc:ip:1", 1000, 10, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Allowed || res.RetryAfter != 1100*time.Millisecond || res.Reset != 2000*time.Millisecond {
+		t.Fatalf("expected retry after 1.1s and reset 2s, got %+v", res)
+	}
+	if got := mr.HGet("swc:ip:1", "1700000000"); got != "10" {
+		t.Fatalf("expected a counter of 10 for the current window, got %q", got)
+	}
+
+	now = now.Add(1100 * time.Millisecond)
+	if res, _ = store.SlidingWindowCounter(ctx, "swc:ip:1", 1000, 10, 1); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("expected the 10th slot to open, got %+v", res)
+	}
+	res, _ = store.SlidingWindowCounter(ctx, "swc:ip:1", 1000, 10, 1)
+	if res.Allowed || res.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("expected retry after 100ms, got %+v", res)
+	}
+	if got, _ := mr.HKeys("swc:ip:1"); len(got) != 2 {
+		t.Fatalf("expected two counters, got %v", got)
 	}
+}
+
+// TestRedisStoreMulti tests the multi-limit script with miniredis.
+func TestRedisStoreMulti(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis run failed: %v", err)
+	}
+	defer mr.Close()
 
-	// Verify count is 5 after adding 5 events
-	if count != 5 {
-		t.Fatalf("expected count 5, got %d", count)
+	s, err := NewRedisStore(mr.Addr())
+	if err != nil {
+		t.Fatalf("failed to create redis store: %v", err)
+	}
+	store := s.(*redisStore)
+	now := time.UnixMilli(1700000000000)
+	store.now = func() time.Time { return now }
+	testStoreMulti(t, store)
+}
+
+// TestRedisStoreDebit tests weighted costs and debits with miniredis.
+func TestRedisStoreDebit(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis run failed: %v", err)
+	}
+	defer mr.Close()
+
+	s, err := NewRedisStore(mr.Addr())
+	if err != nil {
+		t.Fatalf("failed to create redis store: %v", err)
+	}
+	store := s.(*redisStore)
+	now := time.UnixMilli(1700000000000)
+	store.now = func() time.Time { return now }
+	testStoreDebit(t, store)
+	if tokens := mr.HGet("tb", "tokens"); tokens != "-6" {
+		t.Errorf("expected a negative balance of -6, got %q", tokens)
+	}
+}
+
+func TestRedisStoreSlidingWindowWeights(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis run failed: %v", err)
+	}
+	defer mr.Close()
+
+	s, err := NewRedisStore(mr.Addr())
+	if err != nil {
+		t.Fatalf("failed to create redis store: %v", err)
+	}
+	store := s.(*redisStore)
+	store.now = func() time.Time { return time.UnixMilli(1700000000000) }
+	testStoreSlidingWindowWeights(t, store, func(at time.Time) { store.now = func() time.Time { return at } })
+	if members, _ := mr.ZMembers("w:sw"); len(members) != 2 {
+		t.Errorf("expected one member per admitted event in the window, got %v", members)
+	}
+
+	// members written before weights were recorded count once
+	mr.ZAdd("legacy:sw", 1700000000000, "1700000000000-abc-1")
+	store.now = func() time.Time { return time.UnixMilli(1700000000000) }
+	results, err := store.Multi(context.Background(), []Check{{Algorithm: AlgSlidingWindow, Key: "legacy", Limit: 2, WindowMillis: 1000, Cost: 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !results[0].Allowed || results[0].Remaining != 0 {
+		t.Fatalf("expected the legacy member to count once, got %+v", results[0])
 	}
 }
 
@@ -1,14 +1,71 @@
 package repository
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // Store defines methods used by rate-limit algorithms. Implementations must be concurrency-safe
 // and support distributed atomic operations when backed by Redis.
 type Store interface {
 	// TokenBucket attempts to take `tokens` from the bucket identified by key.
-	// Returns allowed, remaining tokens, error.
-	TokenBucket(ctx context.Context, key string, capacity int64, refillRate float64, tokens int64) (bool, int64, error)
+	// Remaining is the number of whole tokens left in the bucket.
+	TokenBucket(ctx context.Context, key string, capacity int64, refillRate float64, tokens int64) (Result, error)
 
-	// SlidingWindow increments event at current timestamp and returns count within window.
-	SlidingWindow(ctx context.Context, key string, windowMillis int64) (int64, error)
+	// SlidingWindow records an event at the current timestamp if fewer than limit
+	// events fall within the window. Every admitted event is stored.
+	SlidingWindow(ctx context.Context, key string, windowMillis, limit int64) (Result, error)
+
+	// GCRA applies the generic cell rate algorithm: requests are admitted at rate
+	// per second with bursts of up to burst, and cost requests are taken at once.
+	// Only the theoretical arrival time is stored per key.
+	GCRA(ctx context.Context, key string, burst int64, rate float64, cost int64) (Result, error)
+
+	// SlidingWindowCounter admits up to limit events per sliding window, estimated
+	// from the counts of the current and previous fixed windows. Denied events are
+	// not counted. Only two counters are stored per key.
+	SlidingWindowCounter(ctx context.Context, key string, windowMillis, limit, cost int64) (Result, error)
+
+	// Multi evaluates several checks atomically, one result per check. The event is
+	// taken from every check only if all of them allow it; otherwise nothing is
+	// consumed. Keys must be distinct.
+	Multi(ctx context.Context, checks []Check) ([]Result, error)
+
+	// Debit takes Cost from every check without enforcing its limit, so a key may
+	// go into debt (e.g. a negative token balance) that delays later requests. It
+	// settles costs that are only known after a request was admitted.
+	Debit(ctx context.Context, checks []Check) error
+}
+
+// Algorithm names a rate-limit algorithm of a Store.
+type Algorithm string
+
+const (
+	AlgTokenBucket          Algorithm = "tokenbucket"
+	AlgSlidingWindow        Algorithm = "slidingwindow"
+	AlgGCRA                 Algorithm = "gcra"
+	AlgSlidingWindowCounter Algorithm = "slidingwindowcounter"
+)
+
+// Check is one limit evaluated by Multi or Debit. Its fields are the arguments of the
+// single-algorithm method of the same name.
+type Check struct {
+	Algorithm Algorithm
+	Key       string
+	// Limit is the bucket capacity, the GCRA burst or the window limit.
+	Limit        int64
+	Rate         float64 // per second, for token bucket and GCRA
+	WindowMillis int64   // for the sliding windows
+	Cost         int64   // tokens or events taken
+}
+
+// Result is the outcome of a rate-limit check.
+type Result struct {
+	Allowed   bool
+	Remaining int64
+	// RetryAfter is how long a denied request must wait before it would be
+	// admitted; zero when allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the key is back to its full quota.
+	Reset time.Duration
 }
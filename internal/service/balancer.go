@@ -0,0 +1,199 @@
+package service
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Load-balancing strategy names accepted in cluster configuration.
+const (
+	RoundRobinLB         = "round_robin"
+	WeightedRoundRobinLB = "weighted_round_robin"
+	LeastRequestLB       = "least_request"
+	RandomTwoChoicesLB   = "random_two_choices"
+	ConsistentHashLB     = "consistent_hash"
+)
+
+// Balancer picks one target out of the currently available targets of a cluster.
+// Implementations must be concurrency-safe; targets is never empty.
+type Balancer interface {
+	Pick(r *http.Request, targets []*Target) *Target
+}
+
+// NewBalancer returns the balancer for a strategy name. hashOn is only used by consistent_hash.
+func NewBalancer(strategy, hashOn string) (Balancer, error) {
+	switch strategy {
+	case "", RoundRobinLB:
+		return &roundRobin{}, nil
+	case WeightedRoundRobinLB:
+		return &weightedRoundRobin{}, nil
+	case LeastRequestLB:
+		return leastRequest{}, nil
+	case RandomTwoChoicesLB:
+		return randomTwoChoices{}, nil
+	case ConsistentHashLB:
+		key, err := parseHashOn(hashOn)
+		if err != nil {
+			return nil, err
+		}
+		return &consistentHash{key: key}, nil
+	default:
+		return nil, fmt.Errorf("unknown load balancer %q", strategy)
+	}
+}
+
+// roundRobin cycles through targets in order.
+type roundRobin struct {
+	next uint64
+}
+
+func (b *roundRobin) Pick(r *http.Request, targets []*Target) *Target {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return targets[n%uint64(len(targets))]
+}
+
+// weightedRoundRobin implements smooth weighted round-robin (as in nginx):
+// each pick adds every target's weight to its running score and takes the highest.
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*Target]int
+}
+
+func (b *weightedRoundRobin) Pick(r *http.Request, targets []*Target) *Target {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current == nil {
+		b.current = make(map[*Target]int)
+	}
+	var best *Target
+	total := 0
+	for _, t := range targets {
+		b.current[t] += t.Weight()
+		total += t.Weight()
+		if best == nil || b.current[t] > b.current[best] {
+			best = t
+		}
+	}
+	b.current[best] -= total
+	// drop scores of targets that left the pool
+	if len(b.current) > len(targets) {
+		live := make(map[*Target]bool, len(targets))
+		for _, t := range targets {
+			live[t] = true
+		}
+		for t := range b.current {
+			if !live[t] {
+				delete(b.current, t)
+			}
+		}
+	}
+	return best
+}
+
+// leastRequest picks the target with the fewest outstanding requests.
+type leastRequest struct{}
+
+func (leastRequest) Pick(r *http.Request, targets []*Target) *Target {
+	best := targets[0]
+	for _, t := range targets[1:] {
+		if t.Inflight() < best.Inflight() {
+			best = t
+		}
+	}
+	return best
+}
+
+// randomTwoChoices samples two targets at random and picks the less loaded one.
+type randomTwoChoices struct{}
+
+func (randomTwoChoices) Pick(r *http.Request, targets []*Target) *Target {
+	if len(targets) == 1 {
+		return targets[0]
+	}
+	i := rand.Intn(len(targets))
+	j := rand.Intn(len(targets) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := targets[i], targets[j]
+	if b.Inflight() < a.Inflight() {
+		return b
+	}
+	return a
+}
+
+// hashKey extracts the consistent hash key from a request.
+type hashKey func(r *http.Request) string
+
+func parseHashOn(spec string) (hashKey, error) {
+	kind, name, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "client_ip":
+		return ClientIP, nil
+	case "header":
+		if name == "" {
+			return nil, fmt.Errorf("hash_on header requires a name")
+		}
+		return func(r *http.Request) string { return r.Header.Get(name) }, nil
+	case "cookie":
+		if name == "" {
+			return nil, fmt.Errorf("hash_on cookie requires a name")
+		}
+		return func(r *http.Request) string {
+			if c, err := r.Cookie(name); err == nil {
+				return c.Value
+			}
+			return ""
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown hash_on %q", spec)
+	}
+}
+
+// consistentHash uses weighted rendezvous hashing, so only keys owned by a target
+// that leaves or joins the pool are remapped.
+type consistentHash struct {
+	key hashKey
+}
+
+func (b *consistentHash) Pick(r *http.Request, targets []*Target) *Target {
+	key := b.key(r)
+	if key == "" {
+		key = ClientIP(r)
+	}
+	var best *Target
+	bestScore := math.Inf(-1)
+	for _, t := range targets {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(t.URL.Host))
+		// map hash to (0,1) and weight it: score = -w / ln(u)
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		score := -float64(t.Weight()) / math.Log(u)
+		if score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	return best
+}
+
+// ClientIP returns the originating client address, honouring X-Forwarded-For.
+func ClientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first, _, _ := strings.Cut(xff, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
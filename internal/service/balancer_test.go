@@ -0,0 +1,101 @@
+package service
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func testTargets(weights ...int) []*Target {
+	targets := make([]*Target, len(weights))
+	for i, w := range weights {
+		targets[i] = &Target{URL: &url.URL{Scheme: "http", Host: string(rune('a'+i)) + ":80"}}
+		targets[i].weight.Store(int64(w))
+	}
+	return targets
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	lb, _ := NewBalancer(RoundRobinLB, "")
+	targets := testTargets(1, 1, 1)
+	req := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i < 6; i++ {
+		if got := lb.Pick(req, targets); got != targets[i%3] {
+			t.Fatalf("pick %d: expected %s, got %s", i, targets[i%3].URL.Host, got.URL.Host)
+		}
+	}
+}
+
+func TestWeightedRoundRobinBalancer(t *testing.T) {
+	lb, _ := NewBalancer(WeightedRoundRobinLB, "")
+	targets := testTargets(5, 1, 1)
+	req := httptest.NewRequest("GET", "/", nil)
+	counts := map[*Target]int{}
+	for i := 0; i < 70; i++ {
+		counts[lb.Pick(req, targets)]++
+	}
+	if counts[targets[0]] != 50 || counts[targets[1]] != 10 || counts[targets[2]] != 10 {
+		t.Fatalf("unexpected distribution: %d/%d/%d", counts[targets[0]], counts[targets[1]], counts[targets[2]])
+	}
+}
+
+func TestLeastRequestBalancer(t *testing.T) {
+	lb, _ := NewBalancer(LeastRequestLB, "")
+	targets := testTargets(1, 1, 1)
+	targets[0].inflight = 3
+	targets[1].inflight = 1
+	targets[2].inflight = 2
+	if got := lb.Pick(httptest.NewRequest("GET", "/", nil), targets); got != targets[1] {
+		t.Fatalf("expected least loaded target, got %s", got.URL.Host)
+	}
+}
+
+func TestRandomTwoChoicesBalancer(t *testing.T) {
+	lb, _ := NewBalancer(RandomTwoChoicesLB, "")
+	targets := testTargets(1, 1)
+	targets[0].inflight = 10
+	req := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i < 20; i++ {
+		if got := lb.Pick(req, targets); got != targets[1] {
+			t.Fatalf("expected less loaded target, got %s", got.URL.Host)
+		}
+	}
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	lb, err := NewBalancer(ConsistentHashLB, "header:X-User-ID")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	targets := testTargets(1, 1, 1, 1)
+
+	owners := map[string]*Target{}
+	for _, user := range []string{"alice", "bob", "carol", "dave", "erin", "frank"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User-ID", user)
+		owners[user] = lb.Pick(req, targets)
+		if again := lb.Pick(req, targets); again != owners[user] {
+			t.Fatalf("%s: hash pick not stable", user)
+		}
+	}
+
+	// removing a target only remaps the keys it owned
+	removed := targets[0]
+	for user, owner := range owners {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User-ID", user)
+		got := lb.Pick(req, targets[1:])
+		if owner != removed && got != owner {
+			t.Fatalf("%s: remapped although its target stayed", user)
+		}
+	}
+}
+
+func TestNewBalancerInvalid(t *testing.T) {
+	if _, err := NewBalancer("bogus", ""); err == nil {
+		t.Fatal("expected error for unknown strategy")
+	}
+	if _, err := NewBalancer(ConsistentHashLB, "header:"); err == nil {
+		t.Fatal("expected error for header without name")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/md5"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"sync"
 	"time"
@@ -194,25 +195,27 @@ func NewCachedRoundTripper(cache *ResponseCache) *CachedRoundTripper {
 
 // RoundTrip implements http.RoundTripper
 func (crt *CachedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	// Only cache GET requests
-	if req.Method != http.MethodGet {
+	// Only cache GET requests, and never responses to credentialed requests
+	if req.Method != http.MethodGet || hasCredentials(req) {
 		return crt.transport.RoundTrip(req)
 	}
 
 	// Check cache
-	cacheKey := GenerateCacheKey(req.Method, req.URL.Path, req.URL.RawQuery)
+	// The host is part of the key so hosts sharing a route never share entries
+	cacheKey := GenerateCacheKey(req.Method, req.Host+req.URL.Path, req.URL.RawQuery)
 	if cached, exists := crt.cache.Get(cacheKey); exists {
-		// Return cached response
+		// Return cached response on a copy of the headers, which callers modify
+		header := cached.Headers.Clone()
+		header.Set("X-Cache", "HIT")
 		return &http.Response{
 			Status:     fmt.Sprintf("%d %s", cached.Status, http.StatusText(cached.Status)),
 			StatusCode: cached.Status,
 			Proto:      "HTTP/1.1",
 			ProtoMajor: 1,
 			ProtoMinor: 1,
-			Header:     cached.Headers,
+			Header:     header,
 			Body:       io.NopCloser(bytes.NewReader(cached.Body)),
 			Request:    req,
-			// Add cache hit header for debugging
 		}, nil
 	}
 
@@ -222,28 +225,78 @@ func (crt *CachedRoundTripper) RoundTrip(req *http.Request) (*http.Response, err
 		return resp, err
 	}
 
-	// Read response body
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	// Cache if applicable
-	if CacheableResponse(resp.StatusCode, resp.Header) {
-		ttl := ExtractCacheTTL(resp.Header)
-		entry := &CacheEntry{
-			Status:    resp.StatusCode,
-			Headers:   resp.Header.Clone(),
-			Body:      body,
-			ExpiresAt: time.Now().Add(ttl),
-			CreatedAt: time.Now(),
+	// Never buffer event streams; other cacheable bodies are copied into the
+	// cache as the client reads them, so large downloads keep streaming.
+	if IsStreamingResponse(resp) || !CacheableResponse(resp.StatusCode, resp.Header) || resp.Header.Get("Set-Cookie") != "" {
+		return resp, nil
+	}
+	resp.Body = &cachingBody{
+		ReadCloser: resp.Body,
+		cache:      crt.cache,
+		key:        cacheKey,
+		length:     resp.ContentLength,
+		entry: &CacheEntry{
+			Status:  resp.StatusCode,
+			Headers: resp.Header.Clone(),
+		},
+	}
+	resp.Header.Set("X-Cache", "MISS")
+	return resp, nil
+}
+
+// hasCredentials reports whether a response to req may be specific to the caller.
+func hasCredentials(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != "" || req.Header.Get("X-API-Key") != ""
+}
+
+// IsStreamingResponse reports whether resp is an event stream that must be
+// relayed as it arrives.
+func IsStreamingResponse(resp *http.Response) bool {
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return ct == "text/event-stream"
+}
+
+// cachingBody copies a response body into the cache while it is read. The
+// entry is stored once the body is read to the end within the cache's entry
+// size limit; a body closed early is not cached.
+type cachingBody struct {
+	io.ReadCloser
+	cache  *ResponseCache
+	key    string
+	length int64 // Content-Length, -1 if unknown
+	entry  *CacheEntry
+	buf    bytes.Buffer
+	// skip stops buffering after an error or once the body exceeds the limit
+	skip bool
+	done bool
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.skip {
+		b.buf.Write(p[:n])
+		if int64(b.buf.Len()) > b.cache.maxEntry {
+			b.skip = true
+			b.buf = bytes.Buffer{}
 		}
-		crt.cache.Set(cacheKey, entry)
-		resp.Header.Set("X-Cache", "MISS")
 	}
+	switch {
+	case err == io.EOF:
+		b.store()
+	case err != nil:
+		b.skip = true
+	}
+	return n, err
+}
 
-	// Return response with new body
-	resp.Body = io.NopCloser(bytes.NewReader(body))
-	return resp, nil
+func (b *cachingBody) store() {
+	if b.skip || b.done || (b.length >= 0 && int64(b.buf.Len()) != b.length) {
+		return
+	}
+	b.done = true
+	now := time.Now()
+	b.entry.Body = b.buf.Bytes()
+	b.entry.CreatedAt = now
+	b.entry.ExpiresAt = now.Add(ExtractCacheTTL(b.entry.Headers))
+	b.cache.Set(b.key, b.entry)
 }
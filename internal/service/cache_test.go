@@ -1,8 +1,11 @@
 package service
 
 import (
+	"bufio"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 )
@@ -104,6 +107,7 @@ func TestCachedRoundTripper_CachesGET(t *testing.T) {
 	// First request - should hit server
 	req, _ := http.NewRequest("GET", server.URL+"/test", nil)
 	resp1, _ := client.Do(req)
+	io.ReadAll(resp1.Body)
 	resp1.Body.Close()
 
 	if callCount != 1 {
@@ -113,6 +117,7 @@ func TestCachedRoundTripper_CachesGET(t *testing.T) {
 	// Second request - should use cache
 	req, _ = http.NewRequest("GET", server.URL+"/test", nil)
 	resp2, _ := client.Do(req)
+	io.ReadAll(resp2.Body)
 	resp2.Body.Close()
 
 	if callCount != 1 {
@@ -149,6 +154,202 @@ func TestCachedRoundTripper_SkipsPOST(t *testing.T) {
 	}
 }
 
+func TestCachedRoundTripper_StreamsEventStream(t *testing.T) {
+	rc := NewResponseCache(100, 1024*1024)
+	crt := NewCachedRoundTripper(rc)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := &http.Client{Transport: crt}
+	resp, err := client.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first event must arrive while the upstream is still streaming.
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Fatalf("expected first event, got %q (%v)", line, err)
+	}
+	if resp.Header.Get("X-Cache") != "" {
+		t.Error("event streams must not be cached")
+	}
+}
+
+func TestCachedRoundTripper_SkipsOversizedBody(t *testing.T) {
+	rc := NewResponseCache(100, 4)
+	crt := NewCachedRoundTripper(rc)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.Write([]byte("larger than the entry limit"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: crt}
+	resp, err := client.Get(server.URL + "/download")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "larger than the entry limit" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if rc.GetSize() != 0 {
+		t.Errorf("expected oversized body not to be cached, got %d entries", rc.GetSize())
+	}
+}
+
+func TestCachedRoundTripper_CloseDoesNotDrain(t *testing.T) {
+	rc := NewResponseCache(100, 1024*1024)
+	crt := NewCachedRoundTripper(rc)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("part"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	resp, err := (&http.Client{Transport: crt}).Get(server.URL + "/slow")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		resp.Body.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("closing an unread body must not wait for the upstream")
+	}
+	if rc.GetSize() != 0 {
+		t.Errorf("expected a body closed early not to be cached, got %d entries", rc.GetSize())
+	}
+}
+
+func TestCachedRoundTripper_SkipsCredentialedRequests(t *testing.T) {
+	rc := NewResponseCache(100, 1024*1024)
+	crt := NewCachedRoundTripper(rc)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/session" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+		}
+		w.Write([]byte("private"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: crt}
+	for _, h := range []string{"Authorization", "Cookie", "X-API-Key"} {
+		req, _ := http.NewRequest("GET", server.URL+"/me", nil)
+		req.Header.Set(h, "secret")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	resp, err := client.Get(server.URL + "/session")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if rc.GetSize() != 0 {
+		t.Errorf("expected credentialed requests and cookies not to be cached, got %d entries", rc.GetSize())
+	}
+}
+
+func TestCachedRoundTripper_HitsDoNotShareHeaders(t *testing.T) {
+	rc := NewResponseCache(100, 1024*1024)
+	crt := NewCachedRoundTripper(rc)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.Write([]byte("shared"))
+	}))
+	defer server.Close()
+
+	get := func() *http.Response {
+		req, _ := http.NewRequest("GET", server.URL+"/shared", nil)
+		resp, err := crt.RoundTrip(req)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return nil
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+	get()
+
+	// callers such as the reverse proxy change the headers of every response
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp := get(); resp != nil {
+				resp.Header.Add("X-Via", "gw")
+				resp.Header.Del("Cache-Control")
+			}
+		}()
+	}
+	wg.Wait()
+
+	resp := get()
+	if resp.Header.Get("X-Cache") != "HIT" || resp.Header.Get("Cache-Control") != "max-age=300" || len(resp.Header.Values("X-Via")) != 0 {
+		t.Fatalf("expected the cached headers unchanged, got %v", resp.Header)
+	}
+}
+
+func TestCachedRoundTripper_KeysByHost(t *testing.T) {
+	rc := NewResponseCache(100, 1024*1024)
+	crt := NewCachedRoundTripper(rc)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+
+	for _, host := range []string{"a.example.com", "b.example.com", "a.example.com"} {
+		req, _ := http.NewRequest("GET", server.URL+"/home", nil)
+		req.Host = host
+		resp, err := crt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != host {
+			t.Fatalf("expected the response of %s, got %q", host, body)
+		}
+	}
+	if rc.GetSize() != 2 {
+		t.Errorf("expected one entry per host, got %d", rc.GetSize())
+	}
+}
+
 func TestGenerateCacheKey(t *testing.T) {
 	key1 := GenerateCacheKey("GET", "/api/users", "")
 	key2 := GenerateCacheKey("GET", "/api/users", "")
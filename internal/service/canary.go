@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"api-gateway/internal/config"
+)
+
+// Traffic split variants.
+const (
+	VariantStable = "stable"
+	VariantCanary = "canary"
+)
+
+// Canary splits a route's traffic between its cluster and a canary cluster.
+// The weight can be changed at runtime.
+type Canary struct {
+	Cluster        string
+	weight         atomic.Int32
+	cookie         string
+	stickyOn       string
+	overrideHeader string
+}
+
+// NewCanary compiles a canary config.
+func NewCanary(cfg config.CanaryConfig) (*Canary, error) {
+	c := &Canary{Cluster: cfg.Cluster, overrideHeader: cfg.OverrideHeader}
+	if c.overrideHeader == "" {
+		c.overrideHeader = "X-Canary"
+	}
+	kind, name, _ := strings.Cut(cfg.StickyOn, ":")
+	switch kind {
+	case "", "api_key", "user_id":
+		c.stickyOn = kind
+	case "cookie":
+		if name == "" {
+			return nil, fmt.Errorf("canary sticky_on cookie requires a name")
+		}
+		c.stickyOn, c.cookie = kind, name
+	default:
+		return nil, fmt.Errorf("unknown canary sticky_on %q", cfg.StickyOn)
+	}
+	if err := c.SetWeight(cfg.Weight); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Weight returns the percentage of traffic sent to the canary.
+func (c *Canary) Weight() int {
+	return int(c.weight.Load())
+}
+
+// SetWeight changes the percentage of traffic sent to the canary.
+func (c *Canary) SetWeight(w int) error {
+	if w < 0 || w > 100 {
+		return fmt.Errorf("canary weight must be between 0 and 100")
+	}
+	c.weight.Store(int32(w))
+	return nil
+}
+
+// Choose picks the variant for a request. When stickiness is cookie based and the
+// client has no valid cookie yet, it returns the cookie to set on the response; the
+// cookie holds the client's bucket (0-99), compared with the current weight.
+func (c *Canary) Choose(r *http.Request) (string, *http.Cookie) {
+	switch strings.ToLower(r.Header.Get(c.overrideHeader)) {
+	case "always":
+		return VariantCanary, nil
+	case "never":
+		return VariantStable, nil
+	}
+
+	var key string
+	switch c.stickyOn {
+	case "cookie":
+		// the cookie pins the client's bucket, not the variant, so weight
+		// changes move clients in both directions
+		if ck, err := r.Cookie(c.cookie); err == nil {
+			if n, err := strconv.Atoi(ck.Value); err == nil && n >= 0 && n < 100 {
+				return c.bucket(n), nil
+			}
+		}
+		n := rand.Intn(100)
+		return c.bucket(n), &http.Cookie{Name: c.cookie, Value: strconv.Itoa(n), Path: "/", HttpOnly: true}
+	case "api_key":
+		key = r.Header.Get("X-API-Key")
+	case "user_id":
+		// the authenticated principal; a client-sent X-User-ID header could pick
+		// its own variant
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			key = id.Principal
+		}
+	}
+	if key == "" {
+		return c.bucket(rand.Intn(100)), nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.bucket(int(h.Sum32() % 100)), nil
+}
+
+// bucket maps a value in [0,100) to a variant.
+func (c *Canary) bucket(n int) string {
+	if n < c.Weight() {
+		return VariantCanary
+	}
+	return VariantStable
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestCanaryOverrideHeader(t *testing.T) {
+	c, err := NewCanary(config.CanaryConfig{Cluster: "v2", Weight: 0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Canary", "always")
+	if v, _ := c.Choose(req); v != VariantCanary {
+		t.Fatalf("expected canary with override, got %s", v)
+	}
+	c.SetWeight(100)
+	req.Header.Set("X-Canary", "never")
+	if v, _ := c.Choose(req); v != VariantStable {
+		t.Fatalf("expected stable with override, got %s", v)
+	}
+}
+
+func TestCanaryStickyOnUserID(t *testing.T) {
+	c, err := NewCanary(config.CanaryConfig{Cluster: "v2", Weight: 50, StickyOn: "user_id"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	counts := map[string]int{}
+	for i := 0; i < 200; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req = req.WithContext(WithIdentity(req.Context(), Identity{Principal: "user-" + strconv.Itoa(i)}))
+		first, _ := c.Choose(req)
+		for j := 0; j < 5; j++ {
+			// a client-sent header must not move an authenticated user
+			req.Header.Set("X-User-ID", "forged-"+strconv.Itoa(j))
+			if v, _ := c.Choose(req); v != first {
+				t.Fatalf("user-%d switched variant", i)
+			}
+		}
+		counts[first]++
+	}
+	if counts[VariantCanary] == 0 || counts[VariantStable] == 0 {
+		t.Fatalf("expected both variants, got %v", counts)
+	}
+
+	// without an identity the header is ignored and requests are split at random
+	counts = map[string]int{}
+	for i := 0; i < 200; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User-ID", "forged")
+		v, _ := c.Choose(req)
+		counts[v]++
+	}
+	if counts[VariantCanary] == 0 || counts[VariantStable] == 0 {
+		t.Fatalf("expected anonymous requests to be split at random, got %v", counts)
+	}
+}
+
+func TestCanaryStickyOnAPIKey(t *testing.T) {
+	c, err := NewCanary(config.CanaryConfig{Cluster: "v2", Weight: 50, StickyOn: "api_key"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	counts := map[string]int{}
+	for i := 0; i < 200; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", "key-"+string(rune('a'+i%26))+string(rune('a'+i/26)))
+		first, _ := c.Choose(req)
+		for j := 0; j < 5; j++ {
+			if v, _ := c.Choose(req); v != first {
+				t.Fatalf("api key %s switched variant", req.Header.Get("X-API-Key"))
+			}
+		}
+		counts[first]++
+	}
+	if counts[VariantCanary] == 0 || counts[VariantStable] == 0 {
+		t.Fatalf("expected both variants, got %v", counts)
+	}
+}
+
+func TestCanaryStickyCookie(t *testing.T) {
+	c, err := NewCanary(config.CanaryConfig{Cluster: "v2", Weight: 100, StickyOn: "cookie:release"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v, ck := c.Choose(httptest.NewRequest("GET", "/", nil))
+	if v != VariantCanary || ck == nil || ck.Name != "release" {
+		t.Fatalf("expected canary with cookie, got %s %v", v, ck)
+	}
+	if n, err := strconv.Atoi(ck.Value); err != nil || n < 0 || n > 99 {
+		t.Fatalf("expected the cookie to hold a bucket 0-99, got %q", ck.Value)
+	}
+
+	// The cookie pins the bucket; the current weight decides the variant.
+	choose := func(bucket string) (string, *http.Cookie) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "release", Value: bucket})
+		return c.Choose(req)
+	}
+	c.SetWeight(30)
+	if v, ck := choose("29"); v != VariantCanary || ck != nil {
+		t.Fatalf("expected bucket 29 on canary without a new cookie, got %s %v", v, ck)
+	}
+	if v, _ := choose("30"); v != VariantStable {
+		t.Fatalf("expected bucket 30 on stable, got %s", v)
+	}
+	// raising the weight migrates stable clients, rolling back returns everyone
+	c.SetWeight(50)
+	if v, _ := choose("30"); v != VariantCanary {
+		t.Fatalf("expected bucket 30 to move to canary at 50%%, got %s", v)
+	}
+	c.SetWeight(0)
+	if v, _ := choose("0"); v != VariantStable {
+		t.Fatalf("expected a rollback to move bucket 0 to stable, got %s", v)
+	}
+
+	// old or forged values get a fresh bucket
+	if _, ck := choose(VariantCanary); ck == nil {
+		t.Fatal("expected an invalid cookie to be replaced")
+	}
+	if _, ck := choose("100"); ck == nil {
+		t.Fatal("expected an out-of-range bucket to be replaced")
+	}
+}
+
+func TestCanarySetWeightBounds(t *testing.T) {
+	c, err := NewCanary(config.CanaryConfig{Cluster: "v2", Weight: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.SetWeight(101); err == nil {
+		t.Fatal("expected error for weight above 100")
+	}
+	if err := c.SetWeight(-1); err == nil {
+		t.Fatal("expected error for negative weight")
+	}
+	if c.Weight() != 10 {
+		t.Fatalf("weight changed after invalid update: %d", c.Weight())
+	}
+	if _, err := NewCanary(config.CanaryConfig{Cluster: "v2", StickyOn: "session"}); err == nil {
+		t.Fatal("expected error for unknown sticky_on")
+	}
+}
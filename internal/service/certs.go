@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CertStore holds the TLS certificates served by the gateway and picks one per
+// handshake by SNI. Certificate files are re-read when they change on disk.
+type CertStore struct {
+	metrics *metrics.Registry
+
+	mu     sync.RWMutex
+	pairs  []*certPair
+	byName map[string]*tls.Certificate // lower-cased DNS names, including "*.example.com"
+}
+
+// certPair is one configured certificate and the file state it was loaded from.
+type certPair struct {
+	cfg     config.CertificateConfig
+	cert    *tls.Certificate
+	modTime time.Time
+	size    int64
+}
+
+// NewCertStore loads every certificate pair. m may be nil.
+func NewCertStore(cfgs []config.CertificateConfig, m *metrics.Registry) (*CertStore, error) {
+	if len(cfgs) == 0 {
+		return nil, errors.New("no tls certificates configured")
+	}
+	s := &CertStore{metrics: m}
+	for _, cc := range cfgs {
+		p := &certPair{cfg: cc}
+		if err := s.load(p); err != nil {
+			return nil, err
+		}
+		s.pairs = append(s.pairs, p)
+	}
+	s.index()
+	return s, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. An exact name match wins
+// over a wildcard; clients without a known server name get the first certificate.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if c, ok := s.byName[name]; ok {
+		return c, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if c, ok := s.byName["*"+name[i:]]; ok {
+			return c, nil
+		}
+	}
+	return s.pairs[0].cert, nil
+}
+
+// Reload re-reads certificate pairs whose files changed since they were loaded.
+// A pair that fails to load keeps serving its previous certificate.
+func (s *CertStore) Reload() error {
+	s.mu.RLock()
+	pairs := s.pairs
+	s.mu.RUnlock()
+	var errs []error
+	changed := false
+	for _, p := range pairs {
+		modTime, size, err := pairState(p.cfg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if modTime.Equal(p.modTime) && size == p.size {
+			continue
+		}
+		next := &certPair{cfg: p.cfg}
+		if err := s.load(next); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.mu.Lock()
+		prev := p.cert.Leaf.Subject.CommonName
+		*p = *next
+		s.mu.Unlock()
+		if cn := p.cert.Leaf.Subject.CommonName; s.metrics != nil && cn != prev {
+			s.metrics.TLSCertificateExpiry.DeleteLabelValues(p.cfg.CertFile, prev)
+		}
+		changed = true
+		log.Info().Str("certificate", p.cfg.CertFile).Time("not_after", p.cert.Leaf.NotAfter).Msg("tls certificate reloaded")
+	}
+	if changed {
+		s.index()
+	}
+	return errors.Join(errs...)
+}
+
+// Watch checks the certificate files every interval until ctx is cancelled.
+func (s *CertStore) Watch(ctx context.Context, interval time.Duration) {
+	watchFiles(ctx, interval, "tls certificate", s.Reload)
+}
+
+// watchFiles calls reload every interval until ctx is cancelled, logging failures.
+func watchFiles(ctx context.Context, interval time.Duration, what string, reload func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := reload(); err != nil {
+				log.Error().Err(err).Msg(what + " reload failed")
+			}
+		}
+	}()
+}
+
+// load reads p's files into p and records the certificate's expiry.
+func (s *CertStore) load(p *certPair) error {
+	modTime, size, err := pairState(p.cfg)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(p.cfg.CertFile, p.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load tls certificate %s: %w", p.cfg.CertFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("parse tls certificate %s: %w", p.cfg.CertFile, err)
+		}
+	}
+	p.cert, p.modTime, p.size = &cert, modTime, size
+	if s.metrics != nil {
+		s.metrics.TLSCertificateExpiry.WithLabelValues(p.cfg.CertFile, cert.Leaf.Subject.CommonName).
+			Set(float64(cert.Leaf.NotAfter.Unix()))
+	}
+	return nil
+}
+
+// index rebuilds the SNI lookup table. Earlier pairs win when names overlap.
+func (s *CertStore) index() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byName := make(map[string]*tls.Certificate)
+	for _, p := range s.pairs {
+		names := p.cert.Leaf.DNSNames
+		if len(names) == 0 && p.cert.Leaf.Subject.CommonName != "" {
+			names = []string{p.cert.Leaf.Subject.CommonName}
+		}
+		for _, n := range names {
+			n = strings.ToLower(n)
+			if _, ok := byName[n]; !ok {
+				byName[n] = p.cert
+			}
+		}
+	}
+	s.byName = byName
+}
+
+// pairState returns the latest modification time and combined size of a pair's files.
+func pairState(cc config.CertificateConfig) (time.Time, int64, error) {
+	return filesState(cc.CertFile, cc.KeyFile)
+}
+
+// filesState returns the latest modification time and combined size of files.
+func filesState(files ...string) (time.Time, int64, error) {
+	var modTime time.Time
+	var size int64
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, 0, fmt.Errorf("stat tls file: %w", err)
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+		size += fi.Size()
+	}
+	return modTime, size, nil
+}
+
+// ServerTLSConfig builds the listener TLS configuration from cfg, serving
+// certificates from certs. clients enables mutual TLS and may be nil. cfg must
+// have been validated.
+func ServerTLSConfig(cfg config.TLSConfig, certs *CertStore, clients *ClientAuth) *tls.Config {
+	cfg = cfg.WithDefaults()
+	minVersion, _ := config.ParseTLSVersion(cfg.MinVersion)
+	suites, _ := config.ParseCipherSuites(cfg.CipherSuites)
+	tc := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+		// set here rather than left to http.Server, whose additions would be lost
+		// by the per-handshake config of mutual TLS
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if clients != nil {
+		clients.configure(tc)
+	}
+	return tc
+}
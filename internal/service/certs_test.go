@@ -0,0 +1,153 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// writeSelfSigned writes a self-signed certificate for names to dir/base.{crt,key}.
+func writeSelfSigned(t *testing.T, dir, base string, notAfter time.Time, names ...string) config.CertificateConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := config.CertificateConfig{CertFile: filepath.Join(dir, base+".crt"), KeyFile: filepath.Join(dir, base+".key")}
+	if err := os.WriteFile(cc.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cc.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func TestCertStoreSNI(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	def := writeSelfSigned(t, dir, "default", expiry, "api.example.com")
+	wild := writeSelfSigned(t, dir, "wild", expiry, "*.apps.example.com")
+	m := metrics.NewRegistry()
+	s, err := NewCertStore([]config.CertificateConfig{def, wild}, m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := map[string]string{
+		"api.example.com":       "api.example.com",
+		"API.example.com.":      "api.example.com",
+		"shop.apps.example.com": "*.apps.example.com",
+		"unknown.test":          "api.example.com",
+		"":                      "api.example.com",
+	}
+	for sni, want := range cases {
+		c, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", sni, err)
+		}
+		if got := c.Leaf.DNSNames[0]; got != want {
+			t.Errorf("%q: expected certificate for %s, got %s", sni, want, got)
+		}
+	}
+
+	got := testutil.ToFloat64(m.TLSCertificateExpiry.WithLabelValues(wild.CertFile, "*.apps.example.com"))
+	if got != float64(expiry.Unix()) {
+		t.Errorf("expected expiry %d, got %v", expiry.Unix(), got)
+	}
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	cc := writeSelfSigned(t, dir, "api", time.Now().Add(time.Hour), "old.example.com")
+	m := metrics.NewRegistry()
+	s, err := NewCertStore([]config.CertificateConfig{cc}, m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := s.Reload(); err != nil {
+		t.Fatalf("unexpected error on unchanged files: %v", err)
+	}
+
+	writeSelfSigned(t, dir, "api", time.Now().Add(time.Hour), "new.example.com")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(cc.CertFile, later, later)
+	if err := s.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c, _ := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "new.example.com"})
+	if c.Leaf.DNSNames[0] != "new.example.com" {
+		t.Fatalf("expected reloaded certificate, got %v", c.Leaf.DNSNames)
+	}
+	if n := testutil.CollectAndCount(m.TLSCertificateExpiry); n != 1 {
+		t.Fatalf("expected the previous certificate's expiry series to be removed, got %d series", n)
+	}
+
+	// a broken file keeps the previous certificate in service
+	os.WriteFile(cc.CertFile, []byte("garbage"), 0o600)
+	later = later.Add(time.Minute)
+	os.Chtimes(cc.CertFile, later, later)
+	if err := s.Reload(); err == nil {
+		t.Fatal("expected reload error for invalid certificate")
+	}
+	c, _ = s.GetCertificate(&tls.ClientHelloInfo{ServerName: "new.example.com"})
+	if c.Leaf.DNSNames[0] != "new.example.com" {
+		t.Fatalf("expected previous certificate to stay, got %v", c.Leaf.DNSNames)
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	cc := writeSelfSigned(t, dir, "api", time.Now().Add(time.Hour), "api.example.com")
+	s, err := NewCertStore([]config.CertificateConfig{cc}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := ServerTLSConfig(config.TLSConfig{
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	}, s, nil)
+	if cfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 minimum by default, got %x", cfg.MinVersion)
+	}
+	if len(cfg.CipherSuites) != 1 || cfg.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected cipher suites %v", cfg.CipherSuites)
+	}
+	if err := (config.TLSConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}).Validate(); err == nil {
+		t.Error("expected insecure cipher suite to be rejected")
+	}
+	if err := (config.TLSConfig{MinVersion: "1.4"}).Validate(); err == nil {
+		t.Error("expected unknown tls version to be rejected")
+	}
+}
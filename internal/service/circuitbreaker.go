@@ -23,6 +23,8 @@ type CircuitBreaker struct {
 	failureThreshold      int
 	successThreshold      int
 	timeout               time.Duration
+	maxTimeout            time.Duration // when > timeout, open periods double on each consecutive trip
+	trips                 int           // consecutive trips without closing
 	lastFailureTime       time.Time
 	maxConcurrentRequests int
 	currentRequests       int
@@ -46,7 +48,7 @@ func (cb *CircuitBreaker) Call(fn func() error) error {
 	// Check state
 	if cb.state == StateOpen {
 		// Check if timeout has passed
-		if time.Since(cb.lastFailureTime) > cb.timeout {
+		if time.Since(cb.lastFailureTime) > cb.openTimeout() {
 			cb.state = StateHalfOpen
 			cb.successCount = 0
 		} else {
@@ -85,6 +87,56 @@ func (cb *CircuitBreaker) Call(fn func() error) error {
 	return err
 }
 
+// Allow reports whether a request may pass without running it through Call,
+// moving an open breaker to half-open once its open period has elapsed.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == StateOpen && time.Since(cb.lastFailureTime) > cb.openTimeout() {
+		cb.state = StateHalfOpen
+		cb.successCount = 0
+	}
+	return cb.state != StateOpen
+}
+
+// Passable reports whether Allow would let a request pass, without moving an
+// open breaker to half-open.
+func (cb *CircuitBreaker) Passable() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state != StateOpen || time.Since(cb.lastFailureTime) > cb.openTimeout()
+}
+
+// Record records the outcome of a request made outside Call. Outcomes reported
+// while the breaker is open (requests already in flight when it tripped) are ignored.
+func (cb *CircuitBreaker) Record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == StateOpen {
+		return
+	}
+	if err != nil {
+		cb.recordFailure()
+	} else {
+		cb.recordSuccess()
+	}
+}
+
+// openTimeout returns how long the breaker stays open after the current trip.
+func (cb *CircuitBreaker) openTimeout() time.Duration {
+	if cb.maxTimeout <= cb.timeout || cb.trips <= 1 {
+		return cb.timeout
+	}
+	d := cb.timeout
+	for i := 1; i < cb.trips && d < cb.maxTimeout; i++ {
+		d *= 2
+	}
+	if d > cb.maxTimeout {
+		d = cb.maxTimeout
+	}
+	return d
+}
+
 // recordFailure records a failure
 func (cb *CircuitBreaker) recordFailure() {
 	cb.failureCount++
@@ -92,6 +144,9 @@ func (cb *CircuitBreaker) recordFailure() {
 	cb.successCount = 0
 
 	if cb.failureCount >= cb.failureThreshold {
+		if cb.state != StateOpen {
+			cb.trips++
+		}
 		cb.state = StateOpen
 	}
 }
@@ -104,6 +159,7 @@ func (cb *CircuitBreaker) recordSuccess() {
 	if cb.state == StateHalfOpen && cb.successCount >= cb.successThreshold {
 		cb.state = StateClosed
 		cb.successCount = 0
+		cb.trips = 0
 	}
 }
 
@@ -136,11 +192,12 @@ type CircuitMetrics struct {
 
 // CircuitBreakerPool manages multiple circuit breakers
 type CircuitBreakerPool struct {
-	mu        sync.RWMutex
-	breakers  map[string]*CircuitBreaker
-	failureTh int
-	successTh int
-	timeout   time.Duration
+	mu         sync.RWMutex
+	breakers   map[string]*CircuitBreaker
+	failureTh  int
+	successTh  int
+	timeout    time.Duration
+	maxTimeout time.Duration
 }
 
 // NewCircuitBreakerPool creates a new circuit breaker pool
@@ -163,10 +220,24 @@ func (cbp *CircuitBreakerPool) Get(service string) *CircuitBreaker {
 	}
 
 	cb := NewCircuitBreaker(cbp.failureTh, cbp.successTh, cbp.timeout)
+	cb.maxTimeout = cbp.maxTimeout
 	cbp.breakers[service] = cb
 	return cb
 }
 
+// SetMaxTimeout enables exponential backoff: each consecutive trip of a breaker
+// doubles its open period, up to max.
+func (cbp *CircuitBreakerPool) SetMaxTimeout(max time.Duration) {
+	cbp.mu.Lock()
+	defer cbp.mu.Unlock()
+	cbp.maxTimeout = max
+	for _, cb := range cbp.breakers {
+		cb.mu.Lock()
+		cb.maxTimeout = max
+		cb.mu.Unlock()
+	}
+}
+
 // GetAll returns all circuit breakers
 func (cbp *CircuitBreakerPool) GetAll() map[string]*CircuitBreaker {
 	cbp.mu.RLock()
@@ -200,10 +271,18 @@ func (cbp *CircuitBreakerPool) Reset(service string) {
 		cb.state = StateClosed
 		cb.failureCount = 0
 		cb.successCount = 0
+		cb.trips = 0
 		cb.mu.Unlock()
 	}
 }
 
+// Remove discards the circuit breaker of a service
+func (cbp *CircuitBreakerPool) Remove(service string) {
+	cbp.mu.Lock()
+	defer cbp.mu.Unlock()
+	delete(cbp.breakers, service)
+}
+
 // ResetAll resets all circuit breakers
 func (cbp *CircuitBreakerPool) ResetAll() {
 	cbp.mu.RLock()
@@ -218,6 +297,7 @@ func (cbp *CircuitBreakerPool) ResetAll() {
 		cb.state = StateClosed
 		cb.failureCount = 0
 		cb.successCount = 0
+		cb.trips = 0
 		cb.mu.Unlock()
 	}
 }
@@ -214,3 +214,52 @@ func TestCircuitBreaker_MaxConcurrentRequests(t *testing.T) {
 		t.Errorf("expected ErrCircuitBreakerOpen for concurrent limit, got %v", err)
 	}
 }
+
+func TestCircuitBreaker_AllowAndRecord(t *testing.T) {
+	cb := NewCircuitBreaker(2, 1, 50*time.Millisecond)
+
+	cb.Record(errors.New("fail"))
+	if !cb.Allow() {
+		t.Fatal("expected breaker to allow below threshold")
+	}
+	cb.Record(errors.New("fail"))
+	if cb.Allow() {
+		t.Fatal("expected breaker to reject after threshold")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !cb.Allow() || cb.GetState() != StateHalfOpen {
+		t.Fatalf("expected half-open after timeout, got %s", cb.GetState())
+	}
+	cb.Record(nil)
+	if cb.GetState() != StateClosed {
+		t.Errorf("expected Closed after success in HalfOpen, got %s", cb.GetState())
+	}
+}
+
+func TestCircuitBreakerPool_ExponentialBackoff(t *testing.T) {
+	pool := NewCircuitBreakerPool(1, 1, 40*time.Millisecond)
+	pool.SetMaxTimeout(100 * time.Millisecond)
+	cb := pool.Get("host")
+
+	cb.Record(errors.New("fail")) // trip 1: 40ms
+	time.Sleep(50 * time.Millisecond)
+	if !cb.Allow() {
+		t.Fatal("expected half-open after first open period")
+	}
+
+	cb.Record(errors.New("fail")) // trip 2: 80ms
+	time.Sleep(50 * time.Millisecond)
+	if cb.Allow() {
+		t.Fatal("expected second open period to be doubled")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if !cb.Allow() {
+		t.Fatal("expected half-open after doubled open period")
+	}
+
+	cb.Record(errors.New("fail")) // trip 3: capped at 100ms
+	if got := cb.openTimeout(); got != 100*time.Millisecond {
+		t.Fatalf("expected open period capped at 100ms, got %s", got)
+	}
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"api-gateway/internal/config"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Identity is an authenticated caller, usable for authorization and as a rate limit key.
+type Identity struct {
+	Principal string
+	Role      string
+	// Tier is the rate-limit tier of an API key.
+	Tier string
+	// Claims are the verified claims of a JWT.
+	Claims map[string]interface{}
+	// Subject, SANs and Fingerprint describe the client certificate of mTLS identities.
+	Subject     string
+	SANs        []string
+	Fingerprint string // hex SHA-256 of the DER certificate
+}
+
+type identityKey struct{}
+
+// WithIdentity stores the caller's identity in the context.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the caller's identity, if one was established.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// ClientAuth verifies client certificates against CA bundles and revocation
+// lists, and maps verified certificates to identities. CA and CRL files are
+// re-read when they change on disk.
+type ClientAuth struct {
+	cfg config.ClientAuthConfig
+
+	mu      sync.RWMutex
+	pool    *x509.CertPool
+	crls    []*x509.RevocationList
+	modTime time.Time
+	size    int64
+}
+
+// NewClientAuth loads the configured CA bundles and CRLs.
+func NewClientAuth(cfg config.ClientAuthConfig) (*ClientAuth, error) {
+	a := &ClientAuth{cfg: cfg.WithDefaults()}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Headers returns the names of the headers forwarding certificate details upstream.
+func (a *ClientAuth) Headers() config.ClientCertHeaders {
+	return a.cfg.Headers
+}
+
+// Reload re-reads the CA and CRL files if any of them changed. On failure the
+// previously loaded files stay in use.
+func (a *ClientAuth) Reload() error {
+	modTime, size, err := filesState(a.files()...)
+	if err != nil {
+		return err
+	}
+	a.mu.RLock()
+	unchanged := modTime.Equal(a.modTime) && size == a.size
+	a.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	if err := a.load(); err != nil {
+		return err
+	}
+	log.Info().Int("crls", len(a.cfg.CRLFiles)).Msg("client ca bundles reloaded")
+	return nil
+}
+
+// Watch checks the CA and CRL files every interval until ctx is cancelled.
+func (a *ClientAuth) Watch(ctx context.Context, interval time.Duration) {
+	watchFiles(ctx, interval, "client ca", a.Reload)
+}
+
+func (a *ClientAuth) files() []string {
+	return append(append([]string(nil), a.cfg.CAFiles...), a.cfg.CRLFiles...)
+}
+
+func (a *ClientAuth) load() error {
+	modTime, size, err := filesState(a.files()...)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	for _, f := range a.cfg.CAFiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("read client ca: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("client ca %s: no certificates found", f)
+		}
+	}
+	var crls []*x509.RevocationList
+	for _, f := range a.cfg.CRLFiles {
+		list, err := loadCRLs(f)
+		if err != nil {
+			return err
+		}
+		crls = append(crls, list...)
+	}
+	a.mu.Lock()
+	a.pool, a.crls, a.modTime, a.size = pool, crls, modTime, size
+	a.mu.Unlock()
+	return nil
+}
+
+// loadCRLs parses a PEM file of "X509 CRL" blocks or a single DER CRL.
+func loadCRLs(file string) ([]*x509.RevocationList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read crl: %w", err)
+	}
+	var ders [][]byte
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = [][]byte{data}
+	}
+	out := make([]*x509.RevocationList, 0, len(ders))
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse crl %s: %w", file, err)
+		}
+		out = append(out, crl)
+	}
+	return out, nil
+}
+
+// configure enables client certificate verification on tc.
+func (a *ClientAuth) configure(tc *tls.Config) {
+	tc.ClientAuth = tls.RequireAndVerifyClientCert
+	if a.cfg.Mode == config.ClientAuthOptional {
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	tc.VerifyConnection = a.verifyConnection
+	// hand out the current CA pool per handshake so reloads take effect
+	tc.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := tc.Clone()
+		a.mu.RLock()
+		c.ClientCAs = a.pool
+		a.mu.RUnlock()
+		return c, nil
+	}
+}
+
+// verifyConnection rejects client certificates revoked by a CRL of their issuer.
+// It runs after chain verification, including on resumed sessions.
+func (a *ClientAuth) verifyConnection(cs tls.ConnectionState) error {
+	a.mu.RLock()
+	crls := a.crls
+	a.mu.RUnlock()
+	if len(crls) == 0 {
+		return nil
+	}
+	for _, chain := range cs.VerifiedChains {
+		for i := 0; i+1 < len(chain); i++ {
+			if revoked(chain[i], chain[i+1], crls) {
+				return fmt.Errorf("client certificate %s (serial %s) is revoked", chain[i].Subject, chain[i].SerialNumber)
+			}
+		}
+	}
+	return nil
+}
+
+// revoked reports whether a CRL signed by issuer lists cert.
+func revoked(cert, issuer *x509.Certificate, crls []*x509.RevocationList) bool {
+	for _, crl := range crls {
+		if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) || crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		for _, e := range crl.RevokedCertificateEntries {
+			if e.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Identify maps a verified client certificate to an identity using the first
+// matching rule. Without a match the principal is the subject CN (or first SAN)
+// and the role is the configured default.
+func (a *ClientAuth) Identify(cert *x509.Certificate) Identity {
+	sum := sha256.Sum256(cert.Raw)
+	id := Identity{Subject: cert.Subject.String(), Fingerprint: hex.EncodeToString(sum[:])}
+	for _, f := range []string{config.CertFieldDNS, config.CertFieldURI, config.CertFieldEmail} {
+		id.SANs = append(id.SANs, certValues(cert, f)...)
+	}
+	for _, rule := range a.cfg.Identities {
+		for _, v := range certValues(cert, rule.Field) {
+			if ok, _ := path.Match(rule.Pattern, v); !ok {
+				continue
+			}
+			id.Principal, id.Role = v, rule.Role
+			if rule.Principal != "" && rule.Principal != rule.Field {
+				if vs := certValues(cert, rule.Principal); len(vs) > 0 {
+					id.Principal = vs[0]
+				}
+			}
+			return id
+		}
+	}
+	id.Principal, id.Role = cert.Subject.CommonName, a.cfg.DefaultRole
+	if id.Principal == "" && len(id.SANs) > 0 {
+		id.Principal = id.SANs[0]
+	}
+	return id
+}
+
+// certValues returns the values of a certificate field (config.CertField*).
+func certValues(cert *x509.Certificate, field string) []string {
+	switch field {
+	case config.CertFieldCN:
+		if cert.Subject.CommonName != "" {
+			return []string{cert.Subject.CommonName}
+		}
+	case config.CertFieldO:
+		return cert.Subject.Organization
+	case config.CertFieldOU:
+		return cert.Subject.OrganizationalUnit
+	case config.CertFieldDNS:
+		return cert.DNSNames
+	case config.CertFieldURI:
+		out := make([]string, len(cert.URIs))
+		for i, u := range cert.URIs {
+			out[i] = u.String()
+		}
+		return out
+	case config.CertFieldEmail:
+		return cert.EmailAddresses
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+)
+
+// testCA is a throwaway certificate authority for client certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	file := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue returns a client certificate signed by the CA.
+func (ca *testCA) issue(t *testing.T, serial int64, cn string, uris ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"platform"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, s := range uris {
+		u, _ := url.Parse(s)
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCRL writes a PEM CRL revoking the given serials.
+func (ca *testCA) writeCRL(t *testing.T, dir string, serials ...int64) string {
+	t.Helper()
+	tmpl := &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: time.Now(), NextUpdate: time.Now().Add(time.Hour)}
+	for _, s := range serials {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: big.NewInt(s), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "ca.crl")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestClientAuthHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	crl := ca.writeCRL(t, dir, 3)
+	serverCert := writeSelfSigned(t, dir, "server", time.Now().Add(time.Hour), "localhost")
+	certs, err := NewCertStore([]config.CertificateConfig{serverCert}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auth, err := NewClientAuth(config.ClientAuthConfig{
+		CAFiles:  []string{ca.file},
+		CRLFiles: []string{crl},
+		Identities: []config.CertIdentityRule{
+			{Field: config.CertFieldURI, Pattern: "spiffe://prod/billing/*", Role: "operator"},
+		},
+		DefaultRole: "user",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got Identity
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = auth.Identify(r.TLS.VerifiedChains[0][0])
+	}))
+	srv.TLS = ServerTLSConfig(config.TLSConfig{}, certs, auth)
+	srv.StartTLS()
+	defer srv.Close()
+
+	call := func(certs ...tls.Certificate) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		}}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := call(ca.issue(t, 2, "billing-api", "spiffe://prod/billing/api")); err != nil {
+		t.Fatalf("expected valid certificate to be accepted: %v", err)
+	}
+	if got.Principal != "spiffe://prod/billing/api" || got.Role != "operator" {
+		t.Errorf("unexpected identity %+v", got)
+	}
+	if got.Subject != "CN=billing-api,O=platform" || len(got.Fingerprint) != 64 {
+		t.Errorf("unexpected certificate details %+v", got)
+	}
+
+	if err := call(ca.issue(t, 4, "reports")); err != nil {
+		t.Fatalf("expected valid certificate to be accepted: %v", err)
+	}
+	if got.Principal != "reports" || got.Role != "user" {
+		t.Errorf("expected CN and default role, got %+v", got)
+	}
+
+	if err := call(ca.issue(t, 3, "revoked")); err == nil {
+		t.Error("expected revoked certificate to be rejected")
+	}
+	if err := call(); err == nil {
+		t.Error("expected handshake without a client certificate to fail")
+	}
+	other := newTestCA(t, t.TempDir())
+	if err := call(other.issue(t, 5, "stranger")); err == nil {
+		t.Error("expected certificate from an unknown CA to be rejected")
+	}
+}
+
+func TestClientAuthNegotiatesHTTP2(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	serverCert := writeSelfSigned(t, dir, "server", time.Now().Add(time.Hour), "localhost")
+	certs, err := NewCertStore([]config.CertificateConfig{serverCert}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	auth, err := NewClientAuth(config.ClientAuthConfig{CAFiles: []string{ca.file}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.NotFoundHandler(), TLSConfig: ServerTLSConfig(config.TLSConfig{}, certs, auth)}
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{ca.issue(t, 2, "client")},
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected handshake error: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.ConnectionState().NegotiatedProtocol; got != "h2" {
+		t.Fatalf("expected h2 with mutual TLS, got %q", got)
+	}
+}
+
+func TestClientAuthReloadCRL(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	crl := ca.writeCRL(t, dir)
+	auth, err := NewClientAuth(config.ClientAuthConfig{CAFiles: []string{ca.file}, CRLFiles: []string{crl}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	leaf := ca.issue(t, 7, "svc").Leaf
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca.cert}}}
+	if err := auth.verifyConnection(state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ca.writeCRL(t, dir, 7)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(crl, later, later)
+	if err := auth.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := auth.verifyConnection(state); err == nil {
+		t.Fatal("expected certificate revoked by the reloaded CRL to be rejected")
+	}
+}
@@ -0,0 +1,516 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Target is a single upstream endpoint within a cluster.
+type Target struct {
+	URL      *url.URL
+	weight   atomic.Int64
+	inflight int64
+	// removed is set once the target leaves its cluster; late releases of
+	// requests still in flight then leave its metric series deleted.
+	removed atomic.Bool
+
+	// unhealthy is set by the active health checker; the zero value is healthy.
+	unhealthy atomic.Bool
+	// breaker tracks passive outlier detection; nil when disabled.
+	breaker *CircuitBreaker
+
+	mu        sync.Mutex
+	successes int // consecutive passed health checks
+	failures  int // consecutive failed health checks
+	lastCheck time.Time
+	lastError string
+}
+
+// Weight returns the target's load-balancing weight.
+func (t *Target) Weight() int {
+	return int(t.weight.Load())
+}
+
+// Healthy reports whether the target passes active health checks.
+func (t *Target) Healthy() bool {
+	return !t.unhealthy.Load()
+}
+
+// Ejected reports whether outlier detection currently holds the target out of rotation.
+func (t *Target) Ejected() bool {
+	return t.breaker != nil && t.breaker.GetState() == StateOpen
+}
+
+// TargetStatus is a point-in-time view of a target for status endpoints.
+type TargetStatus struct {
+	URL       string `json:"url"`
+	Weight    int    `json:"weight"`
+	Healthy   bool   `json:"healthy"`
+	Ejected   bool   `json:"ejected"`
+	Inflight  int64  `json:"inflight"`
+	LastCheck int64  `json:"last_check,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Status returns the target's current state.
+func (t *Target) Status() TargetStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := TargetStatus{
+		URL:       t.URL.String(),
+		Weight:    t.Weight(),
+		Healthy:   t.Healthy(),
+		Ejected:   t.Ejected(),
+		Inflight:  t.Inflight(),
+		LastError: t.lastError,
+	}
+	if !t.lastCheck.IsZero() {
+		st.LastCheck = t.lastCheck.Unix()
+	}
+	return st
+}
+
+// Inflight returns the number of requests currently outstanding to the target.
+func (t *Target) Inflight() int64 {
+	return atomic.LoadInt64(&t.inflight)
+}
+
+// Cluster is a named pool of upstream targets. It implements http.RoundTripper:
+// every round trip picks a target with the cluster's balancer and forwards to it.
+type Cluster struct {
+	Name      string
+	lb        Balancer
+	transport http.RoundTripper
+	metrics   *metrics.Registry
+	trace     *httptrace.ClientTrace // records connection reuse; nil without metrics
+	hc        *config.HealthCheckConfig
+
+	// outliers holds one breaker per target host for passive outlier detection.
+	outliers       *CircuitBreakerPool
+	maxEjectionPct int
+	budget         *retryBudget
+	discovery      *config.DiscoveryConfig
+
+	mu      sync.RWMutex
+	targets []*Target
+}
+
+// NewCluster builds a cluster from configuration. m may be nil.
+func NewCluster(cfg config.ClusterConfig, m *metrics.Registry) (*Cluster, error) {
+	lb, err := NewBalancer(cfg.LoadBalancer, cfg.HashOn)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", cfg.Name, err)
+	}
+	transport, err := newTransport(cfg, m)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{Name: cfg.Name, lb: lb, transport: transport, metrics: m}
+	if m != nil {
+		c.trace = &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+			m.UpstreamConnectionsUsed.WithLabelValues(cfg.Name, strconv.FormatBool(info.Reused)).Inc()
+		}}
+	}
+	if cfg.RetryBudget != nil {
+		c.budget = newRetryBudget(*cfg.RetryBudget)
+	} else {
+		c.budget = newRetryBudget(config.RetryBudgetConfig{})
+	}
+	if cfg.HealthCheck != nil {
+		hc := cfg.HealthCheck.WithDefaults()
+		c.hc = &hc
+	}
+	if cfg.OutlierDetection != nil {
+		od := cfg.OutlierDetection.WithDefaults()
+		c.outliers = NewCircuitBreakerPool(od.ConsecutiveErrors, 1, time.Duration(od.BaseEjectionMs)*time.Millisecond)
+		c.outliers.SetMaxTimeout(time.Duration(od.MaxEjectionMs) * time.Millisecond)
+		c.maxEjectionPct = od.MaxEjectionPercent
+	}
+	if cfg.Discovery != nil {
+		d := cfg.Discovery.WithDefaults()
+		c.discovery = &d
+	}
+	for _, tc := range cfg.TargetList() {
+		t, err := c.newTarget(tc)
+		if err != nil {
+			return nil, err
+		}
+		c.targets = append(c.targets, t)
+	}
+	if m != nil {
+		m.UpstreamTargets.WithLabelValues(c.Name).Set(float64(len(c.targets)))
+	}
+	return c, nil
+}
+
+func (c *Cluster) newTarget(tc config.TargetConfig) (*Target, error) {
+	u, err := url.Parse(tc.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("cluster %s: invalid target url %q", c.Name, tc.URL)
+	}
+	w := tc.Weight
+	if w <= 0 {
+		w = 1
+	}
+	t := &Target{URL: u}
+	t.weight.Store(int64(w))
+	if c.outliers != nil {
+		t.breaker = c.outliers.Get(u.Host)
+	}
+	return t, nil
+}
+
+// SetTargets replaces the cluster's targets. Targets are matched by URL: those
+// kept keep their health, ejection and in-flight state and take the new weight
+// in place; requests in flight to removed targets run to completion. It reports
+// how many targets were added and removed.
+func (c *Cluster) SetTargets(tcs []config.TargetConfig) (added, removed int, err error) {
+	next := make([]*Target, 0, len(tcs))
+	seen := make(map[string]bool, len(tcs))
+	c.mu.RLock()
+	current := c.targets
+	c.mu.RUnlock()
+	existing := make(map[string]*Target, len(current))
+	for _, t := range current {
+		existing[t.URL.String()] = t
+	}
+	for _, tc := range tcs {
+		t, err := c.newTarget(tc)
+		if err != nil {
+			return 0, 0, err
+		}
+		key := t.URL.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if old, ok := existing[key]; ok {
+			old.weight.Store(t.weight.Load())
+			t = old
+		} else {
+			added++
+		}
+		next = append(next, t)
+	}
+	hosts := make(map[string]bool, len(next))
+	for _, t := range next {
+		hosts[t.URL.Host] = true
+	}
+	for key, t := range existing {
+		if seen[key] {
+			continue
+		}
+		removed++
+		t.removed.Store(true)
+		if !hosts[t.URL.Host] {
+			c.forget(t.URL.Host)
+		}
+	}
+	c.mu.Lock()
+	c.targets = next
+	c.mu.Unlock()
+	if c.metrics != nil {
+		c.metrics.UpstreamTargets.WithLabelValues(c.Name).Set(float64(len(next)))
+	}
+	return added, removed, nil
+}
+
+// forget drops the outlier detector and metric series of a host that no target
+// of the cluster uses any more.
+func (c *Cluster) forget(host string) {
+	if c.outliers != nil {
+		c.outliers.Remove(host)
+	}
+	if c.metrics == nil {
+		return
+	}
+	labels := prometheus.Labels{"cluster": c.Name, "target": host}
+	c.metrics.UpstreamHealthy.Delete(labels)
+	c.metrics.UpstreamInflight.Delete(labels)
+	c.metrics.UpstreamEjections.Delete(labels)
+	c.metrics.UpstreamRequests.DeletePartialMatch(labels)
+}
+
+// Targets returns a snapshot of all targets in the cluster.
+func (c *Cluster) Targets() []*Target {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]*Target(nil), c.targets...)
+}
+
+// Status returns the state of every target in the cluster.
+func (c *Cluster) Status() []TargetStatus {
+	targets := c.Targets()
+	out := make([]TargetStatus, len(targets))
+	for i, t := range targets {
+		out[i] = t.Status()
+	}
+	return out
+}
+
+// Ready reports whether at least one target can receive traffic. It leaves
+// circuit breakers as they are, so probes never take a half-open trial request.
+func (c *Cluster) Ready() bool {
+	return len(c.eligible((*CircuitBreaker).Passable)) > 0
+}
+
+// available returns the targets eligible to receive traffic: healthy and not
+// ejected, except that no more than maxEjectionPct of the cluster is held out.
+func (c *Cluster) available() []*Target {
+	return c.eligible((*CircuitBreaker).Allow)
+}
+
+// eligible returns the healthy targets whose breaker passes, topped up with
+// ejected targets beyond the maxEjectionPct limit.
+func (c *Cluster) eligible(passes func(*CircuitBreaker) bool) []*Target {
+	c.mu.RLock()
+	targets := c.targets
+	c.mu.RUnlock()
+	out := make([]*Target, 0, len(targets))
+	var ejected []*Target
+	for _, t := range targets {
+		if !t.Healthy() {
+			continue
+		}
+		if t.breaker != nil && !passes(t.breaker) {
+			ejected = append(ejected, t)
+			continue
+		}
+		out = append(out, t)
+	}
+	if limit := c.maxEjections(len(targets)); len(ejected) > limit {
+		out = append(out, ejected[limit:]...)
+	}
+	return out
+}
+
+// maxEjections returns how many of n targets outlier detection may eject at once.
+func (c *Cluster) maxEjections(n int) int {
+	limit := n * c.maxEjectionPct / 100
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
+}
+
+// recordOutcome feeds an upstream result into the target's outlier detector.
+// 5xx responses, connection errors and timeouts count as failures; requests
+// cancelled by the client do not count at all.
+func (c *Cluster) recordOutcome(t *Target, resp *http.Response, err error) {
+	if t.breaker == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil && resp.StatusCode >= 500 {
+		err = fmt.Errorf("upstream status %d", resp.StatusCode)
+	}
+	wasOpen := t.breaker.GetState() == StateOpen
+	t.breaker.Record(err)
+	if !wasOpen && t.breaker.GetState() == StateOpen {
+		log.Warn().Str("cluster", c.Name).Str("target", t.URL.Host).Err(err).Msg("upstream target ejected")
+		if c.metrics != nil {
+			c.metrics.UpstreamEjections.WithLabelValues(c.Name, t.URL.Host).Inc()
+		}
+	}
+}
+
+// Pick selects a target for the request.
+func (c *Cluster) Pick(r *http.Request) (*Target, error) {
+	return c.pick(r, nil)
+}
+
+// pick selects a target, avoiding those in exclude unless no other target is available.
+func (c *Cluster) pick(r *http.Request, exclude map[*Target]bool) (*Target, error) {
+	targets := c.available()
+	if len(targets) == 0 {
+		return nil, ErrNoHealthyUpstream
+	}
+	if len(exclude) > 0 {
+		fresh := make([]*Target, 0, len(targets))
+		for _, t := range targets {
+			if !exclude[t] {
+				fresh = append(fresh, t)
+			}
+		}
+		if len(fresh) > 0 {
+			targets = fresh
+		}
+	}
+	return c.lb.Pick(r, targets), nil
+}
+
+// RoundTrip forwards req to a target picked by the balancer, retrying per the
+// matched route's retry policy.
+func (c *Cluster) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&c.budget.active, 1)
+	defer atomic.AddInt64(&c.budget.active, -1)
+	if p := retryPolicy(req); p != nil {
+		return c.roundTripWithRetries(req, p)
+	}
+	return c.roundTripOnce(req)
+}
+
+func (c *Cluster) roundTripOnce(req *http.Request) (*http.Response, error) {
+	t, err := c.Pick(req)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(t, req)
+}
+
+// send forwards req to target t. The target's in-flight counter stays raised
+// until the response body is closed.
+func (c *Cluster) send(t *Target, req *http.Request) (*http.Response, error) {
+	out := c.outbound(t, req)
+	if c.trace != nil {
+		out = out.WithContext(httptrace.WithClientTrace(out.Context(), c.trace))
+	}
+	out, ht := withHeaderTimeout(out)
+
+	release := c.acquire(t)
+	resp, err := c.transport.RoundTrip(out)
+	if !ht.headersReceived() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		resp, err = nil, ErrUpstreamHeaderTimeout
+	}
+	c.recordOutcome(t, resp, err)
+	if err != nil {
+		ht.done()
+		release("error")
+		return nil, err
+	}
+	body := &releaseOnClose{ReadCloser: resp.Body, release: func() {
+		ht.done()
+		release(strconv.Itoa(resp.StatusCode))
+	}}
+	resp.Body = body
+	if rw, ok := body.ReadCloser.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		// upgraded connections must stay writable for the reverse proxy
+		resp.Body = &releaseOnCloseRW{releaseOnClose: body, w: rw}
+	}
+	return resp, nil
+}
+
+// outbound returns a shallow copy of req addressed to target t.
+func (c *Cluster) outbound(t *Target, req *http.Request) *http.Request {
+	out := new(http.Request)
+	*out = *req
+	u := *req.URL
+	u.Scheme = t.URL.Scheme
+	u.Host = t.URL.Host
+	u.Path, u.RawPath = joinURLPath(t.URL, req.URL)
+	out.URL = &u
+	if dl, ok := req.Context().Deadline(); ok {
+		out.Header = req.Header.Clone()
+		out.Header.Set(RequestTimeoutHeader, strconv.FormatInt(time.Until(dl).Milliseconds(), 10))
+	}
+	return out
+}
+
+// Forward sends req once to a picked target over the cluster's transport. Unlike
+// RoundTrip it never retries, and its outcome feeds neither outlier detection
+// nor the retry budget, so shadow traffic cannot eject targets or spend retries.
+func (c *Cluster) Forward(req *http.Request) (*http.Response, error) {
+	t, err := c.Pick(req)
+	if err != nil {
+		return nil, err
+	}
+	return c.transport.RoundTrip(c.outbound(t, req))
+}
+
+// acquire raises the target's in-flight counter and returns a function that lowers it once.
+func (c *Cluster) acquire(t *Target) func(code string) {
+	atomic.AddInt64(&t.inflight, 1)
+	var inflight prometheus.Gauge
+	if c.metrics != nil {
+		inflight = c.metrics.UpstreamInflight.WithLabelValues(c.Name, t.URL.Host)
+		inflight.Inc()
+	}
+	var once sync.Once
+	return func(code string) {
+		once.Do(func() {
+			atomic.AddInt64(&t.inflight, -1)
+			if inflight != nil {
+				inflight.Dec()
+				if !t.removed.Load() {
+					c.metrics.UpstreamRequests.WithLabelValues(c.Name, t.URL.Host, code).Inc()
+				}
+			}
+		})
+	}
+}
+
+// releaseOnClose runs release when the body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// releaseOnCloseRW is releaseOnClose over the read-write body of a 101 response.
+type releaseOnCloseRW struct {
+	*releaseOnClose
+	w io.Writer
+}
+
+func (b *releaseOnCloseRW) Write(p []byte) (int, error) {
+	return b.w.Write(p)
+}
+
+// joinURLPath joins the target base path and the request path the same way
+// httputil.NewSingleHostReverseProxy does.
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// Upstream errors
+var (
+	ErrNoHealthyUpstream = NewError("no_healthy_upstream", "no healthy upstream target available")
+)
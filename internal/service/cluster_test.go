@@ -0,0 +1,138 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+)
+
+func TestClusterRoundTripBalancesAndTracksInflight(t *testing.T) {
+	release := make(chan struct{})
+	hits := map[string]int{}
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[name]++
+			if r.URL.Path != "/base/users" {
+				t.Errorf("unexpected upstream path %s", r.URL.Path)
+			}
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-release
+		}))
+	}
+	a, b := newBackend("a"), newBackend("b")
+	defer a.Close()
+	defer b.Close()
+
+	c, err := NewCluster(config.ClusterConfig{
+		Name:    "users",
+		Targets: []config.TargetConfig{{URL: a.URL + "/base"}, {URL: b.URL + "/base"}},
+	}, metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var resps []*http.Response
+	for i := 0; i < 2; i++ {
+		resp, err := c.RoundTrip(httptest.NewRequest("GET", "http://gateway/users", nil))
+		if err != nil {
+			t.Fatalf("round trip: %v", err)
+		}
+		resps = append(resps, resp)
+	}
+	for _, tg := range c.Targets() {
+		if tg.Inflight() != 1 {
+			t.Fatalf("%s: expected 1 in flight, got %d", tg.URL.Host, tg.Inflight())
+		}
+	}
+
+	close(release)
+	for _, resp := range resps {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	for _, tg := range c.Targets() {
+		if tg.Inflight() != 0 {
+			t.Fatalf("%s: expected 0 in flight after close, got %d", tg.URL.Host, tg.Inflight())
+		}
+	}
+	if hits["a"] != 1 || hits["b"] != 1 {
+		t.Fatalf("expected one hit per target, got %v", hits)
+	}
+}
+
+func TestClusterOutlierDetectionEjectsFailingTarget(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+	worse := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer worse.Close()
+
+	c, err := NewCluster(config.ClusterConfig{
+		Name:             "api",
+		Targets:          []config.TargetConfig{{URL: bad.URL}, {URL: good.URL}, {URL: worse.URL}},
+		OutlierDetection: &config.OutlierDetectionConfig{ConsecutiveErrors: 2, MaxEjectionPercent: 34},
+	}, metrics.NewRegistry())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 9; i++ {
+		resp, err := c.RoundTrip(httptest.NewRequest("GET", "http://gateway/", nil))
+		if err != nil {
+			t.Fatalf("round trip: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	targets := c.Targets()
+	if !targets[0].Ejected() {
+		t.Fatal("expected failing target to be ejected")
+	}
+	if targets[1].Ejected() {
+		t.Fatal("healthy target must not be ejected")
+	}
+	// only one of three targets (34%) may be ejected at once
+	if avail := c.available(); len(avail) != 2 {
+		t.Fatalf("expected 2 available targets under the ejection cap, got %d", len(avail))
+	}
+}
+
+func TestClusterReadyLeavesBreakersOpen(t *testing.T) {
+	c, err := NewCluster(config.ClusterConfig{
+		Name:             "api",
+		URL:              "http://a:80",
+		OutlierDetection: &config.OutlierDetectionConfig{ConsecutiveErrors: 1, BaseEjectionMs: 10},
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	target := c.Targets()[0]
+	c.recordOutcome(target, nil, io.ErrUnexpectedEOF)
+	if target.breaker.GetState() != StateOpen {
+		t.Fatalf("expected the target's breaker to open, got %s", target.breaker.GetState())
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if !c.Ready() {
+		t.Fatal("expected a breaker past its open period to count as ready")
+	}
+	if got := target.breaker.GetState(); got != StateOpen {
+		t.Fatalf("expected readiness to leave the breaker open, got %s", got)
+	}
+	if len(c.available()) != 1 || target.breaker.GetState() != StateHalfOpen {
+		t.Fatalf("expected picking a target to take the half-open trial, got %s", target.breaker.GetState())
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/internal/config"
+
+	"github.com/rs/zerolog/log"
+	"go.yaml.in/yaml/v2"
+)
+
+// Resolver performs the DNS lookups of DNS discovery. *net.Resolver implements it;
+// tests inject a fake.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// ErrNoTargetsDiscovered is returned when a discovery source yields no targets.
+// The cluster keeps its previous targets rather than dropping all traffic.
+var ErrNoTargetsDiscovered = errors.New("discovery returned no targets")
+
+// StartDiscovery refreshes the cluster's targets from its discovery source until
+// ctx is cancelled. The first refresh runs before it returns. r resolves DNS
+// sources; nil uses net.DefaultResolver. It is a no-op without discovery.
+func (c *Cluster) StartDiscovery(ctx context.Context, r Resolver) {
+	if c.discovery == nil {
+		return
+	}
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	if err := c.Discover(ctx, r); err != nil {
+		log.Error().Err(err).Str("cluster", c.Name).Msg("service discovery failed")
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(c.discovery.IntervalMs) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := c.Discover(ctx, r); err != nil {
+				log.Error().Err(err).Str("cluster", c.Name).Msg("service discovery failed")
+			}
+		}
+	}()
+}
+
+// Discover runs one discovery round and applies the result. On failure the
+// cluster keeps its current targets.
+func (c *Cluster) Discover(ctx context.Context, r Resolver) error {
+	tcs, err := discoverTargets(ctx, *c.discovery, r)
+	if err == nil && len(tcs) == 0 {
+		err = ErrNoTargetsDiscovered
+	}
+	var added, removed int
+	if err == nil {
+		added, removed, err = c.SetTargets(tcs)
+	}
+	if c.metrics != nil {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		c.metrics.DiscoveryRefreshes.WithLabelValues(c.Name, result).Inc()
+	}
+	if err != nil {
+		return err
+	}
+	if added > 0 || removed > 0 {
+		log.Info().Str("cluster", c.Name).Int("added", added).Int("removed", removed).
+			Int("targets", len(tcs)).Msg("upstream targets updated")
+	}
+	return nil
+}
+
+// discoverTargets reads the current targets from a discovery source.
+func discoverTargets(ctx context.Context, d config.DiscoveryConfig, r Resolver) ([]config.TargetConfig, error) {
+	switch d.Type {
+	case config.DiscoveryFile:
+		return readEndpointsFile(d.Path)
+	case config.DiscoveryDNS:
+		if d.RecordType == config.RecordSRV {
+			return lookupSRVTargets(ctx, d, r)
+		}
+		return lookupHostTargets(ctx, d, r)
+	}
+	return nil, fmt.Errorf("unknown discovery type %q", d.Type)
+}
+
+// endpointsFile is the format of file discovery sources.
+type endpointsFile struct {
+	Targets []config.TargetConfig `json:"targets" yaml:"targets"`
+}
+
+func readEndpointsFile(path string) ([]config.TargetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read endpoints file: %w", err)
+	}
+	var f endpointsFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &f)
+	default:
+		err = json.Unmarshal(data, &f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoints file: %w", err)
+	}
+	return f.Targets, nil
+}
+
+func lookupHostTargets(ctx context.Context, d config.DiscoveryConfig, r Resolver) ([]config.TargetConfig, error) {
+	addrs, err := r.LookupHost(ctx, d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %s: %w", d.Name, err)
+	}
+	sort.Strings(addrs)
+	out := make([]config.TargetConfig, len(addrs))
+	for i, a := range addrs {
+		out[i] = config.TargetConfig{URL: d.Scheme + "://" + net.JoinHostPort(a, strconv.Itoa(d.Port))}
+	}
+	return out, nil
+}
+
+// lookupSRVTargets returns the records of the lowest priority, weighted by their SRV weight.
+func lookupSRVTargets(ctx context.Context, d config.DiscoveryConfig, r Resolver) ([]config.TargetConfig, error) {
+	_, srvs, err := r.LookupSRV(ctx, "", "", d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup srv %s: %w", d.Name, err)
+	}
+	if len(srvs) == 0 {
+		return nil, nil
+	}
+	best := srvs[0].Priority
+	for _, s := range srvs {
+		if s.Priority < best {
+			best = s.Priority
+		}
+	}
+	var out []config.TargetConfig
+	for _, s := range srvs {
+		if s.Priority != best {
+			continue
+		}
+		host := strings.TrimSuffix(s.Target, ".")
+		out = append(out, config.TargetConfig{
+			URL:    d.Scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(s.Port))),
+			Weight: int(s.Weight),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	return out, nil
+}
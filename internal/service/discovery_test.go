@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type fakeResolver struct {
+	hosts map[string][]string
+	srvs  map[string][]*net.SRV
+}
+
+func (f *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	addrs, ok := f.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func (f *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	srvs, ok := f.srvs[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, srvs, nil
+}
+
+func targetURLs(c *Cluster) []string {
+	var out []string
+	for _, t := range c.Targets() {
+		out = append(out, t.URL.String())
+	}
+	return out
+}
+
+func TestClusterFileDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "endpoints.yaml")
+	write := func(body string) {
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("targets:\n  - url: http://10.0.0.1:8080\n  - url: http://10.0.0.2:8080\n    weight: 3\n")
+
+	m := metrics.NewRegistry()
+	c, err := NewCluster(config.ClusterConfig{
+		Name:      "api",
+		Discovery: &config.DiscoveryConfig{Type: config.DiscoveryFile, Path: path},
+	}, m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := context.Background()
+	if err := c.Discover(ctx, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := targetURLs(c); len(got) != 2 || c.Targets()[1].Weight() != 3 {
+		t.Fatalf("unexpected targets %v", got)
+	}
+	kept := c.Targets()[0]
+
+	write("targets:\n  - url: http://10.0.0.1:8080\n  - url: http://10.0.0.3:8080\n")
+	if err := c.Discover(ctx, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := targetURLs(c); len(got) != 2 || got[1] != "http://10.0.0.3:8080" {
+		t.Fatalf("unexpected targets %v", got)
+	}
+	if c.Targets()[0] != kept {
+		t.Error("unchanged target should keep its state")
+	}
+
+	write("targets: []\n")
+	if err := c.Discover(ctx, nil); err == nil {
+		t.Fatal("expected an empty endpoints file to be rejected")
+	}
+	if got := targetURLs(c); len(got) != 2 {
+		t.Fatalf("expected previous targets to stay, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.DiscoveryRefreshes.WithLabelValues("api", "error")); got != 1 {
+		t.Errorf("expected 1 failed refresh, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.UpstreamTargets.WithLabelValues("api")); got != 2 {
+		t.Errorf("expected 2 targets, got %v", got)
+	}
+
+	jsonPath := filepath.Join(dir, "endpoints.json")
+	os.WriteFile(jsonPath, []byte(`{"targets": [{"url": "http://10.0.0.9:80"}]}`), 0o600)
+	c.discovery.Path = jsonPath
+	if err := c.Discover(ctx, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := targetURLs(c); len(got) != 1 || got[0] != "http://10.0.0.9:80" {
+		t.Fatalf("unexpected targets %v", got)
+	}
+}
+
+func TestClusterDNSDiscovery(t *testing.T) {
+	r := &fakeResolver{
+		hosts: map[string][]string{"api.default.svc": {"10.1.0.2", "10.1.0.1", "fd00::1"}},
+		srvs: map[string][]*net.SRV{"_http._tcp.api.default.svc": {
+			{Target: "pod-b.api.default.svc.", Port: 9000, Priority: 10, Weight: 1},
+			{Target: "pod-a.api.default.svc.", Port: 9000, Priority: 10, Weight: 3},
+			{Target: "backup.api.default.svc.", Port: 9000, Priority: 20, Weight: 1},
+		}},
+	}
+	ctx := context.Background()
+
+	a, err := NewCluster(config.ClusterConfig{
+		Name:      "a",
+		Discovery: &config.DiscoveryConfig{Type: config.DiscoveryDNS, Name: "api.default.svc", Port: 8080},
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := a.Discover(ctx, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"http://10.1.0.1:8080", "http://10.1.0.2:8080", "http://[fd00::1]:8080"}
+	if got := targetURLs(a); len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	srv, err := NewCluster(config.ClusterConfig{
+		Name: "srv",
+		Discovery: &config.DiscoveryConfig{Type: config.DiscoveryDNS, RecordType: config.RecordSRV,
+			Name: "_http._tcp.api.default.svc", Scheme: "https"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := srv.Discover(ctx, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	targets := srv.Targets()
+	if len(targets) != 2 || targets[0].URL.String() != "https://pod-a.api.default.svc:9000" || targets[0].Weight() != 3 {
+		t.Fatalf("expected the priority 10 records, got %v", targetURLs(srv))
+	}
+
+	delete(r.hosts, "api.default.svc")
+	if err := a.Discover(ctx, r); err == nil {
+		t.Fatal("expected lookup failure")
+	}
+	if len(a.Targets()) != 3 {
+		t.Fatal("expected targets to survive a failed lookup")
+	}
+}
+
+func TestClusterSetTargetsKeepsInflightRequests(t *testing.T) {
+	release := make(chan struct{})
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		io.WriteString(w, "old")
+	}))
+	defer old.Close()
+	next := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "new")
+	}))
+	defer next.Close()
+
+	c, err := NewCluster(config.ClusterConfig{Name: "api", URL: old.URL}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	target := c.Targets()[0]
+	done := make(chan string)
+	go func() {
+		resp, err := c.RoundTrip(httptest.NewRequest("GET", "http://gateway/", nil))
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		done <- string(b)
+	}()
+	for target.Inflight() == 0 {
+		runtime.Gosched()
+	}
+
+	added, removed, err := c.SetTargets([]config.TargetConfig{{URL: next.URL}})
+	if err != nil || added != 1 || removed != 1 {
+		t.Fatalf("expected 1 added and 1 removed, got %d %d %v", added, removed, err)
+	}
+	resp, err := c.RoundTrip(httptest.NewRequest("GET", "http://gateway/", nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "new" {
+		t.Fatalf("expected new requests on the new target, got %q", b)
+	}
+
+	close(release)
+	if got := <-done; got != "old" {
+		t.Fatalf("expected the in-flight request to complete, got %q", got)
+	}
+	if target.Inflight() != 0 {
+		t.Fatalf("expected removed target to drain, got %d in flight", target.Inflight())
+	}
+}
+
+func TestClusterSetTargetsUpdatesWeightAndForgetsRemoved(t *testing.T) {
+	m := metrics.NewRegistry()
+	c, err := NewCluster(config.ClusterConfig{
+		Name:             "api",
+		Targets:          []config.TargetConfig{{URL: "http://a:80"}, {URL: "http://b:80"}},
+		OutlierDetection: &config.OutlierDetectionConfig{ConsecutiveErrors: 1},
+	}, m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, b := c.Targets()[0], c.Targets()[1]
+	c.recordOutcome(a, nil, io.ErrUnexpectedEOF)
+	c.acquire(b)("200")
+	c.metrics.UpstreamHealthy.WithLabelValues("api", "b:80").Set(1)
+
+	added, removed, err := c.SetTargets([]config.TargetConfig{{URL: "http://a:80", Weight: 5}})
+	if err != nil || added != 0 || removed != 1 {
+		t.Fatalf("expected 0 added and 1 removed, got %d %d %v", added, removed, err)
+	}
+	if got := c.Targets(); len(got) != 1 || got[0] != a || a.Weight() != 5 || !a.Ejected() {
+		t.Fatalf("expected a weight change to keep the ejected target with weight 5, got %+v", a.Status())
+	}
+	if _, ok := c.outliers.GetAll()["b:80"]; ok {
+		t.Fatal("expected the removed target's outlier detector to be dropped")
+	}
+	for name, n := range map[string]int{
+		"requests": testutil.CollectAndCount(m.UpstreamRequests),
+		"inflight": testutil.CollectAndCount(m.UpstreamInflight),
+		"healthy":  testutil.CollectAndCount(m.UpstreamHealthy),
+	} {
+		if n != 0 {
+			t.Errorf("expected the removed target's %s series to be deleted, got %d", name, n)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GRPCCode is a gRPC status code (see google.golang.org/grpc/codes).
+type GRPCCode int
+
+// gRPC status codes produced by the gateway.
+const (
+	GRPCUnknown           GRPCCode = 2
+	GRPCInvalidArgument   GRPCCode = 3
+	GRPCDeadlineExceeded  GRPCCode = 4
+	GRPCPermissionDenied  GRPCCode = 7
+	GRPCResourceExhausted GRPCCode = 8
+	GRPCUnimplemented     GRPCCode = 12
+	GRPCInternal          GRPCCode = 13
+	GRPCUnavailable       GRPCCode = 14
+	GRPCUnauthenticated   GRPCCode = 16
+)
+
+// IsGRPCRequest reports whether r is a gRPC call.
+func IsGRPCRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// GRPCCodeForStatus maps the HTTP status of a gateway rejection to a gRPC code.
+func GRPCCodeForStatus(status int) GRPCCode {
+	switch status {
+	case http.StatusBadRequest:
+		return GRPCInvalidArgument
+	case http.StatusUnauthorized:
+		return GRPCUnauthenticated
+	case http.StatusForbidden:
+		return GRPCPermissionDenied
+	case http.StatusNotFound:
+		return GRPCUnimplemented
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return GRPCResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return GRPCUnavailable
+	case http.StatusGatewayTimeout:
+		return GRPCDeadlineExceeded
+	case http.StatusInternalServerError:
+		return GRPCInternal
+	}
+	return GRPCUnknown
+}
+
+// RejectGRPC answers a gRPC request with the gRPC equivalent of an HTTP
+// rejection and reports true; it reports false, writing nothing, for other
+// requests. Middleware calls it before writing its usual error body.
+func RejectGRPC(w http.ResponseWriter, r *http.Request, status int, msg string) bool {
+	if !IsGRPCRequest(r) {
+		return false
+	}
+	ct := "application/grpc"
+	if IsGRPCWebRequest(r) {
+		ct = r.Header.Get("Content-Type")
+	}
+	writeGRPCError(w, ct, GRPCCodeForStatus(status), msg)
+	return true
+}
+
+// WriteGRPCError writes a trailers-only gRPC response: HTTP 200 with no body
+// and grpc-status and grpc-message in the single header block.
+func WriteGRPCError(w http.ResponseWriter, code GRPCCode, msg string) {
+	writeGRPCError(w, "application/grpc", code, msg)
+}
+
+func writeGRPCError(w http.ResponseWriter, contentType string, code GRPCCode, msg string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", contentType)
+	h.Set("Grpc-Status", strconv.Itoa(int(code)))
+	h.Set("Grpc-Message", encodeGRPCMessage(msg))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGRPCMessage percent-encodes a status message as the gRPC HTTP/2 spec requires.
+func encodeGRPCMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRejectGRPC(t *testing.T) {
+	req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	rr := httptest.NewRecorder()
+	if !RejectGRPC(rr, req, http.StatusTooManyRequests, "rate limit 100% used") {
+		t.Fatal("expected gRPC rejection")
+	}
+	if rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+		t.Fatalf("expected trailers-only 200 response, got %d %q", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Grpc-Status"); got != "8" {
+		t.Fatalf("expected RESOURCE_EXHAUSTED (8), got %s", got)
+	}
+	if got := rr.Header().Get("Grpc-Message"); got != "rate limit 100%25 used" {
+		t.Fatalf("expected percent-encoded message, got %q", got)
+	}
+
+	plain := httptest.NewRequest("GET", "/", nil)
+	if RejectGRPC(httptest.NewRecorder(), plain, http.StatusTooManyRequests, "x") {
+		t.Fatal("non-gRPC requests must not be rejected as gRPC")
+	}
+}
+
+func TestGRPCCodeForStatus(t *testing.T) {
+	for status, want := range map[int]GRPCCode{
+		http.StatusTooManyRequests:    GRPCResourceExhausted,
+		http.StatusUnauthorized:       GRPCUnauthenticated,
+		http.StatusForbidden:          GRPCPermissionDenied,
+		http.StatusServiceUnavailable: GRPCUnavailable,
+		http.StatusGatewayTimeout:     GRPCDeadlineExceeded,
+		http.StatusTeapot:             GRPCUnknown,
+	} {
+		if got := GRPCCodeForStatus(status); got != want {
+			t.Errorf("status %d: expected %d, got %d", status, want, got)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"api-gateway/internal/config"
+)
+
+// gRPC-Web content types.
+const (
+	GRPCWebContentType     = "application/grpc-web"
+	GRPCWebTextContentType = "application/grpc-web-text"
+)
+
+// IsGRPCWebRequest reports whether r is a gRPC-Web call.
+func IsGRPCWebRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), GRPCWebContentType)
+}
+
+// IsGRPCWebText reports whether a gRPC-Web content type carries base64 bodies.
+func IsGRPCWebText(contentType string) bool {
+	return strings.HasPrefix(contentType, GRPCWebTextContentType)
+}
+
+// GRPCWeb holds a route's gRPC-Web bridge settings.
+type GRPCWeb struct {
+	anyOrigin bool
+	origins   map[string]bool
+	MaxAgeSec int
+}
+
+// NewGRPCWeb compiles a gRPC-Web config.
+func NewGRPCWeb(cfg config.GRPCWebConfig) *GRPCWeb {
+	g := &GRPCWeb{origins: make(map[string]bool), MaxAgeSec: cfg.MaxAgeSec}
+	if g.MaxAgeSec <= 0 {
+		g.MaxAgeSec = 600
+	}
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			g.anyOrigin = true
+		}
+		g.origins[strings.ToLower(o)] = true
+	}
+	return g
+}
+
+// AllowOrigin reports whether browsers from origin may call the route.
+func (g *GRPCWeb) AllowOrigin(origin string) bool {
+	return g.anyOrigin || g.origins[strings.ToLower(origin)]
+}
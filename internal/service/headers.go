@@ -0,0 +1,123 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"api-gateway/internal/config"
+)
+
+// HeaderOps is a compiled list of header operations.
+type HeaderOps struct {
+	set    map[string]string
+	append map[string]string
+	remove []string
+}
+
+// HeaderRules are the header operations for upstream requests and client responses.
+type HeaderRules struct {
+	Request  HeaderOps
+	Response HeaderOps
+}
+
+// NewHeaderRules compiles header rules, rejecting templates with unknown variables.
+func NewHeaderRules(cfg config.HeaderRulesConfig) (*HeaderRules, error) {
+	req, err := newHeaderOps(cfg.Request)
+	if err != nil {
+		return nil, fmt.Errorf("request headers: %w", err)
+	}
+	resp, err := newHeaderOps(cfg.Response)
+	if err != nil {
+		return nil, fmt.Errorf("response headers: %w", err)
+	}
+	return &HeaderRules{Request: req, Response: resp}, nil
+}
+
+func newHeaderOps(cfg config.HeaderOpsConfig) (HeaderOps, error) {
+	ops := HeaderOps{set: make(map[string]string), append: make(map[string]string)}
+	for k, v := range cfg.Set {
+		if err := validateTemplate(v); err != nil {
+			return ops, fmt.Errorf("%s: %w", k, err)
+		}
+		ops.set[http.CanonicalHeaderKey(k)] = v
+	}
+	for k, v := range cfg.Append {
+		if err := validateTemplate(v); err != nil {
+			return ops, fmt.Errorf("%s: %w", k, err)
+		}
+		ops.append[http.CanonicalHeaderKey(k)] = v
+	}
+	for _, k := range cfg.Remove {
+		ops.remove = append(ops.remove, http.CanonicalHeaderKey(k))
+	}
+	return ops, nil
+}
+
+// Apply runs the operations against h, expanding templates with vars.
+func (o HeaderOps) Apply(h http.Header, vars func(string) string) {
+	for _, k := range o.remove {
+		h.Del(k)
+	}
+	for k, v := range o.set {
+		h.Set(k, os.Expand(v, vars))
+	}
+	for k, v := range o.append {
+		h.Add(k, os.Expand(v, vars))
+	}
+}
+
+// TemplateVars returns the template variable lookup for a request. Subject and role
+// come from the identity established by the auth middlewares, never from request
+// headers, so clients cannot inject them on routes without authentication.
+func TemplateVars(r *http.Request) func(string) string {
+	m, _ := RouteMatchFromContext(r.Context())
+	id, _ := IdentityFromContext(r.Context())
+	return func(name string) string {
+		switch name {
+		case "request_id":
+			return r.Header.Get("X-Request-ID")
+		case "client_ip":
+			return ClientIP(r)
+		case "subject":
+			return id.Principal
+		case "role":
+			return id.Role
+		case "route":
+			if m != nil {
+				return m.Route.Name
+			}
+		case "cluster":
+			if m != nil {
+				return m.Cluster
+			}
+		}
+		if p, ok := strings.CutPrefix(name, "param."); ok && m != nil {
+			return m.Params[p]
+		}
+		if h, ok := strings.CutPrefix(name, "header."); ok {
+			return r.Header.Get(h)
+		}
+		return ""
+	}
+}
+
+// validateTemplate rejects references to unknown template variables.
+func validateTemplate(v string) error {
+	var err error
+	os.Expand(v, func(name string) string {
+		switch name {
+		case "request_id", "client_ip", "subject", "role", "route", "cluster":
+			return ""
+		}
+		if strings.HasPrefix(name, "param.") || strings.HasPrefix(name, "header.") {
+			return ""
+		}
+		if err == nil {
+			err = fmt.Errorf("unknown template variable %q", name)
+		}
+		return ""
+	})
+	return err
+}
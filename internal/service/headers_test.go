@@ -0,0 +1,72 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestHeaderRulesApply(t *testing.T) {
+	rules, err := NewHeaderRules(config.HeaderRulesConfig{
+		Request: config.HeaderOpsConfig{
+			Set:    map[string]string{"x-gateway-user": "${subject}/${role}", "X-Order": "${param.id}"},
+			Append: map[string]string{"X-Trace": "${request_id}@${route}"},
+			Remove: []string{"x-internal"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/orders/7", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("X-Internal", "secret")
+	req.Header.Set("X-Trace", "client")
+	m := &RouteMatch{Route: &Route{Name: "orders"}, Params: map[string]string{"id": "7"}}
+	ctx := WithIdentity(WithRouteMatch(req.Context(), m), Identity{Principal: "alice", Role: "admin"})
+	req = req.WithContext(ctx)
+
+	h := req.Header.Clone()
+	rules.Request.Apply(h, TemplateVars(req))
+
+	if got := h.Get("X-Gateway-User"); got != "alice/admin" {
+		t.Fatalf("expected X-Gateway-User=alice/admin, got %q", got)
+	}
+	if got := h.Get("X-Order"); got != "7" {
+		t.Fatalf("expected X-Order=7, got %q", got)
+	}
+	if got := h.Values("X-Trace"); len(got) != 2 || got[1] != "req-1@orders" {
+		t.Fatalf("unexpected X-Trace values %v", got)
+	}
+	if h.Get("X-Internal") != "" {
+		t.Fatal("expected X-Internal to be removed")
+	}
+}
+
+func TestTemplateVarsIgnoreIdentityHeaders(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-User-ID", "mallory")
+	req.Header.Set("X-User-Role", "admin")
+	vars := TemplateVars(req)
+	if got := vars("subject") + vars("role"); got != "" {
+		t.Fatalf("expected no subject or role without an authenticated identity, got %q", got)
+	}
+}
+
+func TestTemplateVarsClientIP(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+	if got := TemplateVars(req)("client_ip"); got != "203.0.113.9" {
+		t.Fatalf("expected client ip 203.0.113.9, got %q", got)
+	}
+}
+
+func TestNewHeaderRulesUnknownVariable(t *testing.T) {
+	_, err := NewHeaderRules(config.HeaderRulesConfig{
+		Response: config.HeaderOpsConfig{Set: map[string]string{"X-A": "${nope}"}},
+	})
+	if err == nil {
+		t.Fatal("expected error for unknown template variable")
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StartHealthChecks probes every target of the cluster until ctx is cancelled.
+// It is a no-op when the cluster has no health check configured.
+func (c *Cluster) StartHealthChecks(ctx context.Context) {
+	if c.hc == nil {
+		return
+	}
+	client := &http.Client{Transport: c.transport}
+	go func() {
+		ticker := time.NewTicker(time.Duration(c.hc.IntervalMs) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			c.checkAll(ctx, client)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// checkAll probes all targets concurrently and waits for the round to finish.
+func (c *Cluster) checkAll(ctx context.Context, client *http.Client) {
+	var wg sync.WaitGroup
+	for _, t := range c.Targets() {
+		wg.Add(1)
+		go func(t *Target) {
+			defer wg.Done()
+			c.recordCheck(t, c.probe(ctx, client, t))
+		}(t)
+	}
+	wg.Wait()
+}
+
+// probe performs a single health check request against t.
+func (c *Cluster) probe(ctx context.Context, client *http.Client, t *Target) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.hc.TimeoutMs)*time.Millisecond)
+	defer cancel()
+	u := *t.URL
+	u.Path = singleJoiningSlash(t.URL.Path, c.hc.Path)
+	u.RawPath = ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "api-gateway-healthcheck")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < c.hc.ExpectedStatusMin || resp.StatusCode > c.hc.ExpectedStatusMax {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordCheck applies a probe result and flips the target's state once a threshold is crossed.
+func (c *Cluster) recordCheck(t *Target, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastCheck = time.Now()
+	if err != nil {
+		t.lastError = err.Error()
+		t.successes = 0
+		t.failures++
+		if t.failures >= c.hc.UnhealthyThreshold {
+			t.unhealthy.Store(true)
+		}
+	} else {
+		t.lastError = ""
+		t.failures = 0
+		t.successes++
+		if t.successes >= c.hc.HealthyThreshold {
+			t.unhealthy.Store(false)
+		}
+	}
+	if c.metrics != nil && !t.removed.Load() {
+		v := 1.0
+		if !t.Healthy() {
+			v = 0
+		}
+		c.metrics.UpstreamHealthy.WithLabelValues(c.Name, t.URL.Host).Set(v)
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestHealthCheckThresholds(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			t.Errorf("unexpected health check path %s", r.URL.Path)
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer backend.Close()
+
+	c, err := NewCluster(config.ClusterConfig{
+		Name:        "api",
+		URL:         backend.URL,
+		HealthCheck: &config.HealthCheckConfig{Path: "/healthz", HealthyThreshold: 2, UnhealthyThreshold: 2},
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := &http.Client{}
+	ctx := context.Background()
+	target := c.Targets()[0]
+
+	status.Store(http.StatusServiceUnavailable)
+	c.checkAll(ctx, client)
+	if !target.Healthy() {
+		t.Fatal("target should stay healthy below the unhealthy threshold")
+	}
+	c.checkAll(ctx, client)
+	if target.Healthy() || c.Ready() {
+		t.Fatal("target should be unhealthy after 2 failed checks")
+	}
+	if _, err := c.Pick(httptest.NewRequest("GET", "/", nil)); err != ErrNoHealthyUpstream {
+		t.Fatalf("expected ErrNoHealthyUpstream, got %v", err)
+	}
+	if st := target.Status(); st.LastError == "" || st.LastCheck == 0 {
+		t.Fatalf("expected last check details in status, got %+v", st)
+	}
+
+	status.Store(http.StatusOK)
+	c.checkAll(ctx, client)
+	if target.Healthy() {
+		t.Fatal("target should stay unhealthy below the healthy threshold")
+	}
+	c.checkAll(ctx, client)
+	if !target.Healthy() {
+		t.Fatal("target should recover after 2 passed checks")
+	}
+}
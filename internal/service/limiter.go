@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"api-gateway/internal/repository"
 )
@@ -13,15 +14,51 @@ type AlgorithmType string
 const (
 	TokenBucketAlg   AlgorithmType = "tokenbucket"
 	SlidingWindowAlg AlgorithmType = "slidingwindow"
+	// GCRAAlg admits Rate requests per second with bursts of up to Capacity,
+	// keeping a single timestamp per key.
+	GCRAAlg AlgorithmType = "gcra"
+	// SlidingWindowCounterAlg admits Limit requests per WindowMs, estimated from
+	// two fixed-window counters per key.
+	SlidingWindowCounterAlg AlgorithmType = "slidingwindowcounter"
 )
 
 // Policy describes a rate limit policy.
 type Policy struct {
+	// Name identifies the policy in RateLimit headers; empty means "default".
+	Name      string
 	Algorithm AlgorithmType
-	Capacity  int64
-	Rate      float64 // tokens per second for token bucket
-	WindowMs  int64   // window size for sliding window, milliseconds
-	Limit     int64   // limit for sliding window
+	Capacity  int64   // bucket size for token bucket, burst for GCRA
+	Rate      float64 // tokens per second for token bucket and GCRA
+	WindowMs  int64   // window size for sliding window (counter), milliseconds
+	Limit     int64   // limit for sliding window (counter)
+}
+
+// Quota returns the number of requests the policy admits per Window.
+func (p Policy) Quota() int64 {
+	switch p.Algorithm {
+	case SlidingWindowAlg, SlidingWindowCounterAlg:
+		return p.Limit
+	}
+	return p.Capacity
+}
+
+// Window returns the period Quota applies to: the sliding window, or the time to
+// refill the whole capacity for rate-based algorithms.
+func (p Policy) Window() time.Duration {
+	switch p.Algorithm {
+	case SlidingWindowAlg, SlidingWindowCounterAlg:
+		return time.Duration(p.WindowMs) * time.Millisecond
+	}
+	if p.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(p.Capacity) / p.Rate * float64(time.Second))
+}
+
+// Decision is the outcome of evaluating a policy.
+type Decision struct {
+	repository.Result
+	Policy Policy
 }
 
 // Limiter provides rate-limiting evaluation.
@@ -37,26 +74,143 @@ func NewLimiter(s repository.Store) *Limiter {
 // Allow evaluates whether an event identified by key is allowed.
 // It returns allowed and remaining quota (where applicable).
 func (l *Limiter) Allow(ctx context.Context, key string, p Policy) (bool, int64, error) {
+	d, err := l.Evaluate(ctx, key, p)
+	if err != nil {
+		return false, 0, err
+	}
+	return d.Allowed, d.Remaining, nil
+}
+
+// Evaluate takes one request from the quota of key under p. The decision carries
+// the time until the quota resets and, when denied, until a retry can succeed.
+func (l *Limiter) Evaluate(ctx context.Context, key string, p Policy) (Decision, error) {
+	var res repository.Result
+	var err error
 	switch p.Algorithm {
 	case TokenBucketAlg:
 		// tokens requested = 1
-		allowed, remaining, err := l.store.TokenBucket(ctx, "tb:"+key, p.Capacity, p.Rate, 1)
-		if err != nil {
-			return false, 0, err
-		}
-		return allowed, remaining, nil
+		res, err = l.store.TokenBucket(ctx, "tb:"+key, p.Capacity, p.Rate, 1)
 	case SlidingWindowAlg:
-		count, err := l.store.SlidingWindow(ctx, "sw:"+key, p.WindowMs)
-		if err != nil {
-			return false, 0, err
+		res, err = l.store.SlidingWindow(ctx, "sw:"+key, p.WindowMs, p.Limit)
+	case GCRAAlg:
+		res, err = l.store.GCRA(ctx, "gcra:"+key, p.Capacity, p.Rate, 1)
+	case SlidingWindowCounterAlg:
+		res, err = l.store.SlidingWindowCounter(ctx, "swc:"+key, p.WindowMs, p.Limit, 1)
+	default:
+		err = fmt.Errorf("unknown algorithm %s", p.Algorithm)
+	}
+	if err != nil {
+		return Decision{}, err
+	}
+	return Decision{Result: res, Policy: p}, nil
+}
+
+// Limit applies a policy to a key, one dimension of a multi-limit check.
+type Limit struct {
+	Key    string
+	Policy Policy
+	// Cost is the number of tokens or requests taken; zero means one.
+	Cost int64
+}
+
+// EvaluateAll takes each limit's cost from it in a single atomic store call.
+// The request is allowed only if every limit allows it; otherwise nothing is
+// consumed from any of them. It returns the most restrictive decision: the
+// denial with the longest wait, or the allowed limit with the least remaining.
+func (l *Limiter) EvaluateAll(ctx context.Context, limits []Limit) (Decision, error) {
+	if len(limits) == 0 {
+		return Decision{}, fmt.Errorf("no limits to evaluate")
+	}
+	checks, err := storeChecks(limits)
+	if err != nil {
+		return Decision{}, err
+	}
+	results, err := l.store.Multi(ctx, checks)
+	if err != nil {
+		return Decision{}, err
+	}
+	allowed := true
+	for _, res := range results {
+		allowed = allowed && res.Allowed
+	}
+	most := -1
+	for i, res := range results {
+		if !allowed && res.Allowed {
+			// not binding: only the denying limits explain the rejection
+			continue
 		}
-		allowed := count <= p.Limit
-		remaining := p.Limit - count
-		if remaining < 0 {
-			remaining = 0
+		if most < 0 || moreRestrictive(res, results[most]) {
+			most = i
 		}
-		return allowed, remaining, nil
+	}
+	return Decision{Result: results[most], Policy: limits[most].Policy}, nil
+}
+
+// moreRestrictive orders results by longer wait, then fewer remaining, then later reset.
+func moreRestrictive(a, b repository.Result) bool {
+	if a.RetryAfter != b.RetryAfter {
+		return a.RetryAfter > b.RetryAfter
+	}
+	if a.Remaining != b.Remaining {
+		return a.Remaining < b.Remaining
+	}
+	return a.Reset > b.Reset
+}
+
+// Debit takes each limit's cost from it after the fact, even beyond the limit;
+// the resulting debt delays later requests. It settles costs reported once a
+// request has been served. Costs are capped at each limit's quota.
+func (l *Limiter) Debit(ctx context.Context, limits []Limit) error {
+	checks, err := storeChecks(limits)
+	if err != nil {
+		return err
+	}
+	for i, lim := range limits {
+		if q := lim.Policy.Quota(); q > 0 && checks[i].Cost > q {
+			checks[i].Cost = q
+		}
+	}
+	return l.store.Debit(ctx, checks)
+}
+
+// storeChecks maps limits to store checks, rejecting limits that share a key
+// since they would count against the same state.
+func storeChecks(limits []Limit) ([]repository.Check, error) {
+	checks := make([]repository.Check, len(limits))
+	seen := make(map[string]bool, len(limits))
+	for i, lim := range limits {
+		if seen[lim.Key] {
+			return nil, fmt.Errorf("duplicate rate limit key %q", lim.Key)
+		}
+		seen[lim.Key] = true
+		c, err := storeCheck(lim)
+		if err != nil {
+			return nil, err
+		}
+		checks[i] = c
+	}
+	return checks, nil
+}
+
+// storeCheck maps a limit to the store check of its algorithm, with the same
+// key prefixes as Evaluate.
+func storeCheck(lim Limit) (repository.Check, error) {
+	key, p := lim.Key, lim.Policy
+	c := repository.Check{Algorithm: repository.Algorithm(p.Algorithm), Rate: p.Rate, WindowMillis: p.WindowMs, Cost: lim.Cost}
+	if c.Cost <= 0 {
+		c.Cost = 1
+	}
+	switch p.Algorithm {
+	case TokenBucketAlg:
+		c.Key, c.Limit = "tb:"+key, p.Capacity
+	case SlidingWindowAlg:
+		c.Key, c.Limit = "sw:"+key, p.Limit
+	case GCRAAlg:
+		c.Key, c.Limit = "gcra:"+key, p.Capacity
+	case SlidingWindowCounterAlg:
+		c.Key, c.Limit = "swc:"+key, p.Limit
 	default:
-		return false, 0, fmt.Errorf("unknown algorithm %s", p.Algorithm)
+		return c, fmt.Errorf("unknown algorithm %s", p.Algorithm)
 	}
+	return c, nil
 }
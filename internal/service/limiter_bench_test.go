@@ -33,6 +33,32 @@ func BenchmarkSlidingWindowMemory(b *testing.B) {
 	}
 }
 
+// BenchmarkGCRAMemory benchmarks GCRA on memory store.
+func BenchmarkGCRAMemory(b *testing.B) {
+	mem := repository.NewMemoryStore()
+	lim := NewLimiter(mem)
+	policy := Policy{Algorithm: GCRAAlg, Capacity: 100, Rate: 100}
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lim.Allow(ctx, "bench:key", policy)
+	}
+}
+
+// BenchmarkSlidingWindowCounterMemory benchmarks the sliding window counter on memory store.
+func BenchmarkSlidingWindowCounterMemory(b *testing.B) {
+	mem := repository.NewMemoryStore()
+	lim := NewLimiter(mem)
+	policy := Policy{Algorithm: SlidingWindowCounterAlg, WindowMs: 1000, Limit: 100}
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lim.Allow(ctx, "bench:key", policy)
+	}
+}
+
 // BenchmarkConcurrentTokenBucket benchmarks concurrent token bucket access.
 func BenchmarkConcurrentTokenBucket(b *testing.B) {
 	mem := repository.NewMemoryStore()
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"math"
 	"testing"
+	"time"
 
 	"api-gateway/internal/repository"
 )
@@ -61,6 +63,122 @@ func TestSlidingWindowAlgorithm(t *testing.T) {
 	}
 }
 
+func TestGCRAAlgorithm(t *testing.T) {
+	mem := repository.NewMemoryStore()
+	lim := NewLimiter(mem)
+	policy := Policy{Algorithm: GCRAAlg, Capacity: 3, Rate: 1}
+
+	tests := []struct {
+		name      string
+		allowed   bool
+		remaining int64
+	}{
+		{"1st", true, 2},
+		{"2nd", true, 1},
+		{"3rd", true, 0},
+		{"4th", false, 0},
+	}
+
+	for i, tt := range tests {
+		ok, remaining, err := lim.Allow(context.Background(), "key3", policy)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if ok != tt.allowed || remaining != tt.remaining {
+			t.Fatalf("test %d (%s): expected allowed=%v remaining=%d, got %v %d", i, tt.name, tt.allowed, tt.remaining, ok, remaining)
+		}
+	}
+}
+
+func TestSlidingWindowCounterAlgorithm(t *testing.T) {
+	mem := repository.NewMemoryStore()
+	lim := NewLimiter(mem)
+	policy := Policy{Algorithm: SlidingWindowCounterAlg, WindowMs: 60000, Limit: 3}
+
+	tests := []struct {
+		name    string
+		allowed bool
+	}{
+		{"1st", true},
+		{"2nd", true},
+		{"3rd", true},
+		{"4th", false},
+		{"5th", false},
+	}
+
+	for i, tt := range tests {
+		ok, _, err := lim.Allow(context.Background(), "key4", policy)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if ok != tt.allowed {
+			t.Fatalf("test %d (%s): expected allowed=%v, got %v", i, tt.name, tt.allowed, ok)
+		}
+	}
+}
+
+func TestEvaluateAll(t *testing.T) {
+	mem := repository.NewMemoryStore()
+	lim := NewLimiter(mem)
+	ctx := context.Background()
+	perIP := Policy{Name: "ip", Algorithm: TokenBucketAlg, Capacity: 5, Rate: 1}
+	global := Policy{Name: "global", Algorithm: SlidingWindowCounterAlg, WindowMs: 60000, Limit: 3}
+
+	for i := 0; i < 3; i++ {
+		d, err := lim.EvaluateAll(ctx, []Limit{{Key: "ip:a", Policy: perIP}, {Key: "global", Policy: global}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !d.Allowed || d.Policy.Name != "global" || d.Remaining != int64(2-i) {
+			t.Fatalf("request %d: expected the global limit to be the most restrictive, got %+v", i+1, d)
+		}
+	}
+	d, err := lim.EvaluateAll(ctx, []Limit{{Key: "ip:b", Policy: perIP}, {Key: "global", Policy: global}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Allowed || d.Policy.Name != "global" || d.RetryAfter <= 0 {
+		t.Fatalf("expected the global ceiling to deny, got %+v", d)
+	}
+	// the denied request did not consume the per-IP bucket of b
+	if ok, remaining, _ := lim.Allow(ctx, "ip:b", perIP); !ok || remaining != 4 {
+		t.Fatalf("expected ip:b untouched, got %v %d", ok, remaining)
+	}
+
+	if _, err := lim.EvaluateAll(ctx, []Limit{{Key: "x", Policy: Policy{Algorithm: "leaky"}}}); err == nil {
+		t.Fatal("expected an unknown algorithm to be rejected")
+	}
+	if _, err := lim.EvaluateAll(ctx, []Limit{{Key: "global", Policy: perIP}, {Key: "global", Policy: global}}); err == nil {
+		t.Fatal("expected limits sharing a key to be rejected")
+	}
+}
+
+func TestDebitCapsCostAtQuota(t *testing.T) {
+	mem := repository.NewMemoryStore()
+	lim := NewLimiter(mem)
+	ctx := context.Background()
+	window := Policy{Algorithm: SlidingWindowAlg, WindowMs: 60000, Limit: 5}
+	gcra := Policy{Algorithm: GCRAAlg, Capacity: 5, Rate: 1}
+
+	if err := lim.Debit(ctx, []Limit{{Key: "w", Policy: window, Cost: math.MaxInt64}, {Key: "g", Policy: gcra, Cost: math.MaxInt64}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d, err := lim.EvaluateAll(ctx, []Limit{{Key: "w", Policy: window}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Allowed || d.RetryAfter <= 0 || d.RetryAfter > time.Minute {
+		t.Fatalf("expected the window to be full for at most a window, got %+v", d)
+	}
+	d, err = lim.EvaluateAll(ctx, []Limit{{Key: "g", Policy: gcra}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Allowed || d.RetryAfter <= 0 || d.RetryAfter > 5*time.Second {
+		t.Fatalf("expected the GCRA debt to be at most its burst, got %+v", d)
+	}
+}
+
 func TestMultipleKeys(t *testing.T) {
 	mem := repository.NewMemoryStore()
 	lim := NewLimiter(mem)
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// LogFields collects fields that inner handlers contribute to a request's access log line.
+type LogFields struct {
+	mu     sync.Mutex
+	fields map[string]interface{}
+}
+
+type logFieldsKey struct{}
+
+// WithLogFields attaches an empty field collector to ctx.
+func WithLogFields(ctx context.Context) (context.Context, *LogFields) {
+	f := &LogFields{fields: make(map[string]interface{})}
+	return context.WithValue(ctx, logFieldsKey{}, f), f
+}
+
+// AddLogField records a field for the access log; it is a no-op without a collector.
+func AddLogField(ctx context.Context, key string, value interface{}) {
+	if f, ok := ctx.Value(logFieldsKey{}).(*LogFields); ok {
+		f.mu.Lock()
+		f.fields[key] = value
+		f.mu.Unlock()
+	}
+}
+
+// Map returns a copy of the collected fields.
+func (f *LogFields) Map() map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]interface{}, len(f.fields))
+	for k, v := range f.fields {
+		out[k] = v
+	}
+	return out
+}
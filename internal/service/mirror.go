@@ -0,0 +1,180 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"api-gateway/internal/config"
+)
+
+// MirrorHeader marks shadow requests so upstreams can suppress side effects.
+const MirrorHeader = "X-Gateway-Mirror"
+
+// hopHeaders are connection-scoped and not copied to shadow requests.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// Mirror copies a sample of a route's requests to a shadow cluster.
+type Mirror struct {
+	Cluster string
+	percent float64
+	maxBody int64
+	timeout time.Duration
+	slots   chan struct{}
+}
+
+// NewMirror compiles a mirror config.
+func NewMirror(cfg config.MirrorConfig) *Mirror {
+	cfg = cfg.WithDefaults()
+	return &Mirror{
+		Cluster: cfg.Cluster,
+		percent: cfg.Percent,
+		maxBody: cfg.MaxBodyBytes,
+		timeout: time.Duration(cfg.TimeoutMs) * time.Millisecond,
+		slots:   make(chan struct{}, cfg.MaxConcurrent),
+	}
+}
+
+// Sample reports whether a request should be mirrored.
+func (m *Mirror) Sample() bool {
+	return rand.Float64()*100 < m.percent
+}
+
+// Acquire reserves a shadow request slot, reporting false when the
+// concurrency cap is reached.
+func (m *Mirror) Acquire() bool {
+	select {
+	case m.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (m *Mirror) Release() {
+	<-m.slots
+}
+
+// Tee replaces r.Body with a reader that copies what the primary request reads,
+// up to the mirror's limit, so the shadow can replay the same bytes without
+// delaying the primary. It returns nil, leaving r untouched, when the declared
+// body length already exceeds the limit.
+func (m *Mirror) Tee(r *http.Request) *MirrorBody {
+	b := &MirrorBody{limit: m.maxBody, done: make(chan struct{})}
+	if r.Body == nil || r.Body == http.NoBody {
+		b.complete = true
+		b.finish()
+		return b
+	}
+	if r.ContentLength > m.maxBody {
+		return nil
+	}
+	b.ReadCloser = r.Body
+	r.Body = b
+	return b
+}
+
+// MirrorBody is the primary request body as copied by Mirror.Tee.
+type MirrorBody struct {
+	io.ReadCloser
+	limit int64
+	done  chan struct{}
+	once  sync.Once
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	complete bool // the primary read the body to EOF
+	tooLarge bool
+	finished bool
+}
+
+func (b *MirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	if !b.finished && !b.tooLarge {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.tooLarge = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.finished {
+		b.complete = true
+		b.finished = true
+		b.finish()
+	}
+	b.mu.Unlock()
+	return n, err
+}
+
+func (b *MirrorBody) Close() error {
+	b.Abandon()
+	return b.ReadCloser.Close()
+}
+
+// Abandon stops copying; a body the primary has not read to the end is not
+// replayed. It is called once the primary request has completed.
+func (b *MirrorBody) Abandon() {
+	b.mu.Lock()
+	b.finished = true
+	b.mu.Unlock()
+	b.finish()
+}
+
+func (b *MirrorBody) finish() {
+	b.once.Do(func() { close(b.done) })
+}
+
+// Wait blocks until the primary has read the whole body or stopped reading it,
+// and returns the copy. It reports "" when the copy is complete, otherwise
+// "body_too_large" or "body_incomplete".
+func (b *MirrorBody) Wait() ([]byte, string) {
+	<-b.done
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.tooLarge:
+		return nil, "body_too_large"
+	case !b.complete:
+		return nil, "body_incomplete"
+	}
+	return b.buf.Bytes(), ""
+}
+
+// Request builds the shadow copy of r. The copy is detached from the client's
+// cancellation and the primary deadline, bounded by the mirror timeout, and is
+// never retried. It has no body; see SetMirrorBody. The returned cancel func
+// must be called when it completes.
+func (m *Mirror) Request(r *http.Request, match *RouteMatch) (*http.Request, context.CancelFunc) {
+	route := *match.Route
+	route.Retry = nil
+	shadow := *match
+	shadow.Route = &route
+	shadow.Cluster = m.Cluster
+	ctx := WithRouteMatch(context.WithoutCancel(r.Context()), &shadow)
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+
+	out := r.Clone(ctx)
+	out.RequestURI = ""
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	out.Header.Set(MirrorHeader, "true")
+	out.Body, out.ContentLength = http.NoBody, 0
+	out.GetBody = nil
+	return out, cancel
+}
+
+// SetMirrorBody attaches the copied primary body to a shadow request.
+func SetMirrorBody(req *http.Request, body []byte) {
+	if len(body) > 0 {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestMirrorTeeAndRequest(t *testing.T) {
+	m := NewMirror(config.MirrorConfig{Cluster: "shadow", Percent: 100})
+	ctx, cancelClient := context.WithCancel(context.Background())
+	r := httptest.NewRequest("POST", "/orders", strings.NewReader("payload")).WithContext(ctx)
+	r.Header.Set("Connection", "close")
+	match := &RouteMatch{Route: &Route{Name: "orders", Cluster: "primary", Retry: &config.RetryConfig{Attempts: 2}}, Cluster: "primary"}
+
+	tee := m.Tee(r)
+	if tee == nil {
+		t.Fatal("expected the body to be teed")
+	}
+	if b, _ := io.ReadAll(r.Body); string(b) != "payload" {
+		t.Fatalf("primary body not passed through, got %q", b)
+	}
+	body, reason := tee.Wait()
+	if reason != "" || string(body) != "payload" {
+		t.Fatalf("unexpected copy %q (%s)", body, reason)
+	}
+
+	shadow, cancel := m.Request(r, match)
+	defer cancel()
+	SetMirrorBody(shadow, body)
+	cancelClient()
+	if shadow.Context().Err() != nil {
+		t.Fatal("shadow request must not be cancelled with the client")
+	}
+	if b, _ := io.ReadAll(shadow.Body); string(b) != "payload" || shadow.ContentLength != 7 {
+		t.Fatalf("unexpected shadow body %q (%d)", b, shadow.ContentLength)
+	}
+	if shadow.Header.Get(MirrorHeader) != "true" || shadow.Header.Get("Connection") != "" {
+		t.Fatalf("unexpected shadow headers: %v", shadow.Header)
+	}
+	sm, _ := RouteMatchFromContext(shadow.Context())
+	if sm.Cluster != "shadow" || sm.Route.Retry != nil || match.Route.Retry == nil {
+		t.Fatalf("shadow match should target the mirror cluster without retries: %+v", sm)
+	}
+}
+
+func TestMirrorTeeLimits(t *testing.T) {
+	m := NewMirror(config.MirrorConfig{Cluster: "shadow", Percent: 100, MaxBodyBytes: 4})
+	r := httptest.NewRequest("POST", "/", strings.NewReader("too large"))
+	if m.Tee(r) != nil {
+		t.Fatal("expected a declared length above the limit to be rejected")
+	}
+
+	r = httptest.NewRequest("POST", "/", io.MultiReader(strings.NewReader("too large")))
+	r.ContentLength = -1
+	tee := m.Tee(r)
+	if b, _ := io.ReadAll(r.Body); string(b) != "too large" {
+		t.Fatalf("primary body must remain intact, got %q", b)
+	}
+	if _, reason := tee.Wait(); reason != "body_too_large" {
+		t.Fatalf("expected body_too_large, got %q", reason)
+	}
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader("abc"))
+	tee = m.Tee(r)
+	r.Body.Read(make([]byte, 1))
+	tee.Abandon()
+	if _, reason := tee.Wait(); reason != "body_incomplete" {
+		t.Fatalf("expected body_incomplete, got %q", reason)
+	}
+
+	tee = m.Tee(httptest.NewRequest("GET", "/", nil))
+	if body, reason := tee.Wait(); reason != "" || body != nil {
+		t.Fatalf("expected an empty body to be ready, got %q (%s)", body, reason)
+	}
+}
+
+func TestMirrorConcurrencyCap(t *testing.T) {
+	m := NewMirror(config.MirrorConfig{Cluster: "shadow", MaxConcurrent: 1})
+	if m.Sample() {
+		t.Fatal("0 percent must never sample")
+	}
+	if !m.Acquire() {
+		t.Fatal("expected first slot")
+	}
+	if m.Acquire() {
+		t.Fatal("expected cap to reject second slot")
+	}
+	m.Release()
+	if !m.Acquire() {
+		t.Fatal("expected slot after release")
+	}
+}
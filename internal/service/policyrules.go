@@ -0,0 +1,167 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"api-gateway/internal/config"
+)
+
+// DefaultPolicyName names requests that matched no rule.
+const DefaultPolicyName = "default"
+
+// PolicyResolver selects the rate-limit policy of a request from ordered rules
+// (see config.RateLimitRules for the precedence).
+type PolicyResolver struct {
+	rules []*policyRule
+	def   string
+}
+
+// PolicyMatch is the outcome of resolving a request's policy.
+type PolicyMatch struct {
+	Name   string // the matched rule, or DefaultPolicyName
+	Policy string // policy store key; "" for the built-in default
+}
+
+type policyRule struct {
+	match    PolicyMatch
+	exact    string
+	prefix   string   // from "/prefix/*"
+	segments []string // template segments; "{name}" matches one path segment
+	methods  map[string]bool
+	tiers    map[string]bool
+	roles    map[string]bool
+	claims   map[string]string
+	nets     []*net.IPNet
+}
+
+// NewPolicyResolver compiles rate-limit rules, ordered by descending priority.
+func NewPolicyResolver(cfg config.RateLimitRules) (*PolicyResolver, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	ordered := make([]config.PolicyRule, len(cfg.Rules))
+	copy(ordered, cfg.Rules)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Priority > ordered[j].Priority })
+
+	pr := &PolicyResolver{def: cfg.Default}
+	for _, c := range ordered {
+		r := &policyRule{
+			match:   PolicyMatch{Name: c.Name, Policy: c.Policy},
+			methods: stringSet(c.Methods, strings.ToUpper),
+			tiers:   stringSet(c.Tiers, nil),
+			roles:   stringSet(c.Roles, nil),
+			claims:  c.Claims,
+		}
+		switch {
+		case strings.HasSuffix(c.Path, "/*"):
+			r.prefix = strings.TrimSuffix(c.Path, "*")
+		case strings.Contains(c.Path, "{"):
+			r.segments = strings.Split(strings.Trim(c.Path, "/"), "/")
+			for _, s := range r.segments {
+				if strings.HasPrefix(s, "{") != strings.HasSuffix(s, "}") {
+					return nil, fmt.Errorf("rate limit rule %s: malformed path parameter %q", c.Name, s)
+				}
+			}
+		default:
+			r.exact = c.Path
+		}
+		for _, cidr := range c.CIDRs {
+			_, n, _ := net.ParseCIDR(cidr)
+			r.nets = append(r.nets, n)
+		}
+		pr.rules = append(pr.rules, r)
+	}
+	return pr, nil
+}
+
+// Resolve returns the first rule matching the request, or DefaultPolicyName with
+// the default key.
+func (pr *PolicyResolver) Resolve(r *http.Request) PolicyMatch {
+	if pr == nil {
+		return PolicyMatch{Name: DefaultPolicyName}
+	}
+	id, _ := IdentityFromContext(r.Context())
+	ip := net.ParseIP(ClientIP(r))
+	for _, rule := range pr.rules {
+		if rule.matches(r, id, ip) {
+			return rule.match
+		}
+	}
+	return PolicyMatch{Name: DefaultPolicyName, Policy: pr.def}
+}
+
+func (rule *policyRule) matches(r *http.Request, id Identity, ip net.IP) bool {
+	switch {
+	case rule.exact != "" && r.URL.Path != rule.exact:
+		return false
+	case rule.prefix != "" && !strings.HasPrefix(r.URL.Path, rule.prefix) && r.URL.Path != rule.prefix[:len(rule.prefix)-1]:
+		// "/prefix/*" also matches "/prefix" itself
+		return false
+	case rule.methods != nil && !rule.methods[r.Method]:
+		return false
+	case rule.tiers != nil && !rule.tiers[id.Tier]:
+		return false
+	case rule.roles != nil && !rule.roles[id.Role]:
+		return false
+	}
+	if rule.segments != nil {
+		if _, ok := matchTemplate(rule.segments, r.URL.Path); !ok {
+			return false
+		}
+	}
+	for name, want := range rule.claims {
+		if !claimMatches(id.Claims[name], want) {
+			return false
+		}
+	}
+	if rule.nets != nil {
+		if ip == nil {
+			return false
+		}
+		for _, n := range rule.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// claimMatches compares a decoded JWT claim with a configured value; arrays
+// match if any element does.
+func claimMatches(v interface{}, want string) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case string:
+		return v == want
+	case []interface{}:
+		for _, e := range v {
+			if claimMatches(e, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return fmt.Sprint(v) == want
+}
+
+// stringSet returns the set of values after norm, or nil when there are none.
+func stringSet(values []string, norm func(string) string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		if norm != nil {
+			v = norm(v)
+		}
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestPolicyResolver(t *testing.T) {
+	pr, err := NewPolicyResolver(config.RateLimitRules{
+		Default: "fallback",
+		Rules: []config.PolicyRule{
+			{Name: "tier", Tiers: []string{"premium"}, Policy: "p-tier"},
+			{Name: "internal", Priority: 5, CIDRs: []string{"10.0.0.0/8", "fd00::/8"}, Policy: "p-internal"},
+			{Name: "writes", Priority: 10, Path: "/users/{id}", Methods: []string{"put", "delete"}, Policy: "p-writes"},
+			{Name: "ops", Roles: []string{"operator"}, Path: "/admin/*", Policy: "p-ops"},
+			{Name: "beta", Claims: map[string]string{"groups": "beta", "org": "42"}, Policy: "p-beta"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		remote     string
+		id         *Identity
+		wantRule   string
+		wantPolicy string
+	}{
+		{"no match uses default", "GET", "/orders", "1.2.3.4:1", nil, DefaultPolicyName, "fallback"},
+		{"api key tier", "GET", "/orders", "1.2.3.4:1", &Identity{Tier: "premium"}, "tier", "p-tier"},
+		{"client cidr", "GET", "/orders", "10.1.2.3:1", &Identity{Tier: "premium"}, "internal", "p-internal"},
+		{"ipv6 cidr", "GET", "/orders", "[fd00::1]:1", nil, "internal", "p-internal"},
+		{"path template and method", "PUT", "/users/7", "10.1.2.3:1", nil, "writes", "p-writes"},
+		{"method not listed", "GET", "/users/7", "1.2.3.4:1", nil, DefaultPolicyName, "fallback"},
+		{"template segment count", "PUT", "/users/7/keys", "1.2.3.4:1", nil, DefaultPolicyName, "fallback"},
+		{"role and prefix", "GET", "/admin/policies", "1.2.3.4:1", &Identity{Role: "operator"}, "ops", "p-ops"},
+		{"bare prefix", "GET", "/admin", "1.2.3.4:1", &Identity{Role: "operator"}, "ops", "p-ops"},
+		{"prefix segment boundary", "GET", "/administrator", "1.2.3.4:1", &Identity{Role: "operator"}, DefaultPolicyName, "fallback"},
+		{"role outside prefix", "GET", "/api/x", "1.2.3.4:1", &Identity{Role: "operator"}, DefaultPolicyName, "fallback"},
+		{"array and numeric claims", "GET", "/x", "1.2.3.4:1", &Identity{Claims: map[string]interface{}{"groups": []interface{}{"dev", "beta"}, "org": float64(42)}}, "beta", "p-beta"},
+		{"missing claim", "GET", "/x", "1.2.3.4:1", &Identity{Claims: map[string]interface{}{"groups": "beta"}}, DefaultPolicyName, "fallback"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.RemoteAddr = tc.remote
+			if tc.id != nil {
+				req = req.WithContext(WithIdentity(req.Context(), *tc.id))
+			}
+			want := PolicyMatch{Name: tc.wantRule, Policy: tc.wantPolicy}
+			if got := pr.Resolve(req); got != want {
+				t.Fatalf("expected %+v, got %+v", want, got)
+			}
+		})
+	}
+
+	var none *PolicyResolver
+	if got := none.Resolve(httptest.NewRequest(http.MethodGet, "/", nil)); got != (PolicyMatch{Name: DefaultPolicyName}) {
+		t.Fatalf("expected the built-in default without rules, got %+v", got)
+	}
+}
+
+func TestNewPolicyResolverInvalid(t *testing.T) {
+	for name, rule := range map[string]config.PolicyRule{
+		"empty name":     {Policy: "p"},
+		"missing policy": {Name: "r"},
+		"relative path":  {Name: "r", Path: "api", Policy: "p"},
+		"bad cidr":       {Name: "r", CIDRs: []string{"10.0.0.0/33"}, Policy: "p"},
+		"bad template":   {Name: "r", Path: "/users/{id", Policy: "p"},
+	} {
+		if _, err := NewPolicyResolver(config.RateLimitRules{Rules: []config.PolicyRule{rule}}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	dup := []config.PolicyRule{{Name: "r", Policy: "p"}, {Name: "r", Policy: "q"}}
+	if _, err := NewPolicyResolver(config.RateLimitRules{Rules: dup}); err == nil {
+		t.Error("expected duplicate rule names to be rejected")
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/internal/config"
+)
+
+// retryBudget allows retries while active retries stay below a share of active requests.
+type retryBudget struct {
+	percent        float64
+	minConcurrency int64
+	active         int64 // requests in flight through the cluster
+	retries        int64 // retries in flight through the cluster
+}
+
+func newRetryBudget(cfg config.RetryBudgetConfig) *retryBudget {
+	cfg = cfg.WithDefaults()
+	return &retryBudget{percent: cfg.BudgetPercent, minConcurrency: int64(cfg.MinRetryConcurrency)}
+}
+
+// acquire reserves a retry slot, reporting false when the budget is exhausted.
+func (b *retryBudget) acquire() bool {
+	limit := int64(float64(atomic.LoadInt64(&b.active)) * b.percent / 100)
+	if limit < b.minConcurrency {
+		limit = b.minConcurrency
+	}
+	if atomic.AddInt64(&b.retries, 1) > limit {
+		atomic.AddInt64(&b.retries, -1)
+		return false
+	}
+	return true
+}
+
+func (b *retryBudget) release() {
+	atomic.AddInt64(&b.retries, -1)
+}
+
+// retryPolicy returns the retry configuration of the route matched for req, if the
+// request may be retried at all.
+func retryPolicy(req *http.Request) *config.RetryConfig {
+	m, ok := RouteMatchFromContext(req.Context())
+	if !ok || m.Route.Retry == nil {
+		return nil
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return m.Route.Retry
+	}
+	if req.Header.Get("Idempotency-Key") != "" {
+		return m.Route.Retry
+	}
+	return nil
+}
+
+// shouldRetry reports whether an attempt's outcome matches the retry conditions.
+func shouldRetry(p *config.RetryConfig, resp *http.Response, err error) bool {
+	if err != nil {
+		var opErr *net.OpError
+		if !errors.As(err, &opErr) || opErr.Op != "dial" {
+			return false
+		}
+		for _, cond := range p.RetryOn {
+			if cond == config.RetryOnConnectFailure {
+				return true
+			}
+		}
+		return false
+	}
+	for _, cond := range p.RetryOn {
+		if cond == config.RetryOnGatewayError {
+			switch resp.StatusCode {
+			case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				return true
+			}
+		}
+	}
+	for _, code := range p.StatusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns a full-jitter exponential delay for the given retry (1-based).
+func backoff(p *config.RetryConfig, retry int) time.Duration {
+	d := time.Duration(p.BaseBackoffMs) * time.Millisecond
+	max := time.Duration(p.MaxBackoffMs) * time.Millisecond
+	for i := 1; i < retry && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// bufferBody reads up to limit bytes of the request body so it can be replayed.
+// It reports false, restoring the body as a stream, when the body is larger.
+func bufferBody(req *http.Request, limit int64) ([]byte, bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		req.Body.Close()
+		return nil, false, err
+	}
+	if int64(len(buf)) > limit {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return nil, false, nil
+	}
+	req.Body.Close()
+	return buf, true, nil
+}
+
+// roundTripWithRetries performs the first attempt and retries per policy, preferring
+// a target not tried yet on each attempt. When the retry budget is exhausted the
+// last outcome is returned as is.
+func (c *Cluster) roundTripWithRetries(req *http.Request, p *config.RetryConfig) (*http.Response, error) {
+	body, replayable, err := bufferBody(req, p.MaxBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !replayable {
+		return c.roundTripOnce(req)
+	}
+
+	tried := make(map[*Target]bool)
+	resp, err := c.attempt(req, body, tried)
+	for retry := 1; ; retry++ {
+		if retry > p.Attempts || !shouldRetry(p, resp, err) {
+			return resp, err
+		}
+		if !c.budget.acquire() {
+			if c.metrics != nil {
+				c.metrics.UpstreamRetryBudgetExhausted.WithLabelValues(c.Name).Inc()
+			}
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		if c.metrics != nil {
+			c.metrics.UpstreamRetries.WithLabelValues(c.Name).Inc()
+		}
+		select {
+		case <-time.After(backoff(p, retry)):
+		case <-req.Context().Done():
+			c.budget.release()
+			return nil, req.Context().Err()
+		}
+		resp, err = c.attempt(req, body, tried)
+		c.budget.release()
+	}
+}
+
+// attempt sends one replay of req with a fresh copy of body to a target not in tried.
+func (c *Cluster) attempt(req *http.Request, body []byte, tried map[*Target]bool) (*http.Response, error) {
+	t, err := c.pick(req, tried)
+	if err != nil {
+		return nil, err
+	}
+	tried[t] = true
+	out := req
+	if body != nil {
+		out = req.WithContext(req.Context())
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+	return c.send(t, out)
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+// retryRequest builds a request carrying a route match with the given retry policy.
+func retryRequest(method, body string, retry *config.RetryConfig) *http.Request {
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, "http://gateway/orders", rd)
+	rc := retry.WithDefaults()
+	m := &RouteMatch{Route: &Route{Name: "orders", Cluster: "orders", Retry: &rc}}
+	return req.WithContext(WithRouteMatch(req.Context(), m))
+}
+
+func TestClusterRetriesOnGatewayErrorWithDifferentTarget(t *testing.T) {
+	var failing, healthy int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failing, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&healthy, 1)
+		b, _ := io.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	defer good.Close()
+
+	c, err := NewCluster(config.ClusterConfig{
+		Name:    "orders",
+		Targets: []config.TargetConfig{{URL: bad.URL}, {URL: good.URL}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// round robin starts on the failing target; the retry must go to the other one
+	req := retryRequest("POST", "payload", &config.RetryConfig{Attempts: 2, BaseBackoffMs: 1})
+	req.Header.Set("Idempotency-Key", "abc")
+	resp, err := c.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("round trip: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "payload" {
+		t.Fatalf("expected replayed body from healthy target, got %d %q", resp.StatusCode, body)
+	}
+	if atomic.LoadInt32(&failing) != 1 || atomic.LoadInt32(&healthy) != 1 {
+		t.Fatalf("expected one attempt per target, got failing=%d healthy=%d", failing, healthy)
+	}
+}
+
+func TestClusterDoesNotRetryNonIdempotentWithoutKey(t *testing.T) {
+	var hits int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+
+	c, _ := NewCluster(config.ClusterConfig{Name: "orders", URL: bad.URL}, nil)
+	resp, err := c.RoundTrip(retryRequest("POST", "x", &config.RetryConfig{Attempts: 3, BaseBackoffMs: 1}))
+	if err != nil {
+		t.Fatalf("round trip: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || atomic.LoadInt32(&hits) != 1 {
+		t.Fatalf("expected single attempt, got status %d hits %d", resp.StatusCode, hits)
+	}
+}
+
+func TestClusterRetriesConnectFailure(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+
+	c, _ := NewCluster(config.ClusterConfig{
+		Name:    "orders",
+		Targets: []config.TargetConfig{{URL: closedURL}, {URL: good.URL}},
+	}, nil)
+	resp, err := c.RoundTrip(retryRequest("GET", "", &config.RetryConfig{Attempts: 1, RetryOn: []string{config.RetryOnConnectFailure}, BaseBackoffMs: 1}))
+	if err != nil {
+		t.Fatalf("expected retry to succeed, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestClusterRetriesStatusCodesAndBodyLimit(t *testing.T) {
+	var hits int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer backend.Close()
+	c, _ := NewCluster(config.ClusterConfig{Name: "orders", URL: backend.URL}, nil)
+	policy := &config.RetryConfig{Attempts: 2, StatusCodes: []int{429}, BaseBackoffMs: 1, MaxBodyBytes: 4}
+
+	resp, _ := c.RoundTrip(retryRequest("PUT", "abc", policy))
+	resp.Body.Close()
+	if atomic.LoadInt32(&hits) != 3 {
+		t.Fatalf("expected 3 attempts for retriable status, got %d", hits)
+	}
+
+	// bodies over the buffer limit cannot be replayed and are sent once
+	atomic.StoreInt32(&hits, 0)
+	resp, _ = c.RoundTrip(retryRequest("PUT", "too large", policy))
+	resp.Body.Close()
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Fatalf("expected single attempt for oversized body, got %d", hits)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(config.RetryBudgetConfig{BudgetPercent: 50, MinRetryConcurrency: 1})
+	if !b.acquire() {
+		t.Fatal("expected first retry within minimum concurrency")
+	}
+	if b.acquire() {
+		t.Fatal("expected budget to be exhausted")
+	}
+	b.active = 10
+	if !b.acquire() {
+		t.Fatal("expected budget to grow with active requests")
+	}
+	b.release()
+	b.release()
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"api-gateway/internal/config"
+)
+
+// Rewrite is a compiled route rewrite rule.
+type Rewrite struct {
+	stripPrefix string
+	re          *regexp.Regexp
+	replacement string
+	addPrefix   string
+	host        string
+}
+
+// NewRewrite compiles a rewrite config.
+func NewRewrite(cfg config.RewriteConfig) (*Rewrite, error) {
+	rw := &Rewrite{
+		stripPrefix: cfg.StripPrefix,
+		replacement: cfg.Replacement,
+		addPrefix:   cfg.AddPrefix,
+		host:        cfg.Host,
+	}
+	if cfg.Regex != "" {
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite regex: %w", err)
+		}
+		rw.re = re
+	}
+	return rw, nil
+}
+
+// Apply returns a copy of r with the rewritten path and Host. r itself is left
+// untouched so earlier pipeline stages keep seeing the public request.
+func (rw *Rewrite) Apply(r *http.Request) *http.Request {
+	out := r.WithContext(r.Context())
+	u := *r.URL
+	out.URL = &u
+
+	path := r.URL.Path
+	if rw.stripPrefix != "" && hasPathPrefix(path, rw.stripPrefix) {
+		path = path[len(rw.stripPrefix):]
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		out.Header = r.Header.Clone()
+		out.Header.Set("X-Forwarded-Prefix", rw.stripPrefix)
+	}
+	if rw.re != nil {
+		path = rw.re.ReplaceAllString(path, rw.replacement)
+	}
+	if rw.addPrefix != "" {
+		path = singleJoiningSlash(rw.addPrefix, path)
+	}
+	if path != r.URL.Path {
+		u.Path = path
+		u.RawPath = ""
+	}
+	if rw.host != "" {
+		out.Host = rw.host
+	}
+	return out
+}
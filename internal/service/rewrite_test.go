@@ -0,0 +1,57 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestRewriteApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.RewriteConfig
+		path     string
+		wantPath string
+	}{
+		{"strip prefix", config.RewriteConfig{StripPrefix: "/api/v1"}, "/api/v1/orders", "/orders"},
+		{"strip whole path", config.RewriteConfig{StripPrefix: "/api/v1"}, "/api/v1", "/"},
+		{"strip only whole segments", config.RewriteConfig{StripPrefix: "/api"}, "/apiother", "/apiother"},
+		{"add prefix", config.RewriteConfig{AddPrefix: "/internal"}, "/orders/1", "/internal/orders/1"},
+		{"regex groups", config.RewriteConfig{Regex: `^/users/(\d+)/profile$`, Replacement: "/profiles/$1"}, "/users/42/profile", "/profiles/42"},
+		{"named groups", config.RewriteConfig{Regex: `^/v(?P<ver>\d)/(?P<rest>.*)$`, Replacement: "/${rest}/v${ver}"}, "/v2/items", "/items/v2"},
+		{"all steps", config.RewriteConfig{StripPrefix: "/public", Regex: "^/old/", Replacement: "/new/", AddPrefix: "/svc"}, "/public/old/x", "/svc/new/x"},
+	}
+	for _, tt := range tests {
+		rw, err := NewRewrite(tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		req := httptest.NewRequest("GET", tt.path, nil)
+		out := rw.Apply(req)
+		if out.URL.Path != tt.wantPath {
+			t.Fatalf("%s: expected %s, got %s", tt.name, tt.wantPath, out.URL.Path)
+		}
+		if req.URL.Path != tt.path {
+			t.Fatalf("%s: original request was modified", tt.name)
+		}
+	}
+}
+
+func TestRewriteHostAndForwardedPrefix(t *testing.T) {
+	rw, _ := NewRewrite(config.RewriteConfig{StripPrefix: "/api", Host: "orders.internal"})
+	req := httptest.NewRequest("GET", "http://public.example.com/api/orders", nil)
+	out := rw.Apply(req)
+	if out.Host != "orders.internal" || req.Host != "public.example.com" {
+		t.Fatalf("unexpected hosts: out=%s original=%s", out.Host, req.Host)
+	}
+	if out.Header.Get("X-Forwarded-Prefix") != "/api" || req.Header.Get("X-Forwarded-Prefix") != "" {
+		t.Fatal("expected X-Forwarded-Prefix only on the rewritten request")
+	}
+}
+
+func TestNewRewriteInvalidRegex(t *testing.T) {
+	if _, err := NewRewrite(config.RewriteConfig{Regex: "("}); err == nil {
+		t.Fatal("expected error for invalid regex")
+	}
+}
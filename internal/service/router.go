@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"api-gateway/internal/config"
+)
+
+// Route is a compiled entry of the route table.
+type Route struct {
+	Name    string
+	Cluster string
+	Retry   *config.RetryConfig
+	Timeout config.TimeoutConfig
+	// FlushInterval is passed to the reverse proxy; negative flushes immediately.
+	FlushInterval time.Duration
+	Rewrite       *Rewrite
+	Headers       *HeaderRules
+	Canary        *Canary
+	Mirror        *Mirror
+	WebSocket     *WebSocket
+	GRPCWeb       *GRPCWeb
+	Transform     *Transform
+	RateLimitCost int64 // taken from every rate limit per request, at least 1
+	grpc          bool  // only matches gRPC calls
+	host          string
+	prefix        string
+	segments      []string // template segments; "{name}" captures one path segment
+	methods       map[string]bool
+}
+
+// RouteMatch is the result of matching a request against the route table.
+type RouteMatch struct {
+	Route  *Route
+	Params map[string]string
+	// Cluster is the cluster serving the request; it differs from Route.Cluster
+	// when a canary was chosen.
+	Cluster string
+	Variant string
+}
+
+// Router matches requests against an ordered route table. The first matching route wins.
+type Router struct {
+	routes []*Route
+}
+
+// NewRouter compiles route configs into a Router.
+func NewRouter(cfgs []config.RouteConfig) (*Router, error) {
+	rt := &Router{}
+	for i, c := range cfgs {
+		r := &Route{
+			Name:          c.Name,
+			Cluster:       c.Cluster,
+			FlushInterval: time.Duration(c.FlushIntervalMs) * time.Millisecond,
+			RateLimitCost: max(c.RateLimitCost, 1),
+			host:          strings.ToLower(c.Host),
+			prefix:        c.PathPrefix,
+		}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("route-%d", i)
+		}
+		if c.Timeout != nil {
+			r.Timeout = *c.Timeout
+		}
+		if c.Rewrite != nil {
+			rw, err := NewRewrite(*c.Rewrite)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", r.Name, err)
+			}
+			r.Rewrite = rw
+		}
+		if c.Headers != nil {
+			hr, err := NewHeaderRules(*c.Headers)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", r.Name, err)
+			}
+			r.Headers = hr
+		}
+		if c.Canary != nil {
+			cn, err := NewCanary(*c.Canary)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", r.Name, err)
+			}
+			r.Canary = cn
+		}
+		if c.Transform != nil {
+			tf, err := NewTransform(*c.Transform)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %w", r.Name, err)
+			}
+			r.Transform = tf
+		}
+		if c.Mirror != nil {
+			r.Mirror = NewMirror(*c.Mirror)
+		}
+		if c.WebSocket != nil {
+			r.WebSocket = NewWebSocket(*c.WebSocket)
+		}
+		if c.Retry != nil && c.Retry.Attempts > 0 {
+			rc := c.Retry.WithDefaults()
+			r.Retry = &rc
+		}
+		if c.Type == config.RouteTypeGRPC {
+			r.grpc = true
+			if c.GRPCWeb != nil {
+				r.GRPCWeb = NewGRPCWeb(*c.GRPCWeb)
+			}
+			r.methods = map[string]bool{http.MethodPost: true}
+			switch {
+			case c.GRPCMethod != "":
+				c.Path = "/" + c.GRPCService + "/" + c.GRPCMethod
+			case c.GRPCService != "":
+				r.prefix = "/" + c.GRPCService + "/"
+			}
+		}
+		if c.Path != "" {
+			if !strings.HasPrefix(c.Path, "/") {
+				return nil, fmt.Errorf("route %s: path must start with /", r.Name)
+			}
+			r.segments = strings.Split(strings.Trim(c.Path, "/"), "/")
+			for _, s := range r.segments {
+				if strings.HasPrefix(s, "{") != strings.HasSuffix(s, "}") {
+					return nil, fmt.Errorf("route %s: malformed path parameter %q", r.Name, s)
+				}
+			}
+		}
+		if len(c.Methods) > 0 && !r.grpc {
+			r.methods = make(map[string]bool, len(c.Methods))
+			for _, m := range c.Methods {
+				r.methods[strings.ToUpper(m)] = true
+			}
+		}
+		rt.routes = append(rt.routes, r)
+	}
+	return rt, nil
+}
+
+// Routes returns the compiled routes in match order.
+func (rt *Router) Routes() []*Route {
+	return rt.routes
+}
+
+// Route returns the route with the given name.
+func (rt *Router) Route(name string) (*Route, bool) {
+	for _, r := range rt.routes {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// Match returns the first route matching the request's host, path and method.
+func (rt *Router) Match(r *http.Request) (*RouteMatch, bool) {
+	host := requestHost(r)
+	grpc := IsGRPCRequest(r)
+	for _, route := range rt.routes {
+		if route.methods != nil && !route.methods[r.Method] {
+			continue
+		}
+		if route.grpc && !grpc {
+			continue
+		}
+		if route.host != "" && !matchHost(route.host, host) {
+			continue
+		}
+		if route.prefix != "" && !hasPathPrefix(r.URL.Path, route.prefix) {
+			continue
+		}
+		var params map[string]string
+		if route.segments != nil {
+			var ok bool
+			if params, ok = matchTemplate(route.segments, r.URL.Path); !ok {
+				continue
+			}
+		}
+		return &RouteMatch{Route: route, Params: params, Cluster: route.Cluster, Variant: VariantStable}, true
+	}
+	return nil, false
+}
+
+// RateLimitCost returns the rate limit cost of the route matching r, or one
+// when no route matches or rt is nil.
+func (rt *Router) RateLimitCost(r *http.Request) int64 {
+	if rt == nil {
+		return 1
+	}
+	if m, ok := rt.Match(r); ok {
+		return m.Route.RateLimitCost
+	}
+	return 1
+}
+
+// hasPathPrefix reports whether path starts with prefix at a segment boundary:
+// "/api" matches "/api" and "/api/x" but not "/apiother".
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// matchTemplate matches path against template segments, capturing "{name}" parameters.
+func matchTemplate(segments []string, path string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, "{") {
+			if parts[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[seg[1:len(seg)-1]] = parts[i]
+			continue
+		}
+		if seg != parts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// matchHost compares a host pattern ("api.example.com" or "*.example.com") with a request host.
+func matchHost(pattern, host string) bool {
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+// requestHost returns the lower-cased request host without port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+type routeMatchKey struct{}
+
+// WithRouteMatch stores the matched route in the context for later pipeline stages.
+func WithRouteMatch(ctx context.Context, m *RouteMatch) context.Context {
+	return context.WithValue(ctx, routeMatchKey{}, m)
+}
+
+// RouteMatchFromContext returns the route matched for the request, if any.
+func RouteMatchFromContext(ctx context.Context) (*RouteMatch, bool) {
+	m, ok := ctx.Value(routeMatchKey{}).(*RouteMatch)
+	return m, ok
+}
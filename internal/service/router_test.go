@@ -0,0 +1,95 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestRouterMatch(t *testing.T) {
+	rt, err := NewRouter([]config.RouteConfig{
+		{Name: "user", Path: "/users/{id}", Methods: []string{"GET"}, Cluster: "users"},
+		{Name: "admin-host", Host: "*.internal.example.com", PathPrefix: "/", Cluster: "internal"},
+		{Name: "orders", PathPrefix: "/api/orders", Cluster: "orders"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		method, host, path string
+		route              string
+		ok                 bool
+	}{
+		{"GET", "gw", "/users/42", "user", true},
+		{"POST", "gw", "/users/42", "", false},
+		{"GET", "gw", "/users/42/orders", "", false},
+		{"GET", "ops.internal.example.com:8080", "/anything", "admin-host", true},
+		{"PUT", "gw", "/api/orders/7", "orders", true},
+		{"GET", "gw", "/api/other", "", false},
+		{"GET", "gw", "/api/orders", "orders", true},
+		{"GET", "gw", "/api/ordersarchive", "", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://"+tt.host+tt.path, nil)
+		m, ok := rt.Match(req)
+		if ok != tt.ok {
+			t.Fatalf("%s %s%s: expected ok=%v, got %v", tt.method, tt.host, tt.path, tt.ok, ok)
+		}
+		if ok && m.Route.Name != tt.route {
+			t.Fatalf("%s %s%s: expected route %s, got %s", tt.method, tt.host, tt.path, tt.route, m.Route.Name)
+		}
+	}
+}
+
+func TestRouterPathParams(t *testing.T) {
+	rt, err := NewRouter([]config.RouteConfig{
+		{Path: "/users/{id}/orders/{order}", Cluster: "users"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m, ok := rt.Match(httptest.NewRequest("GET", "/users/7/orders/99", nil))
+	if !ok {
+		t.Fatal("expected match")
+	}
+	if m.Params["id"] != "7" || m.Params["order"] != "99" {
+		t.Fatalf("unexpected params: %v", m.Params)
+	}
+}
+
+func TestRouterMalformedTemplate(t *testing.T) {
+	if _, err := NewRouter([]config.RouteConfig{{Path: "/users/{id", Cluster: "c"}}); err == nil {
+		t.Fatal("expected error for malformed template")
+	}
+}
+
+func TestRouterMatchGRPC(t *testing.T) {
+	rt, err := NewRouter([]config.RouteConfig{
+		{Name: "say-hello", Type: config.RouteTypeGRPC, GRPCService: "helloworld.Greeter", GRPCMethod: "SayHello", Cluster: "hello"},
+		{Name: "greeter", Type: config.RouteTypeGRPC, GRPCService: "helloworld.Greeter", Cluster: "greeter"},
+		{Name: "http", PathPrefix: "/", Cluster: "web"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		path, contentType string
+		route             string
+	}{
+		{"/helloworld.Greeter/SayHello", "application/grpc", "say-hello"},
+		{"/helloworld.Greeter/SayGoodbye", "application/grpc+proto", "greeter"},
+		{"/helloworld.Greeter/SayHello", "application/json", "http"},
+		{"/other.Service/Call", "application/grpc", "http"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, nil)
+		req.Header.Set("Content-Type", tt.contentType)
+		m, ok := rt.Match(req)
+		if !ok || m.Route.Name != tt.route {
+			t.Fatalf("%s (%s): expected route %s, got %+v", tt.path, tt.contentType, tt.route, m)
+		}
+	}
+}
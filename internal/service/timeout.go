@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestTimeoutHeader lets clients shorten a route's deadline. The gateway also sets
+// it on upstream requests to the remaining deadline in milliseconds.
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// ParseRequestTimeout parses an X-Request-Timeout value: a Go duration ("1.5s",
+// "250ms") or a bare number of milliseconds.
+func ParseRequestTimeout(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, ms > 0
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d > 0
+}
+
+// headerTimeout bounds the wait for upstream response headers.
+type headerTimeout struct {
+	timer  *time.Timer
+	cancel context.CancelCauseFunc
+}
+
+// withHeaderTimeout applies the matched route's response header timeout to req.
+// It returns nil when the route has none.
+func withHeaderTimeout(req *http.Request) (*http.Request, *headerTimeout) {
+	m, ok := RouteMatchFromContext(req.Context())
+	if !ok || m.Route.Timeout.ResponseHeaderMs <= 0 {
+		return req, nil
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	ht := &headerTimeout{cancel: cancel}
+	ht.timer = time.AfterFunc(time.Duration(m.Route.Timeout.ResponseHeaderMs)*time.Millisecond, func() {
+		cancel(ErrUpstreamHeaderTimeout)
+	})
+	return req.WithContext(ctx), ht
+}
+
+// headersReceived stops the timer, reporting false if it had already fired.
+func (h *headerTimeout) headersReceived() bool {
+	if h == nil {
+		return true
+	}
+	return h.timer.Stop()
+}
+
+// done releases the request context once the response is finished.
+func (h *headerTimeout) done() {
+	if h != nil {
+		h.timer.Stop()
+		h.cancel(nil)
+	}
+}
+
+// IsConnectTimeout reports whether err is a timed-out dial to an upstream.
+func IsConnectTimeout(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout()
+}
+
+// Timeout errors
+var (
+	ErrUpstreamHeaderTimeout = NewError("upstream_header_timeout", "upstream response header timeout")
+)
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"api-gateway/internal/config"
+)
+
+// ErrResponseTransform is returned by Transform.Response when a body cannot be
+// transformed; the response is then replaced by a 502 instead of leaking fields.
+var ErrResponseTransform = errors.New("response transform failed")
+
+// Transform is a compiled set of JSON response body operations.
+type Transform struct {
+	drop    []jsonPath
+	mask    []maskOp
+	rename  []renameOp
+	maxBody int64
+}
+
+type maskOp struct {
+	path        jsonPath
+	pattern     *regexp.Regexp // nil masks the whole value
+	replacement string
+}
+
+type renameOp struct {
+	path jsonPath
+	to   string
+}
+
+// NewTransform compiles a transform config.
+func NewTransform(cfg config.TransformConfig) (*Transform, error) {
+	cfg = cfg.WithDefaults()
+	t := &Transform{maxBody: cfg.MaxBodyBytes}
+	for _, p := range cfg.Drop {
+		path, err := parseJSONPath(p)
+		if err != nil {
+			return nil, fmt.Errorf("transform drop: %w", err)
+		}
+		t.drop = append(t.drop, path)
+	}
+	for _, m := range cfg.Mask {
+		path, err := parseJSONPath(m.Path)
+		if err != nil {
+			return nil, fmt.Errorf("transform mask: %w", err)
+		}
+		op := maskOp{path: path, replacement: m.Replacement}
+		if op.replacement == "" {
+			op.replacement = "****"
+		}
+		if m.Pattern != "" {
+			if op.pattern, err = regexp.Compile(m.Pattern); err != nil {
+				return nil, fmt.Errorf("transform mask %s: %w", m.Path, err)
+			}
+		}
+		t.mask = append(t.mask, op)
+	}
+	for _, r := range cfg.Rename {
+		path, err := parseJSONPath(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("transform rename: %w", err)
+		}
+		if r.To == "" {
+			return nil, fmt.Errorf("transform rename %s: empty target name", r.Path)
+		}
+		t.rename = append(t.rename, renameOp{path: path, to: r.To})
+	}
+	return t, nil
+}
+
+// PrepareRequest restricts the upstream response to encodings the transform can
+// read (gzip or identity) and drops Range, since partial bodies cannot be parsed.
+func (t *Transform) PrepareRequest(req *http.Request) {
+	req.Header.Del("Range")
+	if acceptsGzip(req.Header.Get("Accept-Encoding")) {
+		req.Header.Set("Accept-Encoding", "gzip")
+	} else {
+		// the transport then asks for gzip itself and decompresses transparently
+		req.Header.Del("Accept-Encoding")
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding value allows gzip.
+func acceptsGzip(v string) bool {
+	for _, part := range strings.Split(v, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := strings.TrimSpace(params)
+		if !strings.HasPrefix(strings.ToLower(q), "q=") {
+			return true
+		}
+		w, err := strconv.ParseFloat(q[2:], 64)
+		return err != nil || w > 0
+	}
+	return false
+}
+
+// Response applies the transform to a JSON response body, re-compressing gzip
+// bodies and fixing Content-Length. Non-JSON responses are left untouched.
+func (t *Transform) Response(resp *http.Response) error {
+	if !isJSONResponse(resp) {
+		return nil
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity", "gzip":
+	default:
+		return fmt.Errorf("%w: unsupported content encoding %q", ErrResponseTransform, encoding)
+	}
+	body := resp.Body
+	defer body.Close()
+	var r io.Reader = body
+	if encoding == "gzip" {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrResponseTransform, err)
+		}
+		r = zr
+	}
+	data, err := io.ReadAll(io.LimitReader(r, t.maxBody+1))
+	if err != nil {
+		return fmt.Errorf("%w: read body: %v", ErrResponseTransform, err)
+	}
+	if int64(len(data)) > t.maxBody {
+		return fmt.Errorf("%w: body exceeds %d bytes", ErrResponseTransform, t.maxBody)
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if data, err = t.Apply(data); err != nil {
+			return fmt.Errorf("%w: %v", ErrResponseTransform, err)
+		}
+	}
+	if encoding == "gzip" {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		data = buf.Bytes()
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// the representation changed, so it is no longer byte-identical
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+	return nil
+}
+
+func isJSONResponse(resp *http.Response) bool {
+	if resp.Body == nil || resp.Body == http.NoBody || resp.Request.Method == http.MethodHead ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && (mt == "application/json" || strings.HasSuffix(mt, "+json"))
+}
+
+// Apply transforms a JSON document. Numbers keep their original text; object
+// members are written in sorted order.
+func (t *Transform) Apply(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse json: %w", err)
+	}
+	if dec.More() {
+		return nil, errors.New("parse json: trailing data")
+	}
+	for _, p := range t.drop {
+		p.visit(doc, func(container any, key any) {
+			if obj, ok := container.(map[string]any); ok {
+				delete(obj, key.(string))
+			}
+		})
+	}
+	for _, m := range t.mask {
+		m.path.visit(doc, func(container any, key any) {
+			setMember(container, key, m.apply(member(container, key)))
+		})
+	}
+	for _, r := range t.rename {
+		r.path.visit(doc, func(container any, key any) {
+			obj, ok := container.(map[string]any)
+			if !ok || key.(string) == r.to {
+				return
+			}
+			obj[r.to] = obj[key.(string)]
+			delete(obj, key.(string))
+		})
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (m maskOp) apply(v any) any {
+	if v == nil {
+		return nil
+	}
+	if m.pattern != nil {
+		if s, ok := v.(string); ok {
+			return m.pattern.ReplaceAllString(s, m.replacement)
+		}
+	}
+	return m.replacement
+}
+
+func member(container any, key any) any {
+	switch c := container.(type) {
+	case map[string]any:
+		return c[key.(string)]
+	case []any:
+		return c[key.(int)]
+	}
+	return nil
+}
+
+func setMember(container any, key any, v any) {
+	switch c := container.(type) {
+	case map[string]any:
+		c[key.(string)] = v
+	case []any:
+		c[key.(int)] = v
+	}
+}
+
+// jsonPath is a compiled JSONPath subset: member names, indexes, wildcards and
+// recursive descent.
+type jsonPath []pathStep
+
+type pathStep struct {
+	name      string // member name
+	index     int    // array index when name is empty; negative counts from the end
+	wildcard  bool   // every member or element
+	recursive bool   // ".." descends into every level
+}
+
+// parseJSONPath compiles expressions like "$.a.b", "$.a[*].b", "$.a[2]", "$['a b']" and "$..b".
+func parseJSONPath(expr string) (jsonPath, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("json path %q must start with $", expr)
+	}
+	var path jsonPath
+	s := expr[1:]
+	for len(s) > 0 {
+		var step pathStep
+		switch {
+		case strings.HasPrefix(s, ".."):
+			step.recursive = true
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				break
+			}
+			fallthrough
+		case s[0] == '.':
+			if !step.recursive {
+				s = s[1:]
+			}
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("json path %q: empty member name", expr)
+			}
+			step.name, s = s[:end], s[end:]
+			if step.name == "*" {
+				step.name, step.wildcard = "", true
+			}
+			path = append(path, step)
+			continue
+		}
+		if !strings.HasPrefix(s, "[") {
+			return nil, fmt.Errorf("json path %q: unexpected %q", expr, s)
+		}
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return nil, fmt.Errorf("json path %q: missing ]", expr)
+		}
+		inner := s[1:end]
+		s = s[end+1:]
+		switch {
+		case inner == "*":
+			step.wildcard = true
+		case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+			step.name = inner[1 : len(inner)-1]
+		default:
+			i, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("json path %q: invalid index %q", expr, inner)
+			}
+			step.index = i
+		}
+		if step.name == "" && !step.wildcard && step.recursive {
+			return nil, fmt.Errorf("json path %q: recursive descent needs a name or *", expr)
+		}
+		path = append(path, step)
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("json path %q selects the whole document", expr)
+	}
+	return path, nil
+}
+
+// visit calls fn with the container and key (string for objects, int for arrays)
+// of every value the path selects in v.
+func (p jsonPath) visit(v any, fn func(container any, key any)) {
+	if len(p) == 0 {
+		return
+	}
+	step, rest := p[0], p[1:]
+	if step.recursive {
+		here := step
+		here.recursive = false
+		jsonPath(append([]pathStep{here}, rest...)).visit(v, fn)
+		for _, child := range children(v) {
+			p.visit(child, fn)
+		}
+		return
+	}
+	for _, k := range step.keys(v) {
+		if len(rest) == 0 {
+			fn(v, k)
+		} else {
+			rest.visit(member(v, k), fn)
+		}
+	}
+}
+
+// keys returns the keys of v matched by the step.
+func (s pathStep) keys(v any) []any {
+	switch c := v.(type) {
+	case map[string]any:
+		if s.wildcard {
+			out := make([]any, 0, len(c))
+			for k := range c {
+				out = append(out, k)
+			}
+			return out
+		}
+		if _, ok := c[s.name]; ok && s.name != "" {
+			return []any{s.name}
+		}
+	case []any:
+		if s.wildcard {
+			out := make([]any, len(c))
+			for i := range c {
+				out[i] = i
+			}
+			return out
+		}
+		i := s.index
+		if i < 0 {
+			i += len(c)
+		}
+		if s.name == "" && i >= 0 && i < len(c) {
+			return []any{i}
+		}
+	}
+	return nil
+}
+
+// children returns the member values of an object or array.
+func children(v any) []any {
+	switch c := v.(type) {
+	case map[string]any:
+		out := make([]any, 0, len(c))
+		for _, child := range c {
+			out = append(out, child)
+		}
+		return out
+	case []any:
+		return c
+	}
+	return nil
+}
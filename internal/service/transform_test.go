@@ -0,0 +1,159 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestTransformApply(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.TransformConfig
+		in   string
+		want string
+	}{
+		{"drop nested", config.TransformConfig{Drop: []string{"$.user.password", "$.debug"}},
+			`{"user":{"id":1,"password":"x"},"debug":true}`, `{"user":{"id":1}}`},
+		{"drop in every element", config.TransformConfig{Drop: []string{"$.items[*].internal"}},
+			`{"items":[{"id":1,"internal":"a"},{"id":2}]}`, `{"items":[{"id":1},{"id":2}]}`},
+		{"drop recursive", config.TransformConfig{Drop: []string{"$..token"}},
+			`{"token":"a","data":[{"token":"b","n":1}]}`, `{"data":[{"n":1}]}`},
+		{"mask whole value", config.TransformConfig{Mask: []config.MaskConfig{{Path: "$..ssn"}}},
+			`{"a":{"ssn":"123-45-6789"},"b":[{"ssn":123456789}],"c":{"ssn":null}}`,
+			`{"a":{"ssn":"****"},"b":[{"ssn":"****"}],"c":{"ssn":null}}`},
+		{"mask with pattern", config.TransformConfig{Mask: []config.MaskConfig{{Path: "$.users[*].email", Pattern: `^[^@]+`, Replacement: "***"}}},
+			`{"users":[{"email":"ada@example.com"},{"email":"bob@example.com"}]}`,
+			`{"users":[{"email":"***@example.com"},{"email":"***@example.com"}]}`},
+		{"rename", config.TransformConfig{Rename: []config.RenameConfig{{Path: "$.data['user_id']", To: "userId"}}},
+			`{"data":{"user_id":7}}`, `{"data":{"userId":7}}`},
+		{"operation order", config.TransformConfig{
+			Drop:   []string{"$.secret"},
+			Mask:   []config.MaskConfig{{Path: "$.email"}},
+			Rename: []config.RenameConfig{{Path: "$.email", To: "contact"}},
+		}, `{"secret":1,"email":"a@b.c"}`, `{"contact":"****"}`},
+		{"index and large numbers", config.TransformConfig{Drop: []string{"$[-1].id"}},
+			`[{"id":12345678901234567890},{"id":2,"v":"<b>"}]`, `[{"id":12345678901234567890},{"v":"<b>"}]`},
+		{"missing paths", config.TransformConfig{Drop: []string{"$.a.b.c", "$.list[5]"}},
+			`{"a":"scalar","list":[1]}`, `{"a":"scalar","list":[1]}`},
+	}
+	for _, tt := range tests {
+		tf, err := NewTransform(tt.cfg)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		got, err := tf.Apply([]byte(tt.in))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestNewTransformInvalid(t *testing.T) {
+	for _, cfg := range []config.TransformConfig{
+		{Drop: []string{"user.name"}},
+		{Drop: []string{"$"}},
+		{Drop: []string{"$.items[x]"}},
+		{Drop: []string{"$.items[0"}},
+		{Mask: []config.MaskConfig{{Path: "$.email", Pattern: "("}}},
+		{Rename: []config.RenameConfig{{Path: "$.a"}}},
+	} {
+		if _, err := NewTransform(cfg); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func transformResponse(body []byte, header http.Header) *http.Response {
+	header.Set("Content-Length", "999")
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: 999,
+		Request:       httptest.NewRequest("GET", "/", nil),
+	}
+}
+
+func TestTransformResponse(t *testing.T) {
+	tf, _ := NewTransform(config.TransformConfig{Drop: []string{"$.password"}, MaxBodyBytes: 64})
+
+	resp := transformResponse([]byte(`{"id":1,"password":"x"}`), http.Header{
+		"Content-Type": {"application/json; charset=utf-8"},
+		"Etag":         {`"v1"`},
+	})
+	if err := tf.Response(resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	if string(b) != `{"id":1}` || resp.ContentLength != 8 || resp.Header.Get("Content-Length") != "8" {
+		t.Fatalf("unexpected body %q with length %d/%s", b, resp.ContentLength, resp.Header.Get("Content-Length"))
+	}
+	if resp.Header.Get("ETag") != `W/"v1"` {
+		t.Errorf("expected a weak ETag, got %q", resp.Header.Get("ETag"))
+	}
+
+	var zbuf bytes.Buffer
+	zw := gzip.NewWriter(&zbuf)
+	zw.Write([]byte(`{"id":2,"password":"y"}`))
+	zw.Close()
+	resp = transformResponse(zbuf.Bytes(), http.Header{"Content-Type": {"application/problem+json"}, "Content-Encoding": {"gzip"}})
+	if err := tf.Response(resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("expected a gzip body: %v", err)
+	}
+	b, _ = io.ReadAll(zr)
+	if string(b) != `{"id":2}` {
+		t.Fatalf("unexpected body %q", b)
+	}
+
+	resp = transformResponse([]byte(`{"password":"x"}`), http.Header{"Content-Type": {"text/plain"}})
+	if err := tf.Response(resp); err != nil || resp.ContentLength != 999 {
+		t.Fatalf("expected non-JSON responses to pass through, got %v", err)
+	}
+
+	for _, resp := range []*http.Response{
+		transformResponse([]byte(`{"id":`), http.Header{"Content-Type": {"application/json"}}),
+		transformResponse([]byte(`{"pad":"`+strings.Repeat("x", 64)+`"}`), http.Header{"Content-Type": {"application/json"}}),
+		transformResponse([]byte(`{}`), http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"br"}}),
+	} {
+		if err := tf.Response(resp); !errors.Is(err, ErrResponseTransform) {
+			t.Errorf("expected ErrResponseTransform, got %v", err)
+		}
+	}
+}
+
+func TestTransformPrepareRequest(t *testing.T) {
+	tf, _ := NewTransform(config.TransformConfig{})
+	for accept, want := range map[string]string{
+		"gzip, deflate, br": "gzip",
+		"br;q=1, *;q=0.5":   "gzip",
+		"br":                "",
+		"gzip;q=0, br":      "",
+		"":                  "",
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		req.Header.Set("Range", "bytes=0-10")
+		tf.PrepareRequest(req)
+		if got := req.Header.Get("Accept-Encoding"); got != want {
+			t.Errorf("%q: expected Accept-Encoding %q, got %q", accept, want, got)
+		}
+		if req.Header.Get("Range") != "" {
+			t.Errorf("%q: expected Range to be removed", accept)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+)
+
+// newTransport returns the upstream transport of a cluster. Dials are bounded by
+// the connect timeout of the route matched for the request. reg may be nil.
+func newTransport(cfg config.ClusterConfig, reg *metrics.Registry) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	switch cfg.Protocol {
+	case config.ProtocolHTTP1:
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP1(true)
+	case config.ProtocolHTTP2:
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP2(true)
+	case config.ProtocolH2C:
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP2(true)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+	var tc config.TransportConfig
+	if cfg.Transport != nil {
+		tc = *cfg.Transport
+	}
+	if tc.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = tc.MaxIdleConnsPerHost
+	}
+	if tc.IdleConnTimeoutMs > 0 {
+		t.IdleConnTimeout = time.Duration(tc.IdleConnTimeoutMs) * time.Millisecond
+	}
+	tlsCfg, err := upstreamTLSConfig(tc)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", cfg.Name, err)
+	}
+	t.TLSClientConfig = tlsCfg
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if tc.DialTimeoutMs > 0 {
+		dialer.Timeout = time.Duration(tc.DialTimeoutMs) * time.Millisecond
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if m, ok := RouteMatchFromContext(ctx); ok && m.Route.Timeout.ConnectMs > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(m.Route.Timeout.ConnectMs)*time.Millisecond)
+			defer cancel()
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if reg == nil {
+			return conn, err
+		}
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				reg.UpstreamDialErrors.WithLabelValues(cfg.Name).Inc()
+			}
+			return nil, err
+		}
+		open := reg.UpstreamConnections.WithLabelValues(cfg.Name)
+		open.Inc()
+		return &countedConn{Conn: conn, closed: open.Dec}, nil
+	}
+	return t, nil
+}
+
+// upstreamTLSConfig builds the client TLS settings of a cluster, or nil for Go's defaults.
+func upstreamTLSConfig(tc config.TransportConfig) (*tls.Config, error) {
+	if tc.CAFile == "" && tc.CertFile == "" && tc.ServerName == "" && !tc.InsecureSkipVerify {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		ServerName:         tc.ServerName,
+		InsecureSkipVerify: tc.InsecureSkipVerify,
+	}
+	if tc.CAFile != "" {
+		data, err := os.ReadFile(tc.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read upstream ca: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("upstream ca %s: no certificates found", tc.CAFile)
+		}
+	}
+	if tc.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load upstream client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// countedConn calls closed once when the connection is closed.
+type countedConn struct {
+	net.Conn
+	once   sync.Once
+	closed func()
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(c.closed)
+	return c.Conn.Close()
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClusterTransportTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	client := ca.issue(t, 2, "gateway")
+	keyDER, err := x509.MarshalPKCS8PrivateKey(client.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: client.Certificate[0]}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	var protos []int
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "gateway" {
+			t.Errorf("expected the gateway client certificate, got %v", r.TLS.PeerCertificates)
+		}
+		protos = append(protos, r.ProtoMajor)
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	backend.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+	serverCA := filepath.Join(dir, "upstream-ca.pem")
+	os.WriteFile(serverCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}), 0o600)
+
+	newCluster := func(protocol string) *Cluster {
+		c, err := NewCluster(config.ClusterConfig{
+			Name:     "secure",
+			URL:      backend.URL,
+			Protocol: protocol,
+			Transport: &config.TransportConfig{
+				CAFile:              serverCA,
+				CertFile:            certFile,
+				KeyFile:             keyFile,
+				ServerName:          "example.com", // the httptest certificate's name
+				MaxIdleConnsPerHost: 4,
+			},
+		}, metrics.NewRegistry())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		protocol string
+		want     int
+	}{{"", 2}, {config.ProtocolHTTP1, 1}} {
+		protos = nil
+		c := newCluster(tc.protocol)
+		for i := 0; i < 2; i++ {
+			resp, err := c.RoundTrip(httptest.NewRequest("GET", "http://gateway/", nil))
+			if err != nil {
+				t.Fatalf("%q: round trip: %v", tc.protocol, err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if len(protos) != 2 || protos[0] != tc.want {
+			t.Errorf("%q: expected HTTP/%d, got %v", tc.protocol, tc.want, protos)
+		}
+		if got := testutil.ToFloat64(c.metrics.UpstreamConnections.WithLabelValues("secure")); got != 1 {
+			t.Errorf("%q: expected 1 open connection, got %v", tc.protocol, got)
+		}
+		if got := testutil.ToFloat64(c.metrics.UpstreamConnectionsUsed.WithLabelValues("secure", "true")); got != 1 {
+			t.Errorf("%q: expected the second request to reuse the connection, got %v", tc.protocol, got)
+		}
+	}
+
+	// without the upstream CA the handshake fails verification
+	c, err := NewCluster(config.ClusterConfig{Name: "plain", URL: backend.URL}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.RoundTrip(httptest.NewRequest("GET", "http://gateway/", nil)); err == nil {
+		t.Error("expected certificate verification to fail without ca_file")
+	}
+}
+
+func TestClusterTransportConfigErrors(t *testing.T) {
+	_, err := NewCluster(config.ClusterConfig{
+		Name:      "broken",
+		URL:       "https://upstream",
+		Transport: &config.TransportConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+	}, nil)
+	if err == nil {
+		t.Error("expected missing ca_file to fail")
+	}
+	rc := config.RoutesConfig{Clusters: []config.ClusterConfig{{
+		Name:      "half",
+		URL:       "https://upstream",
+		Transport: &config.TransportConfig{CertFile: "client.crt"},
+	}}}
+	if err := rc.Validate(); err == nil {
+		t.Error("expected cert_file without key_file to be rejected")
+	}
+}
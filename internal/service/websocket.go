@@ -0,0 +1,348 @@
+package service
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api-gateway/internal/config"
+)
+
+// WebSocket close reasons reported in metrics and logs.
+const (
+	WSCloseNormal      = "normal"
+	WSCloseIdle        = "idle_timeout"
+	WSCloseLifetime    = "max_lifetime"
+	WSCloseMessageRate = "message_rate"
+)
+
+// Close codes the gateway sends to clients (RFC 6455 section 7.4.1).
+const (
+	wsCloseGoingAway       = 1001
+	wsClosePolicyViolation = 1008
+)
+
+// WebSocket connection limit errors
+var (
+	ErrWebSocketRouteLimit = NewError("websocket_route_limit", "too many websocket connections on route")
+	ErrWebSocketKeyLimit   = NewError("websocket_key_limit", "too many websocket connections for client")
+)
+
+// IsWebSocketUpgrade reports whether r asks to switch to the WebSocket protocol.
+func IsWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, tok := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(tok), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocket enforces a route's WebSocket connection limits.
+type WebSocket struct {
+	cfg config.WebSocketConfig
+
+	mu     sync.Mutex
+	active int
+	perKey map[string]int
+}
+
+// NewWebSocket compiles a route's WebSocket config.
+func NewWebSocket(cfg config.WebSocketConfig) *WebSocket {
+	return &WebSocket{cfg: cfg, perKey: make(map[string]int)}
+}
+
+// Acquire reserves a connection slot for the client key. The returned release
+// func must be called once the connection ends.
+func (ws *WebSocket) Acquire(key string) (func(), error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.cfg.MaxConnections > 0 && ws.active >= ws.cfg.MaxConnections {
+		return nil, ErrWebSocketRouteLimit
+	}
+	if ws.cfg.MaxConnectionsPerKey > 0 && ws.perKey[key] >= ws.cfg.MaxConnectionsPerKey {
+		return nil, ErrWebSocketKeyLimit
+	}
+	ws.active++
+	ws.perKey[key]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ws.mu.Lock()
+			defer ws.mu.Unlock()
+			ws.active--
+			if ws.perKey[key]--; ws.perKey[key] <= 0 {
+				delete(ws.perKey, key)
+			}
+		})
+	}, nil
+}
+
+// Active returns the number of open connections on the route.
+func (ws *WebSocket) Active() int {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.active
+}
+
+// Wrap applies the idle, lifetime and message-rate limits to a hijacked client
+// connection. buffered holds client bytes already read past the handshake.
+func (ws *WebSocket) Wrap(conn net.Conn, buffered []byte) *WebSocketConn {
+	now := time.Now()
+	c := &WebSocketConn{
+		Conn:       conn,
+		cfg:        ws.cfg,
+		pending:    buffered,
+		start:      now,
+		done:       make(chan struct{}),
+		tokens:     messageBurst(ws.cfg.MaxMessagesPerSec),
+		lastRefill: now,
+	}
+	c.lastActive.Store(now.UnixNano())
+	go c.watch()
+	return c
+}
+
+func messageBurst(rate float64) float64 {
+	if rate < 1 {
+		return 1
+	}
+	return rate
+}
+
+// WebSocketConn is a client connection with WebSocket limits applied. Reads
+// carry client-to-upstream traffic and writes upstream-to-client traffic.
+type WebSocketConn struct {
+	net.Conn
+	cfg     config.WebSocketConfig
+	pending []byte
+	start   time.Time
+
+	wmu        sync.Mutex // serializes writes so close frames are not interleaved
+	bytesIn    atomic.Int64
+	bytesOut   atomic.Int64
+	lastActive atomic.Int64 // unix nanoseconds
+
+	// read side only
+	frames     frameParser
+	tokens     float64
+	lastRefill time.Time
+
+	closeOnce sync.Once
+	done      chan struct{}
+	mu        sync.Mutex
+	reason    string
+}
+
+func (c *WebSocketConn) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if len(c.pending) > 0 {
+		n = copy(p, c.pending)
+		c.pending = c.pending[n:]
+	} else {
+		n, err = c.Conn.Read(p)
+	}
+	if n > 0 {
+		c.lastActive.Store(time.Now().UnixNano())
+		c.bytesIn.Add(int64(n))
+		if msgs := c.frames.feed(p[:n]); msgs > 0 && !c.allowMessages(msgs) {
+			c.shutdown(WSCloseMessageRate, wsClosePolicyViolation)
+			return 0, net.ErrClosed
+		}
+	}
+	return n, err
+}
+
+func (c *WebSocketConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	n, err := c.Conn.Write(p)
+	c.wmu.Unlock()
+	if n > 0 {
+		c.lastActive.Store(time.Now().UnixNano())
+		c.bytesOut.Add(int64(n))
+	}
+	return n, err
+}
+
+// Close closes the connection without a close frame; the peers are expected
+// to have exchanged their own.
+func (c *WebSocketConn) Close() error {
+	return c.shutdown(WSCloseNormal, 0)
+}
+
+// Stats returns the bytes received from and sent to the client, and why the
+// connection was closed.
+func (c *WebSocketConn) Stats() (in, out int64, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytesIn.Load(), c.bytesOut.Load(), c.reason
+}
+
+// Duration returns how long the connection has been open.
+func (c *WebSocketConn) Duration() time.Duration {
+	return time.Since(c.start)
+}
+
+// allowMessages takes n messages from the connection's token bucket.
+func (c *WebSocketConn) allowMessages(n int) bool {
+	rate := c.cfg.MaxMessagesPerSec
+	if rate <= 0 {
+		return true
+	}
+	now := time.Now()
+	c.tokens = min(messageBurst(rate), c.tokens+now.Sub(c.lastRefill).Seconds()*rate)
+	c.lastRefill = now
+	if c.tokens < float64(n) {
+		return false
+	}
+	c.tokens -= float64(n)
+	return true
+}
+
+// shutdown closes the connection once, sending a close frame with code when non-zero.
+func (c *WebSocketConn) shutdown(reason string, code int) error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.reason = reason
+		c.mu.Unlock()
+		close(c.done)
+		if code != 0 {
+			// unblock a write stuck on a slow client before taking the write lock
+			c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+			c.wmu.Lock()
+			c.Conn.Write(closeFrame(code, reason))
+			c.wmu.Unlock()
+		}
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// watch closes the connection when the idle or lifetime timeout expires.
+func (c *WebSocketConn) watch() {
+	idle := time.Duration(c.cfg.IdleTimeoutMs) * time.Millisecond
+	lifetime := time.Duration(c.cfg.MaxLifetimeMs) * time.Millisecond
+	if idle <= 0 && lifetime <= 0 {
+		return
+	}
+	timer := time.NewTimer(c.nextDeadline(idle, lifetime))
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-timer.C:
+			if lifetime > 0 && now.Sub(c.start) >= lifetime {
+				c.shutdown(WSCloseLifetime, wsCloseGoingAway)
+				return
+			}
+			if idle > 0 && now.Sub(time.Unix(0, c.lastActive.Load())) >= idle {
+				c.shutdown(WSCloseIdle, wsCloseGoingAway)
+				return
+			}
+			timer.Reset(c.nextDeadline(idle, lifetime))
+		}
+	}
+}
+
+// nextDeadline returns the time until the earliest timeout could expire.
+func (c *WebSocketConn) nextDeadline(idle, lifetime time.Duration) time.Duration {
+	now := time.Now()
+	var d time.Duration = -1
+	if lifetime > 0 {
+		d = c.start.Add(lifetime).Sub(now)
+	}
+	if idle > 0 {
+		if i := time.Unix(0, c.lastActive.Load()).Add(idle).Sub(now); d < 0 || i < d {
+			d = i
+		}
+	}
+	return max(d, time.Millisecond)
+}
+
+// closeFrame builds an unmasked server close frame.
+func closeFrame(code int, reason string) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	f := make([]byte, 4, 4+len(reason))
+	f[0] = 0x88 // FIN + close opcode
+	f[1] = byte(2 + len(reason))
+	binary.BigEndian.PutUint16(f[2:], uint16(code))
+	return append(f, reason...)
+}
+
+// frameParser follows WebSocket frame boundaries across reads and counts
+// completed data messages.
+type frameParser struct {
+	hdr  [14]byte
+	hlen int    // header bytes collected so far
+	skip uint64 // payload bytes left in the current frame
+}
+
+func (f *frameParser) feed(b []byte) int {
+	messages := 0
+	for len(b) > 0 {
+		if f.skip > 0 {
+			n := min(uint64(len(b)), f.skip)
+			f.skip -= n
+			b = b[n:]
+			continue
+		}
+		for f.hlen < frameHeaderLen(f.hdr[:f.hlen]) && len(b) > 0 {
+			f.hdr[f.hlen] = b[0]
+			f.hlen++
+			b = b[1:]
+		}
+		if f.hlen < frameHeaderLen(f.hdr[:f.hlen]) {
+			break
+		}
+		// a FIN data or continuation frame completes a message; control frames don't count
+		if f.hdr[0]&0x80 != 0 && f.hdr[0]&0x0f < 0x8 {
+			messages++
+		}
+		f.skip = framePayloadLen(f.hdr[:f.hlen])
+		f.hlen = 0
+	}
+	return messages
+}
+
+// frameHeaderLen returns the header size implied by the bytes seen so far.
+func frameHeaderLen(h []byte) int {
+	if len(h) < 2 {
+		return 2
+	}
+	n := 2
+	switch h[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if h[1]&0x80 != 0 {
+		n += 4 // masking key
+	}
+	return n
+}
+
+func framePayloadLen(h []byte) uint64 {
+	switch l := h[1] & 0x7f; l {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(h[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(h[2:10])
+	default:
+		return uint64(l)
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+)
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	if !IsWebSocketUpgrade(r) {
+		t.Fatal("expected upgrade")
+	}
+	r.Header.Set("Connection", "keep-alive")
+	if IsWebSocketUpgrade(r) {
+		t.Fatal("upgrade requires Connection: upgrade")
+	}
+}
+
+func TestWebSocketAcquireLimits(t *testing.T) {
+	ws := NewWebSocket(config.WebSocketConfig{MaxConnections: 2, MaxConnectionsPerKey: 1})
+	rel1, err := ws.Acquire("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ws.Acquire("a"); !errors.Is(err, ErrWebSocketKeyLimit) {
+		t.Fatalf("expected key limit, got %v", err)
+	}
+	if _, err := ws.Acquire("b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ws.Acquire("c"); !errors.Is(err, ErrWebSocketRouteLimit) {
+		t.Fatalf("expected route limit, got %v", err)
+	}
+	rel1()
+	rel1() // idempotent
+	if ws.Active() != 1 {
+		t.Fatalf("expected 1 active connection, got %d", ws.Active())
+	}
+	if _, err := ws.Acquire("a"); err != nil {
+		t.Fatalf("expected slot after release, got %v", err)
+	}
+}
+
+func TestFrameParserCountsMessagesAcrossReads(t *testing.T) {
+	// masked text frame "hi" (FIN), then a ping, then a 200-byte binary frame
+	// with a 16-bit length split mid-header
+	text := []byte{0x81, 0x82, 1, 2, 3, 4, 'h' ^ 1, 'i' ^ 2}
+	ping := []byte{0x89, 0x80, 1, 2, 3, 4}
+	bin := append([]byte{0x82, 0xfe, 0x00, 200, 1, 2, 3, 4}, make([]byte, 200)...)
+	stream := append(append(append([]byte{}, text...), ping...), bin...)
+
+	var f frameParser
+	total := 0
+	for _, cut := range [][2]int{{0, 3}, {3, 16}, {16, 50}, {50, len(stream)}} {
+		total += f.feed(stream[cut[0]:cut[1]])
+	}
+	if total != 2 {
+		t.Fatalf("expected 2 messages, got %d", total)
+	}
+	if f.hlen != 0 || f.skip != 0 {
+		t.Fatalf("parser not at a frame boundary: %+v", f)
+	}
+}
+
+func TestWebSocketConnIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	ws := NewWebSocket(config.WebSocketConfig{IdleTimeoutMs: 50})
+	c := ws.Wrap(server, nil)
+
+	buf := make([]byte, 16)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("expected close frame, got %v", err)
+	}
+	if buf[0] != 0x88 || int(buf[2])<<8|int(buf[3]) != wsCloseGoingAway || n < 4 {
+		t.Fatalf("unexpected close frame %x", buf[:n])
+	}
+	if _, _, reason := c.Stats(); reason != WSCloseIdle {
+		t.Fatalf("expected idle_timeout, got %q", reason)
+	}
+}